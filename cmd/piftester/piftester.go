@@ -26,6 +26,7 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
 	piftester "github.com/blackducksoftware/perceptor-scanner/pkg/piftester"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
@@ -62,5 +63,6 @@ func main() {
 		http.ListenAndServe(addr, nil)
 	}()
 	log.Infof("Http server started! -- %+v", pifTester)
-	<-stop
+	common.WaitForShutdown(nil)
+	close(stop)
 }
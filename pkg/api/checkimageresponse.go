@@ -27,4 +27,16 @@ import "github.com/blackducksoftware/perceptor-scanner/pkg/common"
 type CheckImageResponse struct {
 	PullSpec    string
 	ImageStatus common.ImageStatus
+	// QueuePosition is where a queued pull is in the queue, starting from 1
+	QueuePosition int `json:",omitempty"`
+	// Digest is the image's digest, such as sha256:abc..., once it's been pulled.
+	// For pull specs with a tag, it's what the tag resolved to.
+	Digest string `json:",omitempty"`
+	// Platform is what the pulled image is built for, such as linux/arm64/v8,
+	// and ManifestDigest is the digest of its manifest.  For a multi-arch image,
+	// that's the platform's manifest, not the manifest list that Digest refers to.
+	Platform       string `json:",omitempty"`
+	ManifestDigest string `json:",omitempty"`
+	// Platforms are all the platforms of a multi-arch image
+	Platforms []string `json:",omitempty"`
 }
@@ -0,0 +1,61 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package api
+
+// InspectImageResponse describes a pulled image, as read from its tarball
+type InspectImageResponse struct {
+	PullSpec string
+	// ID is the digest of the image's config, which docker shows as the image ID
+	ID           string
+	OS           string
+	Architecture string
+	Variant      string `json:",omitempty"`
+	Entrypoint   []string
+	Cmd          []string
+	// Env has the image's environment variables with their values redacted,
+	// since they often hold credentials
+	Env          []string
+	Labels       map[string]string
+	ExposedPorts []string
+	User         string
+	WorkingDir   string
+	Layers       []*ImageLayer
+	History      []*ImageHistory
+	// Size is the total size of the layers in the tarball
+	Size int64
+}
+
+// ImageLayer is one of an image's layers: its diff ID, and its size in the
+// tarball
+type ImageLayer struct {
+	Digest string
+	Size   int64
+}
+
+// ImageHistory is the step of the image's build that made a layer, or only
+// changed its config
+type ImageHistory struct {
+	Created    string `json:",omitempty"`
+	CreatedBy  string `json:",omitempty"`
+	Comment    string `json:",omitempty"`
+	EmptyLayer bool   `json:",omitempty"`
+}
@@ -0,0 +1,137 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package cloudregistry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/juju/errors"
+)
+
+const (
+	azureDefaultAuthority = "https://login.microsoftonline.com"
+	azureResource         = "https://management.azure.com/"
+	// ACR takes refresh tokens as the password of this user
+	acrRefreshTokenUser = "00000000-0000-0000-0000-000000000000"
+	// how long a refresh token is assumed to last, if its expiry can't be read
+	acrDefaultTokenLife = time.Hour
+)
+
+// acrProvider gets an Azure AD access token for a service principal, and
+// exchanges it for an ACR refresh token
+type acrProvider struct {
+	config    *Config
+	registry  string
+	endpoint  string
+	authority string
+	client    *http.Client
+}
+
+func newACRProvider(config *Config, client *http.Client) (*acrProvider, error) {
+	if config.URL == "" || config.TenantID == "" || config.ClientID == "" || config.ClientSecret == "" {
+		return nil, fmt.Errorf("ACR needs the URL of the registry, and a tenant id, client id and client secret")
+	}
+	common.RegisterSecret(config.ClientSecret)
+	registry := strings.SplitN(strings.TrimPrefix(config.URL, "https://"), "/", 2)[0]
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + registry
+	}
+	authority := config.AuthorityEndpoint
+	if authority == "" {
+		authority = azureDefaultAuthority
+	}
+	return &acrProvider{config: config, registry: registry, endpoint: endpoint, authority: authority, client: client}, nil
+}
+
+// Name describes the provider for logging
+func (acr *acrProvider) Name() string {
+	return fmt.Sprintf("ACR %s", acr.registry)
+}
+
+// Credentials returns a refresh token as the password of the null user
+func (acr *acrProvider) Credentials() (*common.RegistryAuth, time.Time, error) {
+	now := time.Now()
+	var aadToken oauthTokenResponse
+	err := acr.postForm(fmt.Sprintf("%s/%s/oauth2/token", acr.authority, acr.config.TenantID), url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {acr.config.ClientID},
+		"client_secret": {acr.config.ClientSecret},
+		"resource":      {azureResource}}, &aadToken)
+	if err != nil {
+		return nil, time.Time{}, errors.Annotate(err, "Azure AD token request failed")
+	}
+	common.RegisterSecret(aadToken.AccessToken)
+
+	var exchange oauthTokenResponse
+	err = acr.postForm(acr.endpoint+"/oauth2/exchange", url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {acr.registry},
+		"tenant":       {acr.config.TenantID},
+		"access_token": {aadToken.AccessToken}}, &exchange)
+	if err != nil {
+		return nil, time.Time{}, errors.Annotate(err, "ACR token exchange failed")
+	}
+	if exchange.RefreshToken == "" {
+		return nil, time.Time{}, fmt.Errorf("ACR token exchange returned no refresh token")
+	}
+	expiry, ok := jwtExpiry(exchange.RefreshToken)
+	if !ok {
+		expiry = now.Add(acrDefaultTokenLife)
+	}
+	return &common.RegistryAuth{URL: acr.config.URL, User: acrRefreshTokenUser, Password: exchange.RefreshToken}, expiry, nil
+}
+
+func (acr *acrProvider) postForm(endpoint string, form url.Values, result interface{}) error {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Annotatef(err, "unable to create request to %s", endpoint)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doJSON(acr.client, req, result)
+}
+
+// jwtExpiry reads the exp claim of a JWT, without verifying it
+func jwtExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
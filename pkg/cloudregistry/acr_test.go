@@ -0,0 +1,73 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package cloudregistry
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestACRProviderExchangesServicePrincipal(t *testing.T) {
+	expiresAt := time.Now().Add(3 * time.Hour).Unix()
+	refreshToken := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, expiresAt))) + ".signature"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") != "client" || r.FormValue("client_secret") != "client-secret" || r.FormValue("grant_type") != "client_credentials" {
+			t.Errorf("unexpected Azure AD token request %+v", r.Form)
+		}
+		w.Write([]byte(`{"access_token":"aad-access-token","expires_in":"3599"}`))
+	})
+	mux.HandleFunc("/oauth2/exchange", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("access_token") != "aad-access-token" || r.FormValue("service") != "myregistry.azurecr.io" || r.FormValue("tenant") != "tenant" {
+			t.Errorf("unexpected ACR exchange request %+v", r.Form)
+		}
+		fmt.Fprintf(w, `{"refresh_token":"%s"}`, refreshToken)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider, err := NewProvider(&Config{
+		Type:              ProviderTypeACR,
+		URL:               "myregistry.azurecr.io",
+		Endpoint:          server.URL,
+		AuthorityEndpoint: server.URL,
+		TenantID:          "tenant",
+		ClientID:          "client",
+		ClientSecret:      "client-secret"})
+	if err != nil {
+		t.Fatalf("unable to create provider: %s", err.Error())
+	}
+	auth, expiry, err := provider.Credentials()
+	if err != nil {
+		t.Fatalf("unable to get credentials: %s", err.Error())
+	}
+	if auth.URL != "myregistry.azurecr.io" || auth.User != acrRefreshTokenUser || auth.Password != refreshToken {
+		t.Errorf("unexpected credentials %+v", auth)
+	}
+	if expiry.Unix() != expiresAt {
+		t.Errorf("expected expiry %d from the refresh token, got %d", expiresAt, expiry.Unix())
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package cloudregistry
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+)
+
+const (
+	// ProviderTypeECR exchanges AWS credentials for an ECR authorization token
+	ProviderTypeECR = "ecr"
+	// ProviderTypeGCP exchanges a GCP service account key for an access token,
+	// for Container Registry and Artifact Registry
+	ProviderTypeGCP = "gcp"
+	// ProviderTypeACR exchanges an Azure service principal for an ACR refresh token
+	ProviderTypeACR = "acr"
+
+	requestTimeout = 30 * time.Second
+)
+
+// Config configures the exchange of long-lived credentials for a cloud
+// registry's short-lived tokens.  Only the fields for its Type are used.
+type Config struct {
+	// Type is ecr, gcp or acr
+	Type string
+	// URL is what the credentials apply to, as in common.RegistryAuth, such
+	// as 123456789012.dkr.ecr.us-east-1.amazonaws.com or *.gcr.io.  For ECR,
+	// it defaults to the registry that the token is issued for.
+	URL string
+	// Endpoint replaces the provider's token endpoint, which is mostly
+	// useful for testing against a stub
+	Endpoint string
+
+	// ECR: if the access key isn't set, the AWS_ACCESS_KEY_ID,
+	// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables are used
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	RegistryID      string
+
+	// GCP
+	ServiceAccountKeyFile string
+
+	// ACR: the service principal's token is issued by AuthorityEndpoint,
+	// which defaults to Azure's public cloud
+	TenantID          string
+	ClientID          string
+	ClientSecret      string
+	AuthorityEndpoint string
+}
+
+// String shows the config with its secrets redacted
+func (config Config) String() string {
+	return fmt.Sprintf("{Type:%s URL:%s Endpoint:%s Region:%s AccessKeyID:%s SecretAccessKey:%s SessionToken:%s RegistryID:%s ServiceAccountKeyFile:%s TenantID:%s ClientID:%s ClientSecret:%s AuthorityEndpoint:%s}",
+		config.Type, config.URL, config.Endpoint, config.Region, config.AccessKeyID, common.RedactIfSet(config.SecretAccessKey),
+		common.RedactIfSet(config.SessionToken), config.RegistryID, config.ServiceAccountKeyFile, config.TenantID, config.ClientID,
+		common.RedactIfSet(config.ClientSecret), config.AuthorityEndpoint)
+}
+
+// Format keeps the secrets out of every fmt verb, including %#v
+func (config Config) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, config.String())
+}
+
+// NewProvider returns the credential provider for config's Type
+func NewProvider(config *Config) (common.CredentialProvider, error) {
+	client := &http.Client{Timeout: requestTimeout}
+	switch config.Type {
+	case ProviderTypeECR:
+		return newECRProvider(config, client)
+	case ProviderTypeGCP:
+		return newGCPProvider(config, client)
+	case ProviderTypeACR:
+		return newACRProvider(config, client)
+	}
+	return nil, fmt.Errorf("unknown cloud registry type %s", config.Type)
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package cloudregistry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/juju/errors"
+)
+
+const (
+	ecrService = "ecr"
+	ecrTarget  = "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken"
+)
+
+type ecrAuthorizationData struct {
+	AuthorizationToken string  `json:"authorizationToken"`
+	ExpiresAt          float64 `json:"expiresAt"`
+	ProxyEndpoint      string  `json:"proxyEndpoint"`
+}
+
+type ecrAuthorizationTokenResponse struct {
+	AuthorizationData []*ecrAuthorizationData `json:"authorizationData"`
+}
+
+// ecrProvider calls ECR's GetAuthorizationToken, signing the request with
+// the configured AWS credentials
+type ecrProvider struct {
+	config      *Config
+	credentials *awsCredentials
+	endpoint    string
+	client      *http.Client
+}
+
+func newECRProvider(config *Config, client *http.Client) (*ecrProvider, error) {
+	if config.Region == "" {
+		return nil, fmt.Errorf("ECR needs a region")
+	}
+	credentials := &awsCredentials{
+		accessKeyID:     config.AccessKeyID,
+		secretAccessKey: config.SecretAccessKey,
+		sessionToken:    config.SessionToken}
+	if credentials.accessKeyID == "" {
+		credentials = &awsCredentials{
+			accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN")}
+	}
+	if credentials.accessKeyID == "" || credentials.secretAccessKey == "" {
+		return nil, fmt.Errorf("ECR needs an access key id and secret access key")
+	}
+	common.RegisterSecret(credentials.secretAccessKey)
+	common.RegisterSecret(credentials.sessionToken)
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://api.ecr.%s.amazonaws.com", config.Region)
+	}
+	return &ecrProvider{config: config, credentials: credentials, endpoint: endpoint, client: client}, nil
+}
+
+// Name describes the provider for logging
+func (ecr *ecrProvider) Name() string {
+	return fmt.Sprintf("ECR in %s", ecr.config.Region)
+}
+
+// Credentials returns the user and password that an authorization token stands for
+func (ecr *ecrProvider) Credentials() (*common.RegistryAuth, time.Time, error) {
+	request := map[string][]string{}
+	if ecr.config.RegistryID != "" {
+		request["registryIds"] = []string{ecr.config.RegistryID}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, time.Time{}, errors.Trace(err)
+	}
+	req, err := http.NewRequest("POST", ecr.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, time.Time{}, errors.Annotate(err, "unable to create GetAuthorizationToken request")
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", ecrTarget)
+	signV4(req, body, ecr.credentials, ecrService, ecr.config.Region, time.Now())
+
+	var response ecrAuthorizationTokenResponse
+	if err := doJSON(ecr.client, req, &response); err != nil {
+		return nil, time.Time{}, errors.Annotate(err, "GetAuthorizationToken failed")
+	}
+	if len(response.AuthorizationData) == 0 {
+		return nil, time.Time{}, fmt.Errorf("GetAuthorizationToken returned no authorization data")
+	}
+	data := response.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(data.AuthorizationToken)
+	if err != nil {
+		return nil, time.Time{}, errors.Annotate(err, "unable to decode ECR authorization token")
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return nil, time.Time{}, fmt.Errorf("ECR authorization token isn't of the form user:password")
+	}
+	url := ecr.config.URL
+	if url == "" {
+		url = strings.TrimPrefix(data.ProxyEndpoint, "https://")
+	}
+	expiry := time.Unix(0, int64(data.ExpiresAt*float64(time.Second)))
+	return &common.RegistryAuth{URL: url, User: parts[0], Password: parts[1]}, expiry, nil
+}
+
+// doJSON sends req, and decodes a successful response's JSON body into result
+func doJSON(client *http.Client, req *http.Request, result interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Annotate(err, "unable to read response body")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, common.Redact(string(bodyBytes)))
+	}
+	return errors.Annotate(json.Unmarshal(bodyBytes, result), "unable to decode response")
+}
@@ -0,0 +1,81 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package cloudregistry
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestECRProviderExchangesAWSCredentials(t *testing.T) {
+	expiresAt := time.Now().Add(12 * time.Hour).Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != ecrTarget {
+			t.Errorf("expected target %s, got %s", ecrTarget, r.Header.Get("X-Amz-Target"))
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/ecr/aws4_request") {
+			t.Errorf("expected a signed request, got authorization %s", r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("X-Amz-Security-Token") != "session" {
+			t.Errorf("expected session token, got %s", r.Header.Get("X-Amz-Security-Token"))
+		}
+		token := base64.StdEncoding.EncodeToString([]byte("AWS:ecr-password"))
+		fmt.Fprintf(w, `{"authorizationData":[{"authorizationToken":"%s","expiresAt":%d.5,"proxyEndpoint":"https://123.dkr.ecr.us-east-1.amazonaws.com"}]}`, token, expiresAt)
+	}))
+	defer server.Close()
+
+	provider, err := NewProvider(&Config{Type: ProviderTypeECR, Region: "us-east-1", AccessKeyID: "AKID", SecretAccessKey: "secret-key", SessionToken: "session", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("unable to create provider: %s", err.Error())
+	}
+	auth, expiry, err := provider.Credentials()
+	if err != nil {
+		t.Fatalf("unable to get credentials: %s", err.Error())
+	}
+	if auth.URL != "123.dkr.ecr.us-east-1.amazonaws.com" || auth.User != "AWS" || auth.Password != "ecr-password" {
+		t.Errorf("unexpected credentials %+v", auth)
+	}
+	if expiry.Unix() != expiresAt {
+		t.Errorf("expected expiry %d, got %d", expiresAt, expiry.Unix())
+	}
+}
+
+func TestECRProviderReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"__type":"UnrecognizedClientException"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	provider, err := NewProvider(&Config{Type: ProviderTypeECR, Region: "us-east-1", AccessKeyID: "AKID", SecretAccessKey: "secret-key", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("unable to create provider: %s", err.Error())
+	}
+	if _, _, err := provider.Credentials(); err == nil || !strings.Contains(err.Error(), "UnrecognizedClientException") {
+		t.Errorf("expected the error from ECR, got %v", err)
+	}
+}
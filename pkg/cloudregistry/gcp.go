@@ -0,0 +1,190 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package cloudregistry
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/juju/errors"
+)
+
+const (
+	gcpDefaultTokenURI = "https://oauth2.googleapis.com/token"
+	gcpScope           = "https://www.googleapis.com/auth/cloud-platform"
+	gcpJWTGrantType    = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	gcpAssertionLife   = time.Hour
+	// Container Registry and Artifact Registry take access tokens as the
+	// password of this user
+	gcpAccessTokenUser = "oauth2accesstoken"
+)
+
+// gcpServiceAccountKey is the JSON key file of a GCP service account
+type gcpServiceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string  `json:"access_token"`
+	RefreshToken string  `json:"refresh_token"`
+	ExpiresIn    seconds `json:"expires_in"`
+}
+
+// seconds is a number of seconds that comes as a JSON number, or as a string
+// from Azure AD
+type seconds int
+
+// UnmarshalJSON accepts both forms
+func (s *seconds) UnmarshalJSON(data []byte) error {
+	value, err := strconv.Atoi(strings.Trim(string(data), `"`))
+	if err != nil {
+		return errors.Annotatef(err, "unable to parse seconds %s", string(data))
+	}
+	*s = seconds(value)
+	return nil
+}
+
+// gcpProvider exchanges a JWT, signed with a service account's key, for an
+// access token
+type gcpProvider struct {
+	config      *Config
+	clientEmail string
+	privateKey  *rsa.PrivateKey
+	tokenURI    string
+	client      *http.Client
+}
+
+func newGCPProvider(config *Config, client *http.Client) (*gcpProvider, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("GCP needs the URL of the registry")
+	}
+	bytes, err := ioutil.ReadFile(config.ServiceAccountKeyFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to read GCP service account key %s", config.ServiceAccountKeyFile)
+	}
+	var key gcpServiceAccountKey
+	if err := json.Unmarshal(bytes, &key); err != nil {
+		return nil, errors.Annotatef(err, "unable to parse GCP service account key %s", config.ServiceAccountKeyFile)
+	}
+	privateKey, err := parseRSAPrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to parse private key of GCP service account key %s", config.ServiceAccountKeyFile)
+	}
+	tokenURI := config.Endpoint
+	if tokenURI == "" {
+		tokenURI = key.TokenURI
+	}
+	if tokenURI == "" {
+		tokenURI = gcpDefaultTokenURI
+	}
+	return &gcpProvider{config: config, clientEmail: key.ClientEmail, privateKey: privateKey, tokenURI: tokenURI, client: client}, nil
+}
+
+// Name describes the provider for logging
+func (gcp *gcpProvider) Name() string {
+	return fmt.Sprintf("GCP service account %s", gcp.clientEmail)
+}
+
+// Credentials returns an access token as the password of oauth2accesstoken
+func (gcp *gcpProvider) Credentials() (*common.RegistryAuth, time.Time, error) {
+	now := time.Now()
+	assertion, err := gcp.assertion(now)
+	if err != nil {
+		return nil, time.Time{}, errors.Trace(err)
+	}
+	form := url.Values{"grant_type": {gcpJWTGrantType}, "assertion": {assertion}}
+	req, err := http.NewRequest("POST", gcp.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, time.Time{}, errors.Annotate(err, "unable to create token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var response oauthTokenResponse
+	if err := doJSON(gcp.client, req, &response); err != nil {
+		return nil, time.Time{}, errors.Annotate(err, "GCP token request failed")
+	}
+	if response.AccessToken == "" {
+		return nil, time.Time{}, fmt.Errorf("GCP token response has no access token")
+	}
+	expiry := now.Add(time.Duration(response.ExpiresIn) * time.Second)
+	return &common.RegistryAuth{URL: gcp.config.URL, User: gcpAccessTokenUser, Password: response.AccessToken}, expiry, nil
+}
+
+// assertion returns a JWT that asks for an access token for the service account
+func (gcp *gcpProvider) assertion(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   gcp.clientEmail,
+		"scope": gcpScope,
+		"aud":   gcp.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(gcpAssertionLife).Unix()})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, gcp.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", errors.Annotate(err, "unable to sign JWT")
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parseRSAPrivateKey reads a PEM encoded RSA key, in PKCS #8 as GCP issues
+// them, or PKCS #1
+func parseRSAPrivateKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key isn't an RSA key")
+	}
+	return key, nil
+}
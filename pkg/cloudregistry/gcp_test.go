@@ -0,0 +1,103 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package cloudregistry
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGCPProviderExchangesServiceAccountKey(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err.Error())
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != gcpJWTGrantType {
+			t.Errorf("expected grant type %s, got %s", gcpJWTGrantType, r.FormValue("grant_type"))
+		}
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if len(parts) != 3 {
+			t.Fatalf("expected a JWT, got %s", r.FormValue("assertion"))
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, hashed[:], signature); err != nil {
+			t.Errorf("expected JWT to be signed with the service account key: %s", err.Error())
+		}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claims map[string]interface{}
+		json.Unmarshal(payload, &claims)
+		if claims["iss"] != "scanner@project.iam.gserviceaccount.com" || claims["scope"] != gcpScope {
+			t.Errorf("unexpected claims %+v", claims)
+		}
+		w.Write([]byte(`{"access_token":"gcp-access-token","expires_in":3600,"token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gcp")
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("unable to marshal key: %s", err.Error())
+	}
+	key, _ := json.Marshal(&gcpServiceAccountKey{
+		ClientEmail: "scanner@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})),
+		TokenURI:    server.URL})
+	keyFile := filepath.Join(dir, "key.json")
+	if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+		t.Fatalf("unable to write key: %s", err.Error())
+	}
+
+	provider, err := NewProvider(&Config{Type: ProviderTypeGCP, URL: "*.gcr.io", ServiceAccountKeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("unable to create provider: %s", err.Error())
+	}
+	auth, expiry, err := provider.Credentials()
+	if err != nil {
+		t.Fatalf("unable to get credentials: %s", err.Error())
+	}
+	if auth.URL != "*.gcr.io" || auth.User != gcpAccessTokenUser || auth.Password != "gcp-access-token" {
+		t.Errorf("unexpected credentials %+v", auth)
+	}
+	if expiry.Before(time.Now().Add(59*time.Minute)) || expiry.After(time.Now().Add(time.Hour)) {
+		t.Errorf("expected expiry in an hour, got %s", expiry)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package cloudregistry
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	amzDayFormat    = "20060102"
+	amzDateHeader   = "X-Amz-Date"
+	amzTokenHeader  = "X-Amz-Security-Token"
+	sigV4Terminator = "aws4_request"
+)
+
+// awsCredentials are what requests to AWS are signed with
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// signV4 adds AWS Signature Version 4 headers to req, whose body is body.
+// The host and every header that's already set are signed.
+func signV4(req *http.Request, body []byte, credentials *awsCredentials, service string, region string, now time.Time) {
+	now = now.UTC()
+	req.Header.Set(amzDateHeader, now.Format(amzDateFormat))
+	if credentials.sessionToken != "" {
+		req.Header.Set(amzTokenHeader, credentials.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + strings.TrimSpace(headers[name]) + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req),
+		canonicalHeaders,
+		signedHeaders,
+		sha256Hex(body)}, "\n")
+
+	scope := strings.Join([]string{now.Format(amzDayFormat), region, service, sigV4Terminator}, "/")
+	stringToSign := strings.Join([]string{sigV4Algorithm, now.Format(amzDateFormat), scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.secretAccessKey), now.Format(amzDayFormat))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, sigV4Terminator)
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, credentials.accessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	keys := []string{}
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything but the unreserved characters
+func awsEscape(s string) string {
+	escaped := ""
+	for _, b := range []byte(s) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') || strings.IndexByte("-_.~", b) >= 0 {
+			escaped += string(b)
+		} else {
+			escaped += fmt.Sprintf("%%%02X", b)
+		}
+	}
+	return escaped
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
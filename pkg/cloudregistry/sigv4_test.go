@@ -0,0 +1,53 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package cloudregistry
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestSignV4 checks against AWS's published Signature Version 4 test suite
+func TestSignV4(t *testing.T) {
+	credentials := &awsCredentials{accessKeyID: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	testCases := []struct {
+		name      string
+		url       string
+		signature string
+	}{
+		{"get-vanilla", "https://example.amazonaws.com/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", "https://example.amazonaws.com/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	}
+	for _, testCase := range testCases {
+		req, err := http.NewRequest("GET", testCase.url, nil)
+		if err != nil {
+			t.Fatalf("unable to create request: %s", err.Error())
+		}
+		signV4(req, []byte{}, credentials, "service", "us-east-1", now)
+		expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=" + testCase.signature
+		if actual := req.Header.Get("Authorization"); actual != expected {
+			t.Errorf("%s: expected %s, got %s", testCase.name, expected, actual)
+		}
+	}
+}
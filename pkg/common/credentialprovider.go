@@ -0,0 +1,114 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// credentials are renewed this long before they expire, so that a pull that
+// starts just before the expiry doesn't fail halfway through
+const credentialRenewalMargin = 5 * time.Minute
+
+// after a provider fails, it isn't asked again for a while, so that a token
+// endpoint that's down or slow to time out doesn't hold up every pull.  The
+// wait doubles with each failure in a row, up to the maximum.
+const (
+	minCredentialRetryBackoff = 10 * time.Second
+	maxCredentialRetryBackoff = 5 * time.Minute
+)
+
+// CredentialProvider exchanges long-lived credentials, such as a cloud
+// service account, for short-lived registry credentials
+type CredentialProvider interface {
+	// Name describes the provider for logging
+	Name() string
+	// Credentials returns fresh credentials, and when they expire
+	Credentials() (*RegistryAuth, time.Time, error)
+}
+
+// cachedCredentials keeps a provider's credentials until they're about to
+// expire, and its last failure until it's time to try again
+type cachedCredentials struct {
+	provider CredentialProvider
+	auth     *RegistryAuth
+	expiry   time.Time
+	err      error
+	backoff  time.Duration
+	retryAt  time.Time
+	mutex    sync.Mutex
+}
+
+// get returns the cached credentials, renewing them if need be.  If they
+// can't be renewed, the old ones are used for as long as they're valid, and
+// the provider isn't asked again until its backoff is up.
+func (cc *cachedCredentials) get() (*RegistryAuth, error) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	now := time.Now()
+	if cc.auth != nil && now.Add(credentialRenewalMargin).Before(cc.expiry) {
+		return cc.auth, nil
+	}
+	if now.Before(cc.retryAt) {
+		return cc.fallback(now)
+	}
+	auth, expiry, err := cc.provider.Credentials()
+	if err != nil {
+		RecordEvent("unable to renew registry credentials")
+		cc.backoff *= 2
+		if cc.backoff < minCredentialRetryBackoff {
+			cc.backoff = minCredentialRetryBackoff
+		} else if cc.backoff > maxCredentialRetryBackoff {
+			cc.backoff = maxCredentialRetryBackoff
+		}
+		cc.retryAt = now.Add(cc.backoff)
+		cc.err = errors.Annotatef(err, "unable to get credentials from %s", cc.provider.Name())
+		if cc.auth != nil && now.Before(cc.expiry) {
+			log.Warnf("unable to renew credentials from %s, using the old ones until %s: %s", cc.provider.Name(), cc.expiry, err.Error())
+		}
+		return cc.fallback(now)
+	}
+	cc.err = nil
+	cc.backoff = 0
+	cc.retryAt = time.Time{}
+	RegisterSecret(auth.Password)
+	RegisterSecret(auth.IdentityToken)
+	RegisterSecret(auth.RegistryToken)
+	RecordEvent("renew registry credentials")
+	log.Infof("renewed credentials for %s from %s, valid until %s", auth.URL, cc.provider.Name(), expiry)
+	cc.auth = auth
+	cc.expiry = expiry
+	return auth, nil
+}
+
+// fallback returns the old credentials while they're valid, and otherwise the
+// last failure
+func (cc *cachedCredentials) fallback(now time.Time) (*RegistryAuth, error) {
+	if cc.auth != nil && now.Before(cc.expiry) {
+		return cc.auth, nil
+	}
+	return nil, cc.err
+}
@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeCredentialProvider struct {
+	calls  int
+	expiry time.Time
+	err    error
+}
+
+func (fcp *fakeCredentialProvider) Name() string {
+	return "fake"
+}
+
+func (fcp *fakeCredentialProvider) Credentials() (*RegistryAuth, time.Time, error) {
+	fcp.calls++
+	if fcp.err != nil {
+		return nil, time.Time{}, fcp.err
+	}
+	return &RegistryAuth{URL: "registry.io", User: "token", Password: fmt.Sprintf("token-%d", fcp.calls)}, fcp.expiry, nil
+}
+
+func RunCredentialProviderTests() {
+	Describe("RegistryCredentials with providers", func() {
+		It("should cache credentials until they're about to expire", func() {
+			provider := &fakeCredentialProvider{expiry: time.Now().Add(time.Hour)}
+			credentials := NewRegistryCredentials(nil, nil)
+			credentials.AddProvider(provider)
+			Expect(credentials.Registries()[0].Password).To(Equal("token-1"))
+			Expect(credentials.Registries()[0].Password).To(Equal("token-1"))
+			Expect(provider.calls).To(Equal(1))
+
+			provider.expiry = time.Now().Add(credentialRenewalMargin / 2)
+			credentials.providers[0].expiry = provider.expiry
+			Expect(credentials.Registries()[0].Password).To(Equal("token-2"))
+		})
+		It("should keep using credentials that can't be renewed until they expire", func() {
+			provider := &fakeCredentialProvider{expiry: time.Now().Add(credentialRenewalMargin / 2)}
+			credentials := NewRegistryCredentials(nil, nil)
+			credentials.AddProvider(provider)
+			Expect(credentials.Registries()[0].Password).To(Equal("token-1"))
+
+			provider.err = fmt.Errorf("token endpoint is down")
+			Expect(credentials.Registries()[0].Password).To(Equal("token-1"))
+
+			credentials.providers[0].expiry = time.Now().Add(-time.Second)
+			Expect(credentials.Registries()).To(BeEmpty())
+		})
+		It("should back off from a provider that fails", func() {
+			provider := &fakeCredentialProvider{err: fmt.Errorf("token endpoint is down")}
+			credentials := NewRegistryCredentials(nil, nil)
+			credentials.AddProvider(provider)
+			Expect(credentials.Registries()).To(BeEmpty())
+			Expect(credentials.Registries()).To(BeEmpty())
+			Expect(provider.calls).To(Equal(1))
+			Expect(credentials.providers[0].backoff).To(Equal(minCredentialRetryBackoff))
+
+			credentials.providers[0].retryAt = time.Now().Add(-time.Second)
+			Expect(credentials.Registries()).To(BeEmpty())
+			Expect(provider.calls).To(Equal(2))
+			Expect(credentials.providers[0].backoff).To(Equal(2 * minCredentialRetryBackoff))
+
+			provider.err = nil
+			provider.expiry = time.Now().Add(time.Hour)
+			credentials.providers[0].retryAt = time.Now().Add(-time.Second)
+			Expect(credentials.Registries()[0].Password).To(Equal("token-3"))
+			Expect(credentials.providers[0].backoff).To(Equal(time.Duration(0)))
+		})
+	})
+}
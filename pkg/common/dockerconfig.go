@@ -0,0 +1,100 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// dockerConfigEntry is a registry's entry in a docker config file
+type dockerConfigEntry struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+// ParseDockerConfig reads registry credentials from the contents of a
+// ~/.docker/config.json file or a kubernetes.io/dockerconfigjson secret,
+// which both keep them under "auths".  The older format of ~/.dockercfg and
+// kubernetes.io/dockercfg secrets, without "auths", is accepted too.
+func ParseDockerConfig(data []byte) ([]*RegistryAuth, error) {
+	var config struct {
+		Auths map[string]*dockerConfigEntry `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.Annotate(err, "unable to parse docker config")
+	}
+	entries := config.Auths
+	if entries == nil {
+		entries = map[string]*dockerConfigEntry{}
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, errors.Annotate(err, "unable to parse docker config")
+		}
+	}
+	registries := []*RegistryAuth{}
+	for key, entry := range entries {
+		if entry == nil {
+			continue
+		}
+		registry := &RegistryAuth{
+			URL:           normalizeRegistryURL(key),
+			User:          entry.Username,
+			Password:      entry.Password,
+			IdentityToken: entry.IdentityToken,
+			RegistryToken: entry.RegistryToken}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, errors.Annotatef(err, "unable to decode auth for registry %s", key)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, errors.Errorf("auth for registry %s isn't of the form username:password", key)
+			}
+			registry.User = parts[0]
+			registry.Password = parts[1]
+		}
+		RegisterSecret(registry.Password)
+		RegisterSecret(registry.IdentityToken)
+		RegisterSecret(registry.RegistryToken)
+		registries = append(registries, registry)
+	}
+	return registries, nil
+}
+
+// normalizeRegistryURL turns a docker config key, which may be a URL such as
+// https://index.docker.io/v1/, into the registry prefix of pull specs
+func normalizeRegistryURL(key string) string {
+	url := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	url = strings.TrimRight(url, "/")
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/v1"), "/v2")
+	if url == "index.docker.io" || url == "registry-1.docker.io" {
+		return "docker.io"
+	}
+	return url
+}
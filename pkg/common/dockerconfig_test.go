@@ -0,0 +1,98 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func RunDockerConfigTests() {
+	Describe("ParseDockerConfig", func() {
+		It("should decode auths entries", func() {
+			auth := base64.StdEncoding.EncodeToString([]byte("me:pass:w0rd-1"))
+			registries, err := ParseDockerConfig([]byte(`{"auths": {
+				"https://index.docker.io/v1/": {"auth": "` + auth + `"},
+				"gcr.io": {"username": "_json_key", "password": "k3y-json"},
+				"registry.example.com:5000": {"identitytoken": "refr3sh-token"},
+				"registry.example.com": {"registrytoken": "b3arer-token"}}}`))
+			Expect(err).To(BeNil())
+			Expect(registries).To(ConsistOf(
+				&RegistryAuth{URL: "docker.io", User: "me", Password: "pass:w0rd-1"},
+				&RegistryAuth{URL: "gcr.io", User: "_json_key", Password: "k3y-json"},
+				&RegistryAuth{URL: "registry.example.com:5000", IdentityToken: "refr3sh-token"},
+				&RegistryAuth{URL: "registry.example.com", RegistryToken: "b3arer-token"}))
+		})
+		It("should accept the older format without auths", func() {
+			registries, err := ParseDockerConfig([]byte(`{"quay.io": {"username": "me", "password": "w0rd-2"}}`))
+			Expect(err).To(BeNil())
+			Expect(registries).To(ConsistOf(&RegistryAuth{URL: "quay.io", User: "me", Password: "w0rd-2"}))
+		})
+		It("should reject auth that isn't username:password", func() {
+			auth := base64.StdEncoding.EncodeToString([]byte("nocolon"))
+			_, err := ParseDockerConfig([]byte(`{"auths": {"quay.io": {"auth": "` + auth + `"}}}`))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("RegistryCredentials", func() {
+		It("should reload docker config files when they change", func() {
+			dir, err := ioutil.TempDir("", "dockerconfig")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "config.json")
+			static := &RegistryAuth{URL: "static:5000", User: "a", Password: "b"}
+
+			credentials := NewRegistryCredentials([]*RegistryAuth{static}, []string{path})
+			Expect(credentials.Registries()).To(Equal([]*RegistryAuth{static}))
+
+			stop := make(chan struct{})
+			defer close(stop)
+			Expect(credentials.Watch(stop)).To(BeNil())
+
+			Expect(ioutil.WriteFile(path, []byte(`{"auths": {"quay.io": {"username": "me", "password": "first-pw1"}}}`), 0600)).To(BeNil())
+			Eventually(credentials.Registries, 5*time.Second).Should(Equal([]*RegistryAuth{
+				{URL: "quay.io", User: "me", Password: "first-pw1"}, static}))
+
+			// a secret being rotated: the new file replaces the old one
+			newPath := filepath.Join(dir, "config.json.new")
+			Expect(ioutil.WriteFile(newPath, []byte(`{"auths": {"quay.io": {"username": "me", "password": "second-pw2"}}}`), 0600)).To(BeNil())
+			Expect(os.Rename(newPath, path)).To(BeNil())
+			Eventually(credentials.Registries, 5*time.Second).Should(Equal([]*RegistryAuth{
+				{URL: "quay.io", User: "me", Password: "second-pw2"}, static}))
+
+			// unparseable contents keep the last good credentials
+			Expect(ioutil.WriteFile(path, []byte(`{"auths":`), 0600)).To(BeNil())
+			Consistently(credentials.Registries, 500*time.Millisecond).Should(Equal([]*RegistryAuth{
+				{URL: "quay.io", User: "me", Password: "second-pw2"}, static}))
+
+			Expect(os.Remove(path)).To(BeNil())
+			Eventually(credentials.Registries, 5*time.Second).Should(Equal([]*RegistryAuth{static}))
+		})
+	})
+}
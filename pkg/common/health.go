@@ -0,0 +1,101 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WriteHealthResponse responds with 200 if every check passed, and with 503
+// otherwise.  The body lists the result of each check; a nil error means that
+// the check passed.
+func WriteHealthResponse(w http.ResponseWriter, results map[string]error) {
+	statusCode := http.StatusOK
+	body := map[string]string{}
+	for name, err := range results {
+		if err != nil {
+			statusCode = http.StatusServiceUnavailable
+			body[name] = err.Error()
+			log.Warnf("health check %s failed: %s", name, err.Error())
+		} else {
+			body[name] = "ok"
+		}
+	}
+	jsonBytes, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	header := w.Header()
+	header.Set(http.CanonicalHeaderKey("content-type"), "application/json")
+	w.WriteHeader(statusCode)
+	fmt.Fprint(w, string(jsonBytes))
+}
+
+// CheckDirectoryWritable checks that a file can be created in a directory
+func CheckDirectoryWritable(directory string) error {
+	f, err := ioutil.TempFile(directory, ".healthcheck")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %s", directory, err.Error())
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// PingRegistry checks that a docker registry answers on its /v2/ endpoint.  Any
+// HTTP response counts, since most registries answer 401 without credentials.
+// Like the image pullers, it only falls back to plain HTTP for insecure registries.
+func PingRegistry(registryURL string, tlsConfig *tls.Config, timeout time.Duration) error {
+	pattern := parseRegistryPattern(registryURL)
+	host := pattern.host
+	if pattern.port != "" {
+		host = net.JoinHostPort(pattern.host, pattern.port)
+	}
+	// each probe has a transport of its own, which mustn't keep connections
+	// open once it's done
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+	schemes := []string{"https"}
+	if tlsConfig.InsecureSkipVerify {
+		schemes = append(schemes, "http")
+	}
+	var err error
+	for _, scheme := range schemes {
+		var resp *http.Response
+		resp, err = client.Get(fmt.Sprintf("%s://%s/v2/", scheme, host))
+		if err == nil {
+			resp.Body.Close()
+			return nil
+		}
+	}
+	return fmt.Errorf("registry %s is unreachable: %s", host, err.Error())
+}
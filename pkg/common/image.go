@@ -30,6 +30,13 @@ import (
 type Image struct {
 	Directory string
 	PullSpec  string
+	// Platform selects the image of a multi-arch pull spec, as in linux/arm64;
+	// if it's empty, the image facade's default platform is pulled
+	Platform string `json:",omitempty"`
+	// Priority orders queued pulls: higher priority pulls start first.  It
+	// isn't part of the image's ID, so it doesn't keep requests from being
+	// coalesced.
+	Priority int `json:",omitempty"`
 }
 
 // NewImage ...
@@ -42,10 +49,41 @@ func (image *Image) DockerPullSpec() string {
 	return image.PullSpec
 }
 
+// ID identifies the pull: the pull spec, and the platform if one was selected
+func (image *Image) ID() string {
+	if image.Platform == "" {
+		return image.PullSpec
+	}
+	return image.PullSpec + " " + image.Platform
+}
+
 // DockerTarFilePath ...
 func (image *Image) DockerTarFilePath() string {
 	imagePullSpec := strings.Replace(image.PullSpec, "/", "_", -1)
 	imagePullSpec = strings.Replace(imagePullSpec, "@", "_", -1)
 	imagePullSpec = strings.Replace(imagePullSpec, ":", "_", -1)
+	if image.Platform != "" {
+		imagePullSpec += "_" + strings.Replace(image.Platform, "/", "_", -1)
+	}
 	return fmt.Sprintf("%s/%s.tar", image.Directory, imagePullSpec)
 }
+
+// RepositoryOf returns the repository part of a pull spec, without its tag or digest
+func RepositoryOf(pullSpec string) string {
+	if index := strings.Index(pullSpec, "@"); index >= 0 {
+		return pullSpec[:index]
+	}
+	// a colon after the last slash separates the tag; one before it is a registry port
+	if index := strings.LastIndex(pullSpec, ":"); index > strings.LastIndex(pullSpec, "/") {
+		return pullSpec[:index]
+	}
+	return pullSpec
+}
+
+// DigestOf returns the digest of a pull spec, such as sha256:abc..., or "" if it doesn't have one
+func DigestOf(pullSpec string) string {
+	if index := strings.Index(pullSpec, "@"); index >= 0 {
+		return pullSpec[index+1:]
+	}
+	return ""
+}
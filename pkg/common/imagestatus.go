@@ -32,6 +32,8 @@ const (
 	ImageStatusInProgress ImageStatus = iota
 	ImageStatusDone       ImageStatus = iota
 	ImageStatusError      ImageStatus = iota
+	// ImageStatusQueued is waiting for the pulls ahead of it in the queue
+	ImageStatusQueued ImageStatus = iota
 )
 
 func (is ImageStatus) String() string {
@@ -44,6 +46,8 @@ func (is ImageStatus) String() string {
 		return "Done"
 	case ImageStatusError:
 		return "Error"
+	case ImageStatusQueued:
+		return "Queued"
 	default:
 		panic(fmt.Errorf("invalid ImageStatus value: %d", is))
 	}
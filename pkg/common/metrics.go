@@ -22,6 +22,7 @@ under the License.
 package common
 
 import (
+	"fmt"
 	"time"
 
 	imageInterface "github.com/blackducksoftware/perceptor-scanner/pkg/interfaces"
@@ -34,6 +35,10 @@ var dockerGetDurationHistogram prometheus.Histogram
 var dockerTotalDurationHistogram prometheus.Histogram
 var errorsCounter *prometheus.CounterVec
 var eventsCounter *prometheus.CounterVec
+var localImageLookupCounter *prometheus.CounterVec
+var createdImageRemovalCounter *prometheus.CounterVec
+var registryBudgetGauge *prometheus.GaugeVec
+var registryRateLimitedCounter *prometheus.CounterVec
 
 // durations
 
@@ -71,6 +76,38 @@ func RecordDockerError(errorStage string, errorName string, image imageInterface
 	errorsCounter.With(prometheus.Labels{"stage": errorStage, "errorName": errorName}).Inc()
 }
 
+// RecordLocalImageLookup will record whether an image was already in the docker
+// daemon, so that pulling it from its registry was skipped
+func RecordLocalImageLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	localImageLookupCounter.With(prometheus.Labels{"result": result}).Inc()
+}
+
+// RecordCreatedImageRemoval will record whether removing an image that was
+// created in the docker daemon succeeded
+func RecordCreatedImageRemoval(success bool) {
+	createdImageRemovalCounter.With(prometheus.Labels{"success": fmt.Sprintf("%t", success)}).Inc()
+}
+
+// registry rate limits
+
+func recordRegistryBudget(host string, budget *registryBudget, now time.Time) {
+	registryBudgetGauge.With(prometheus.Labels{"registry": host, "name": "pulls_last_minute"}).Set(float64(len(budget.pulls)))
+	registryBudgetGauge.With(prometheus.Labels{"registry": host, "name": "remaining"}).Set(float64(budget.remaining))
+	blocked := 0.0
+	if budget.blockedUntil.After(now) {
+		blocked = budget.blockedUntil.Sub(now).Seconds()
+	}
+	registryBudgetGauge.With(prometheus.Labels{"registry": host, "name": "blocked_seconds"}).Set(blocked)
+}
+
+func recordRegistryRateLimited(host string, outcome string) {
+	registryRateLimitedCounter.With(prometheus.Labels{"registry": host, "outcome": outcome}).Inc()
+}
+
 // init
 
 func init() {
@@ -122,10 +159,42 @@ func init() {
 		Help:      "miscellaneous events from imagefacade",
 	}, []string{"event"})
 
+	localImageLookupCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "imagefacade",
+		Name:      "local_image_lookup",
+		Help:      "whether images were already in the docker daemon (hit), or had to be pulled from their registry (miss)",
+	}, []string{"result"})
+
+	createdImageRemovalCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "imagefacade",
+		Name:      "created_image_removal",
+		Help:      "whether removing images that imagefacade created in the docker daemon succeeded or failed",
+	}, []string{"success"})
+
+	registryBudgetGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "perceptor",
+		Subsystem: "imagefacade",
+		Name:      "registry_budget",
+		Help:      "per-registry pull budget: pulls in the last minute, remaining requests the registry reported (-1 if unknown), and seconds until pulls are allowed again",
+	}, []string{"registry", "name"})
+
+	registryRateLimitedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "imagefacade",
+		Name:      "registry_rate_limited",
+		Help:      "pulls rejected by the per-registry rate limiter, and 429s from registries",
+	}, []string{"registry", "outcome"})
+
 	prometheus.MustRegister(errorsCounter)
 	prometheus.MustRegister(dockerGetDurationHistogram)
 	prometheus.MustRegister(dockerCreateDurationHistogram)
 	prometheus.MustRegister(dockerTotalDurationHistogram)
 	prometheus.MustRegister(tarballSize)
 	prometheus.MustRegister(eventsCounter)
+	prometheus.MustRegister(localImageLookupCounter)
+	prometheus.MustRegister(createdImageRemovalCounter)
+	prometheus.MustRegister(registryBudgetGauge)
+	prometheus.MustRegister(registryRateLimitedCounter)
 }
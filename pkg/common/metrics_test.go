@@ -37,6 +37,10 @@ func RunMetricsTests() {
 			RecordDockerTotalDuration(time.Now().Sub(time.Now()))
 			//  RecordDockerError("abc", "def", image, err)
 			RecordTarFileSize(24)
+			RecordCreatedImageRemoval(true)
+			RecordLocalImageLookup(true)
+			recordRegistryBudget("docker.io", &registryBudget{remaining: -1}, time.Now())
+			recordRegistryRateLimited("docker.io", "delayed")
 		})
 	})
 }
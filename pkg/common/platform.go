@@ -0,0 +1,88 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Platform is what an image in a manifest list is built for, as in
+// linux/arm64/v8
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ParsePlatform parses os/architecture[/variant]
+func ParsePlatform(platform string) (*Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid platform %s: expected os/architecture[/variant]", platform)
+	}
+	parsed := &Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		parsed.Variant = parts[2]
+	}
+	return parsed, nil
+}
+
+// DefaultPlatform is the platform that this process runs on
+func DefaultPlatform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+func (platform *Platform) String() string {
+	if platform.Variant == "" {
+		return platform.OS + "/" + platform.Architecture
+	}
+	return platform.OS + "/" + platform.Architecture + "/" + platform.Variant
+}
+
+// defaultVariants are what images without a variant are built for, as far as
+// matching goes: arm64 images are nearly always v8, for example
+var defaultVariants = map[string]string{
+	"arm64": "v8",
+	"arm":   "v7",
+}
+
+// variant returns the platform's variant, or the architecture's default
+func (platform *Platform) variant() string {
+	if platform.Variant == "" {
+		return defaultVariants[platform.Architecture]
+	}
+	return platform.Variant
+}
+
+// Matches returns whether an image built for other runs on this platform.
+// An exact variant match is better than a default one.
+func (platform *Platform) Matches(other *Platform) (matches bool, exact bool) {
+	if other == nil || platform.OS != other.OS || platform.Architecture != other.Architecture {
+		return false, false
+	}
+	if platform.variant() != other.variant() {
+		return false, false
+	}
+	return true, platform.Variant == other.Variant
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func RunPlatformTests() {
+	Describe("Platform", func() {
+		It("should parse os/architecture[/variant]", func() {
+			platform, err := ParsePlatform("linux/arm64/v8")
+			Expect(err).To(BeNil())
+			Expect(*platform).To(Equal(Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}))
+			Expect(platform.String()).To(Equal("linux/arm64/v8"))
+			platform, err = ParsePlatform("linux/amd64")
+			Expect(err).To(BeNil())
+			Expect(platform.String()).To(Equal("linux/amd64"))
+		})
+
+		It("should reject malformed platforms", func() {
+			for _, invalid := range []string{"", "linux", "/amd64", "linux/", "linux/arm/v7/extra"} {
+				_, err := ParsePlatform(invalid)
+				Expect(err).NotTo(BeNil())
+			}
+		})
+
+		It("should match default variants", func() {
+			arm64, _ := ParsePlatform("linux/arm64")
+			matches, exact := arm64.Matches(&Platform{OS: "linux", Architecture: "arm64", Variant: "v8"})
+			Expect(matches).To(BeTrue())
+			Expect(exact).To(BeFalse())
+			matches, exact = arm64.Matches(&Platform{OS: "linux", Architecture: "arm64"})
+			Expect(matches).To(BeTrue())
+			Expect(exact).To(BeTrue())
+			arm, _ := ParsePlatform("linux/arm")
+			matches, _ = arm.Matches(&Platform{OS: "linux", Architecture: "arm", Variant: "v6"})
+			Expect(matches).To(BeFalse())
+			matches, _ = arm64.Matches(&Platform{OS: "windows", Architecture: "arm64"})
+			Expect(matches).To(BeFalse())
+			matches, _ = arm64.Matches(nil)
+			Expect(matches).To(BeFalse())
+		})
+	})
+}
@@ -0,0 +1,184 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"path"
+	"strings"
+)
+
+// dockerHubHost is where pull specs without a registry go; the other names
+// Docker Hub goes by are normalised to it
+const dockerHubHost = "docker.io"
+
+var dockerHubAliases = map[string]bool{
+	"docker.io":            true,
+	"index.docker.io":      true,
+	"registry-1.docker.io": true,
+}
+
+// PullSpec is a docker pull spec, split into its parts
+type PullSpec struct {
+	// Host is the registry's host name, or docker.io for Docker Hub
+	Host string
+	// Port is the registry's port, or "" if the pull spec doesn't have one
+	Port string
+	// Repository is the path within the registry.  Official Docker Hub
+	// images get the implicit library/ prefix.
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParsePullSpec splits a pull spec into its registry, repository, tag and
+// digest.  As with docker, the first path component is only taken to be the
+// registry if it looks like one: it has a dot or a port, or is localhost.
+func ParsePullSpec(pullSpec string) *PullSpec {
+	parsed := &PullSpec{}
+	repository := pullSpec
+	if index := strings.Index(repository, "@"); index >= 0 {
+		parsed.Digest = repository[index+1:]
+		repository = repository[:index]
+	}
+	// a colon after the last slash separates the tag; one before it is a registry port
+	if index := strings.LastIndex(repository, ":"); index > strings.LastIndex(repository, "/") {
+		parsed.Tag = repository[index+1:]
+		repository = repository[:index]
+	}
+
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		parsed.Host, parsed.Port = splitHostPort(parts[0])
+		parsed.Repository = parts[1]
+	} else {
+		parsed.Host = dockerHubHost
+		parsed.Repository = repository
+	}
+	if dockerHubAliases[parsed.Host] && parsed.Port == "" {
+		parsed.Host = dockerHubHost
+		if !strings.Contains(parsed.Repository, "/") {
+			parsed.Repository = "library/" + parsed.Repository
+		}
+	}
+	return parsed
+}
+
+// isValidPullSpec returns whether the pull spec names a repository: it isn't
+// empty, has no white space, and has no empty path components, tag or digest.
+// ParsePullSpec makes something of anything, so check this before matching.
+func isValidPullSpec(pullSpec string) bool {
+	if pullSpec == "" || strings.ContainsAny(pullSpec, " \t\r\n") {
+		return false
+	}
+	repository := pullSpec
+	if index := strings.Index(repository, "@"); index >= 0 {
+		if index == len(repository)-1 {
+			return false
+		}
+		repository = repository[:index]
+	}
+	if index := strings.LastIndex(repository, ":"); index > strings.LastIndex(repository, "/") {
+		if index == len(repository)-1 {
+			return false
+		}
+		repository = repository[:index]
+	}
+	for _, component := range strings.Split(repository, "/") {
+		if component == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// registryPattern is what a RegistryAuth's URL says it applies to: a host,
+// which may have wildcards such as *.example.com, a port, and optionally a
+// repository path prefix
+type registryPattern struct {
+	host       string
+	port       string
+	pathPrefix string
+}
+
+func parseRegistryPattern(url string) *registryPattern {
+	url = strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+	url = strings.Trim(url, "/")
+	parts := strings.SplitN(url, "/", 2)
+	pattern := &registryPattern{}
+	pattern.host, pattern.port = splitHostPort(parts[0])
+	if len(parts) == 2 {
+		pattern.pathPrefix = parts[1]
+	}
+	if dockerHubAliases[pattern.host] && pattern.port == "" {
+		pattern.host = dockerHubHost
+		// the API version in docker config keys such as https://index.docker.io/v1/
+		if pattern.pathPrefix == "v1" || pattern.pathPrefix == "v2" {
+			pattern.pathPrefix = ""
+		}
+	}
+	return pattern
+}
+
+// matches returns whether the pattern applies to the pull spec, and if so,
+// whether it's by exact host name rather than by wildcard
+func (pattern *registryPattern) matches(pullSpec *PullSpec) (matches bool, exactHost bool) {
+	if pattern.port != pullSpec.Port || !matchesPathPrefix(pullSpec.Repository, pattern.pathPrefix) {
+		return false, false
+	}
+	if pattern.host == pullSpec.Host {
+		return true, true
+	}
+	return matchesHostPattern(pullSpec.Host, pattern.host), false
+}
+
+// matchesHostPattern matches host names label by label, so that *.example.com
+// matches a.example.com, but neither example.com nor a.b.example.com
+func matchesHostPattern(host string, pattern string) bool {
+	if !strings.Contains(pattern, "*") {
+		return false
+	}
+	hostLabels := strings.Split(host, ".")
+	patternLabels := strings.Split(pattern, ".")
+	if len(hostLabels) != len(patternLabels) {
+		return false
+	}
+	for i, label := range patternLabels {
+		if matched, err := path.Match(label, hostLabels[i]); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesPathPrefix matches whole path components, so that a prefix of org
+// matches org/app but not organisation/app
+func matchesPathPrefix(repository string, prefix string) bool {
+	return prefix == "" || repository == prefix || strings.HasPrefix(repository, prefix+"/")
+}
+
+// splitHostPort lowercases the host, since host names aren't case sensitive
+func splitHostPort(hostPort string) (string, string) {
+	if index := strings.LastIndex(hostPort, ":"); index >= 0 {
+		return strings.ToLower(hostPort[:index]), hostPort[index+1:]
+	}
+	return strings.ToLower(hostPort), ""
+}
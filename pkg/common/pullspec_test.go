@@ -0,0 +1,52 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func RunPullSpecTests() {
+	Describe("ParsePullSpec", func() {
+		testCases := []struct {
+			pullSpec string
+			expected *PullSpec
+		}{
+			{"alpine", &PullSpec{Host: "docker.io", Repository: "library/alpine"}},
+			{"alpine:3.8", &PullSpec{Host: "docker.io", Repository: "library/alpine", Tag: "3.8"}},
+			{"myorg/app:1.0", &PullSpec{Host: "docker.io", Repository: "myorg/app", Tag: "1.0"}},
+			{"docker.io/alpine", &PullSpec{Host: "docker.io", Repository: "library/alpine"}},
+			{"index.docker.io/myorg/app", &PullSpec{Host: "docker.io", Repository: "myorg/app"}},
+			{"localhost/app", &PullSpec{Host: "localhost", Repository: "app"}},
+			{"localhost:5000/app:1.0", &PullSpec{Host: "localhost", Port: "5000", Repository: "app", Tag: "1.0"}},
+			{"registry.io/team/app@sha256:abc", &PullSpec{Host: "registry.io", Repository: "team/app", Digest: "sha256:abc"}},
+			{"registry.io:443/team/app:1.0@sha256:abc", &PullSpec{Host: "registry.io", Port: "443", Repository: "team/app", Tag: "1.0", Digest: "sha256:abc"}},
+		}
+		for _, testCase := range testCases {
+			c := testCase
+			It("should parse "+c.pullSpec, func() {
+				Expect(ParsePullSpec(c.pullSpec)).To(Equal(c.expected))
+			})
+		}
+	})
+}
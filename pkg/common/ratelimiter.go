@@ -0,0 +1,279 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// a registry that says it's throttling us, without saying for how long,
+	// is left alone for this long at first, doubling each time it happens again
+	defaultRateLimitBackoff = time.Minute
+	maximumRateLimitBackoff = 30 * time.Minute
+	pullsPerMinuteWindow    = time.Minute
+)
+
+// tooManyRequestsPattern matches the ways that the docker daemon, skopeo and
+// registries report a 429: "toomanyrequests: You have reached your pull rate
+// limit", "429 Too Many Requests" and so on
+var tooManyRequestsPattern = regexp.MustCompile(`(?i)\b429\b|too ?many ?requests|rate limit`)
+
+// retryAfterPattern matches how long an error message says to wait before
+// pulling again, for the registries and tools that say: "Retry-After: 120",
+// "retry after 30s"
+var retryAfterPattern = regexp.MustCompile(`(?i)retry[- ]after:?\s*(\d+)\s*s?\b`)
+
+// IsTooManyRequests returns whether an error message or response says that the
+// registry is throttling us
+func IsTooManyRequests(message string) bool {
+	return tooManyRequestsPattern.MatchString(message)
+}
+
+// TooManyRequestsError is returned by the pullers when a registry answers with
+// a 429.  RetryAfter is 0 if the registry didn't say when to try again.
+type TooManyRequestsError struct {
+	Registry   string
+	RetryAfter time.Duration
+}
+
+// NewTooManyRequestsError describes a 429 from pullSpec's registry.  When to
+// retry comes from the response's Retry-After header, if there is one, or
+// else from the error message.
+func NewTooManyRequestsError(pullSpec string, header http.Header, message string) *TooManyRequestsError {
+	retryAfter, ok := ParseRetryAfter(header.Get("Retry-After"), time.Now())
+	if !ok {
+		if match := retryAfterPattern.FindStringSubmatch(message); match != nil {
+			seconds, _ := strconv.Atoi(match[1])
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	return &TooManyRequestsError{Registry: RegistryHost(pullSpec), RetryAfter: retryAfter}
+}
+
+func (err *TooManyRequestsError) Error() string {
+	if err.RetryAfter == 0 {
+		return fmt.Sprintf("registry %s returned too many requests", err.Registry)
+	}
+	return fmt.Sprintf("registry %s returned too many requests, retry after %s", err.Registry, err.RetryAfter)
+}
+
+// RateLimitedError is returned for a pull that its registry's budget doesn't
+// allow yet
+type RateLimitedError struct {
+	Registry   string
+	RetryAfter time.Duration
+}
+
+func (err *RateLimitedError) Error() string {
+	return fmt.Sprintf("pulls from registry %s are rate limited, retry after %s", err.Registry, err.RetryAfter)
+}
+
+// registryBudget is what's known about one registry host's limits
+type registryBudget struct {
+	// pulls holds when the pulls of the last minute started
+	pulls []time.Time
+	// remaining is the registry's RateLimit-Remaining, or -1 if it hasn't sent one
+	remaining    int
+	blockedUntil time.Time
+	backoff      time.Duration
+}
+
+// RegistryRateLimiter holds off pulls from registries that are throttling us,
+// or that would go over the caps in their RegistryConfig.  Budgets are kept
+// per registry host.  It never waits itself: pulls are started one at a time,
+// so a pull that waited for its registry would hold up pulls from the others.
+type RegistryRateLimiter struct {
+	registries []*RegistryConfig
+	budgets    map[string]*registryBudget
+	now        func() time.Time
+	mutex      sync.Mutex
+}
+
+// NewRegistryRateLimiter ...
+func NewRegistryRateLimiter(registries []*RegistryConfig) *RegistryRateLimiter {
+	return &RegistryRateLimiter{
+		registries: registries,
+		budgets:    map[string]*registryBudget{},
+		now:        time.Now}
+}
+
+// Delay returns how long a pull of pullSpec has to wait for its registry's
+// budget, or 0 if it can start now
+func (rl *RegistryRateLimiter) Delay(pullSpec string) time.Duration {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	return rl.delay(RegistryHost(pullSpec), FindRegistryConfig(pullSpec, rl.registries), rl.now())
+}
+
+// Acquire starts a pull of pullSpec if its registry's budget allows it.
+// Otherwise it returns a RateLimitedError that says how long to wait.
+func (rl *RegistryRateLimiter) Acquire(pullSpec string) error {
+	host := RegistryHost(pullSpec)
+	config := FindRegistryConfig(pullSpec, rl.registries)
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	budget := rl.budget(host)
+	now := rl.now()
+	defer recordRegistryBudget(host, budget, now)
+	if wait := rl.delay(host, config, now); wait > 0 {
+		log.Infof("registry %s is rate limited for %s, not pulling %s", host, wait, pullSpec)
+		recordRegistryRateLimited(host, "rejected")
+		return &RateLimitedError{Registry: host, RetryAfter: wait}
+	}
+	budget.pulls = append(budget.pulls, now)
+	return nil
+}
+
+// delay must be called with the mutex held
+func (rl *RegistryRateLimiter) delay(host string, config *RegistryConfig, now time.Time) time.Duration {
+	budget := rl.budget(host)
+	if now.Before(budget.blockedUntil) {
+		return budget.blockedUntil.Sub(now)
+	}
+	budget.prunePulls(now)
+	if config.PullsPerMinute > 0 && len(budget.pulls) >= config.PullsPerMinute {
+		return budget.pulls[0].Add(pullsPerMinuteWindow).Sub(now)
+	}
+	return 0
+}
+
+// ObserveHeaders updates the budget of pullSpec's registry from the
+// RateLimit-Remaining and Retry-After headers of one of its responses
+func (rl *RegistryRateLimiter) ObserveHeaders(pullSpec string, header http.Header) {
+	now := rl.now()
+	retryAfter, hasRetryAfter := ParseRetryAfter(header.Get("Retry-After"), now)
+	remaining, hasRemaining := parseRateLimitRemaining(header.Get("RateLimit-Remaining"))
+
+	host := RegistryHost(pullSpec)
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	budget := rl.budget(host)
+	if hasRemaining {
+		budget.remaining = remaining
+	}
+	if hasRetryAfter && retryAfter > 0 {
+		rl.block(host, budget, retryAfter, now)
+	} else if hasRemaining && remaining == 0 {
+		rl.block(host, budget, 0, now)
+	}
+	recordRegistryBudget(host, budget, now)
+}
+
+// ObserveTooManyRequests holds off pulls from pullSpec's registry after it
+// answered with a 429.  If retryAfter is 0, the registry's backoff is used.
+func (rl *RegistryRateLimiter) ObserveTooManyRequests(pullSpec string, retryAfter time.Duration) {
+	host := RegistryHost(pullSpec)
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	budget := rl.budget(host)
+	now := rl.now()
+	rl.block(host, budget, retryAfter, now)
+	recordRegistryRateLimited(host, "throttled")
+	recordRegistryBudget(host, budget, now)
+}
+
+// ObserveSuccess resets the backoff of pullSpec's registry
+func (rl *RegistryRateLimiter) ObserveSuccess(pullSpec string) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	rl.budget(RegistryHost(pullSpec)).backoff = 0
+}
+
+// block holds off pulls for retryAfter, or for the next backoff if it's 0
+func (rl *RegistryRateLimiter) block(host string, budget *registryBudget, retryAfter time.Duration, now time.Time) {
+	if retryAfter <= 0 {
+		budget.backoff *= 2
+		if budget.backoff < defaultRateLimitBackoff {
+			budget.backoff = defaultRateLimitBackoff
+		}
+		if budget.backoff > maximumRateLimitBackoff {
+			budget.backoff = maximumRateLimitBackoff
+		}
+		retryAfter = budget.backoff
+	}
+	if until := now.Add(retryAfter); until.After(budget.blockedUntil) {
+		budget.blockedUntil = until
+	}
+	log.Warnf("registry %s is throttling pulls, holding off until %s", host, budget.blockedUntil.Format(time.RFC3339))
+}
+
+func (rl *RegistryRateLimiter) budget(host string) *registryBudget {
+	budget, ok := rl.budgets[host]
+	if !ok {
+		budget = &registryBudget{remaining: -1}
+		rl.budgets[host] = budget
+	}
+	return budget
+}
+
+// prunePulls forgets pulls that started more than a minute ago
+func (budget *registryBudget) prunePulls(now time.Time) {
+	start := now.Add(-pullsPerMinuteWindow)
+	i := 0
+	for i < len(budget.pulls) && !budget.pulls[i].After(start) {
+		i++
+	}
+	budget.pulls = budget.pulls[i:]
+}
+
+// ParseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if !date.After(now) {
+		return 0, true
+	}
+	return date.Sub(now), true
+}
+
+// parseRateLimitRemaining parses a RateLimit-Remaining header, such as
+// Docker Hub's "76;w=21600": the number of requests left in the window
+func parseRateLimitRemaining(value string) (int, bool) {
+	value = strings.TrimSpace(strings.SplitN(value, ";", 2)[0])
+	remaining, err := strconv.Atoi(value)
+	if err != nil || remaining < 0 {
+		return 0, false
+	}
+	return remaining, true
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func RunRateLimiterTests() {
+	Describe("RegistryRateLimiter", func() {
+		var now time.Time
+
+		newLimiter := func(registries []*RegistryConfig) *RegistryRateLimiter {
+			limiter := NewRegistryRateLimiter(registries)
+			limiter.now = func() time.Time { return now }
+			return limiter
+		}
+
+		BeforeEach(func() {
+			now = time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+		})
+
+		It("should cap pulls per minute", func() {
+			limiter := newLimiter([]*RegistryConfig{{URL: "registry.example.com", PullsPerMinute: 2}})
+			for i := 0; i < 2; i++ {
+				Expect(limiter.Delay("registry.example.com/app:1")).To(Equal(time.Duration(0)))
+				Expect(limiter.Acquire("registry.example.com/app:1")).To(BeNil())
+				now = now.Add(20 * time.Second)
+			}
+			Expect(limiter.Delay("registry.example.com/app:1")).To(Equal(20 * time.Second))
+			err := limiter.Acquire("registry.example.com/app:1")
+			Expect(err).To(Equal(&RateLimitedError{Registry: "registry.example.com", RetryAfter: 20 * time.Second}))
+			Expect(limiter.Acquire("quay.io/coreos/etcd")).To(BeNil())
+			now = now.Add(20 * time.Second)
+			Expect(limiter.Acquire("registry.example.com/app:1")).To(BeNil())
+		})
+
+		It("should back off exponentially after 429s", func() {
+			limiter := newLimiter(nil)
+			limiter.ObserveTooManyRequests("nginx", 0)
+			err := limiter.Acquire("nginx")
+			Expect(err).To(Equal(&RateLimitedError{Registry: "docker.io", RetryAfter: time.Minute}))
+
+			now = now.Add(time.Minute)
+			limiter.ObserveTooManyRequests("nginx", 0)
+			err = limiter.Acquire("nginx")
+			Expect(err).To(Equal(&RateLimitedError{Registry: "docker.io", RetryAfter: 2 * time.Minute}))
+
+			now = now.Add(2 * time.Minute)
+			limiter.ObserveSuccess("nginx")
+			limiter.ObserveTooManyRequests("nginx", 10*time.Second)
+			err = limiter.Acquire("nginx")
+			Expect(err).To(Equal(&RateLimitedError{Registry: "docker.io", RetryAfter: 10 * time.Second}))
+		})
+
+		It("should respect rate limit headers", func() {
+			limiter := newLimiter(nil)
+			limiter.ObserveHeaders("registry.example.com/", http.Header{"Ratelimit-Remaining": {"76;w=21600"}})
+			Expect(limiter.Acquire("registry.example.com/app")).To(BeNil())
+
+			limiter.ObserveHeaders("registry.example.com/", http.Header{"Retry-After": {"30"}})
+			err := limiter.Acquire("registry.example.com/app")
+			Expect(err).To(Equal(&RateLimitedError{Registry: "registry.example.com", RetryAfter: 30 * time.Second}))
+
+			now = now.Add(30 * time.Second)
+			limiter.ObserveHeaders("registry.example.com/", http.Header{"Ratelimit-Remaining": {"0;w=21600"}})
+			err = limiter.Acquire("registry.example.com/app")
+			Expect(err).To(Equal(&RateLimitedError{Registry: "registry.example.com", RetryAfter: time.Minute}))
+		})
+
+		It("should parse Retry-After as seconds or a date", func() {
+			retryAfter, ok := ParseRetryAfter("120", now)
+			Expect(ok).To(BeTrue())
+			Expect(retryAfter).To(Equal(2 * time.Minute))
+			retryAfter, ok = ParseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now)
+			Expect(ok).To(BeTrue())
+			Expect(retryAfter).To(Equal(90 * time.Second))
+			_, ok = ParseRetryAfter("soon", now)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should find when to retry a 429", func() {
+			err := NewTooManyRequestsError("nginx", http.Header{"Retry-After": {"45"}}, "toomanyrequests: retry after 10s")
+			Expect(err).To(Equal(&TooManyRequestsError{Registry: "docker.io", RetryAfter: 45 * time.Second}))
+			err = NewTooManyRequestsError("quay.io/coreos/etcd", nil, "429 Too Many Requests: retry after 10s")
+			Expect(err).To(Equal(&TooManyRequestsError{Registry: "quay.io", RetryAfter: 10 * time.Second}))
+			err = NewTooManyRequestsError("nginx", nil, "toomanyrequests: You have reached your pull rate limit.")
+			Expect(err).To(Equal(&TooManyRequestsError{Registry: "docker.io"}))
+		})
+
+		It("should recognize 429s", func() {
+			Expect(IsTooManyRequests("toomanyrequests: You have reached your pull rate limit.")).To(BeTrue())
+			Expect(IsTooManyRequests("reading manifest 1.15 in docker.io/library/nginx: 429 Too Many Requests")).To(BeTrue())
+			Expect(IsTooManyRequests("manifest for nginx@sha256:4290abc not found")).To(BeFalse())
+		})
+	})
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RedactedValue is shown in place of passwords, tokens and auth headers
+const RedactedValue = "<redacted>"
+
+// secrets shorter than this aren't registered: they're too likely to turn up
+// inside ordinary words, and masking those would make the logs unreadable
+const minimumSecretLength = 6
+
+// secretPatterns catch secrets that were never registered, by what surrounds
+// them: struct fields and JSON keys, environment variables, command line
+// flags and HTTP headers
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)((?:password|passwd|token|secret)"?[:=])(\s*"[^"]*"|[^\s",}\]]+)`),
+	regexp.MustCompile(`(?i)(--(?:src-|dest-)?creds[= ])(\S+)`),
+	regexp.MustCompile(`(?i)((?:x-registry-auth|authorization)"?[:=]\s*\[?"?(?:basic |bearer |token )?)([^\s",\]}]+)`),
+}
+
+var secrets = &secretSet{values: map[string]bool{}}
+
+// secretSet holds the secrets that are known to be in use, longest first, so
+// that a secret containing another is masked as a whole
+type secretSet struct {
+	mutex  sync.RWMutex
+	values map[string]bool
+	sorted []string
+}
+
+func (set *secretSet) add(secret string) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	if set.values[secret] {
+		return
+	}
+	set.values[secret] = true
+	set.sorted = append(set.sorted, secret)
+	sort.Slice(set.sorted, func(i, j int) bool { return len(set.sorted[i]) > len(set.sorted[j]) })
+}
+
+func (set *secretSet) redact(s string) string {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	for _, secret := range set.sorted {
+		s = strings.Replace(s, secret, RedactedValue, -1)
+	}
+	return s
+}
+
+// RegisterSecret makes sure that secret is masked wherever it shows up in the
+// logs, however it got there
+func RegisterSecret(secret string) {
+	if len(secret) < minimumSecretLength {
+		return
+	}
+	secrets.add(secret)
+}
+
+// Redact masks the registered secrets, and anything that looks like a
+// password, token or auth header, in s
+func Redact(s string) string {
+	s = secrets.redact(s)
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, "${1}"+RedactedValue)
+	}
+	return s
+}
+
+// RedactIfSet returns RedactedValue in place of a non-empty secret, so that
+// it's still possible to tell whether the secret was configured
+func RedactIfSet(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return RedactedValue
+}
+
+// RedactionHook is a logrus hook that runs every log message, and its fields,
+// through Redact before it's written.  Fields are formatted first, so that
+// types that hold secrets, such as RegistryAuth, keep them out through their
+// own String and Format methods.
+type RedactionHook struct{}
+
+// Levels returns all the log levels: secrets must not show up at any of them
+func (hook *RedactionHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire redacts the entry's message, and replaces its fields with redacted
+// copies.  They're copied rather than changed in place, since the fields are
+// shared with the entry that WithFields returned, which may be logged again.
+func (hook *RedactionHook) Fire(entry *log.Entry) error {
+	entry.Message = Redact(entry.Message)
+	data := make(log.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		data[key] = redactField(value)
+	}
+	entry.Data = data
+	return nil
+}
+
+// redactField formats a log field and redacts it.  Numbers and booleans
+// can't hold secrets, so they're left as they are.
+func redactField(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	case string:
+		return Redact(v)
+	case error:
+		return Redact(v.Error())
+	case fmt.Formatter, fmt.Stringer:
+		return Redact(fmt.Sprintf("%v", v))
+	default:
+		return Redact(fmt.Sprintf("%+v", v))
+	}
+}
+
+func init() {
+	log.AddHook(&RedactionHook{})
+}
@@ -0,0 +1,103 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+func RunRedactTests() {
+	Describe("Redact", func() {
+		It("should mask registered secrets", func() {
+			RegisterSecret("registered-secret")
+			Expect(Redact("using registered-secret here")).To(Equal("using " + RedactedValue + " here"))
+		})
+		It("should not register short secrets", func() {
+			RegisterSecret("short")
+			Expect(Redact("a short word")).To(Equal("a short word"))
+		})
+		It("should mask unregistered secrets by what surrounds them", func() {
+			Expect(Redact("{User:me Password:hunter22}")).To(Equal("{User:me Password:" + RedactedValue + "}"))
+			Expect(Redact(`{"password": "hunter22"}`)).To(Equal(`{"password":` + RedactedValue + `}`))
+			Expect(Redact("BD_HUB_TOKEN=abcdef")).To(Equal("BD_HUB_TOKEN=" + RedactedValue))
+			Expect(Redact("skopeo copy --src-creds=me:hunter22 docker://a")).To(Equal("skopeo copy --src-creds=" + RedactedValue + " docker://a"))
+			Expect(Redact("X-Registry-Auth: eyJhYmMiOiJkZWYifQ==")).To(Equal("X-Registry-Auth: " + RedactedValue))
+			Expect(Redact("Authorization: Bearer abcdef")).To(Equal("Authorization: Bearer " + RedactedValue))
+			Expect(Redact("map[Authorization:[Bearer abcdef]]")).To(Equal("map[Authorization:[Bearer " + RedactedValue + "]]"))
+			Expect(Redact(`{"token":"abcdef","expires_in":300}`)).To(Equal(`{"token":` + RedactedValue + `,"expires_in":300}`))
+		})
+		It("should leave other text alone", func() {
+			Expect(Redact("unable to load token: file not found")).To(Equal("unable to load token: file not found"))
+		})
+	})
+
+	Describe("RegistryAuth", func() {
+		auth := &RegistryAuth{URL: "registry:5000", User: "me", Password: "hunter22"}
+		for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+			v := verb
+			It(fmt.Sprintf("should not show the password with %s", v), func() {
+				Expect(fmt.Sprintf(v, auth)).NotTo(ContainSubstring("hunter22"))
+				Expect(fmt.Sprintf(v, []*RegistryAuth{auth})).NotTo(ContainSubstring("hunter22"))
+			})
+		}
+	})
+
+	Describe("RedactionHook", func() {
+		It("should redact log messages and fields", func() {
+			buffer := &bytes.Buffer{}
+			logger := log.New()
+			logger.Out = buffer
+			logger.Hooks.Add(&RedactionHook{})
+			RegisterSecret("hooked-secret")
+			logger.WithField("error", fmt.Errorf("failed with hooked-secret")).Infof("message with hooked-secret")
+			Expect(buffer.String()).NotTo(ContainSubstring("hooked-secret"))
+			Expect(buffer.String()).To(ContainSubstring(RedactedValue))
+		})
+		It("should leave the logged entry's fields alone", func() {
+			buffer := &bytes.Buffer{}
+			logger := log.New()
+			logger.Out = buffer
+			logger.Hooks.Add(&RedactionHook{})
+			RegisterSecret("shared-secret")
+			entry := logger.WithField("error", "failed with shared-secret")
+			entry.Info("first")
+			entry.Info("second")
+			Expect(entry.Data["error"]).To(Equal("failed with shared-secret"))
+			Expect(buffer.String()).NotTo(ContainSubstring("shared-secret"))
+		})
+		It("should format fields through their own String and Format methods", func() {
+			buffer := &bytes.Buffer{}
+			logger := log.New()
+			logger.Out = buffer
+			logger.Hooks.Add(&RedactionHook{})
+			auth := &RegistryAuth{URL: "registry:5000", User: "me", Password: "unregistered-password"}
+			logger.WithField("auth", auth).WithField("auths", []*RegistryAuth{auth}).Info("pulling")
+			Expect(buffer.String()).NotTo(ContainSubstring("unregistered-password"))
+			Expect(buffer.String()).To(ContainSubstring("registry:5000"))
+		})
+	})
+}
@@ -21,9 +21,27 @@ under the License.
 
 package common
 
+import "fmt"
+
 // RegistryAuth ...
 type RegistryAuth struct {
 	URL      string
 	User     string
 	Password string
+	// IdentityToken is an OAuth refresh token, used instead of the password
+	// by registries that support it
+	IdentityToken string
+	// RegistryToken is a bearer token for the registry itself, sent as is
+	RegistryToken string
+}
+
+// String shows the registry and user, but not the password or tokens
+func (auth RegistryAuth) String() string {
+	return fmt.Sprintf("{URL:%s User:%s Password:%s IdentityToken:%s RegistryToken:%s}",
+		auth.URL, auth.User, RedactIfSet(auth.Password), RedactIfSet(auth.IdentityToken), RedactIfSet(auth.RegistryToken))
+}
+
+// Format keeps the password out of every fmt verb, including %#v
+func (auth RegistryAuth) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, auth.String())
 }
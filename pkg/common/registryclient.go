@@ -0,0 +1,380 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+
+	// dockerHubAPIHost serves Docker Hub's registry API
+	dockerHubAPIHost = "registry-1.docker.io"
+	// tokenClientID identifies us to token servers, which require a client id
+	// for refresh token grants
+	tokenClientID = "perceptor-imagefacade"
+
+	registryClientTimeout = 30 * time.Second
+)
+
+var manifestMediaTypes = strings.Join([]string{mediaTypeDockerManifestList, mediaTypeOCIIndex, mediaTypeDockerManifest, mediaTypeOCIManifest}, ", ")
+
+var challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// manifest holds the fields of manifest lists, OCI indexes and image manifests
+// that are used
+type manifest struct {
+	MediaType string                `json:"mediaType"`
+	Manifests []*manifestDescriptor `json:"manifests"`
+	Config    *manifestDescriptor   `json:"config"`
+}
+
+type manifestDescriptor struct {
+	Digest   string    `json:"digest"`
+	Platform *Platform `json:"platform"`
+}
+
+// PlatformResolution says which image to pull for a pull spec and platform
+type PlatformResolution struct {
+	// PullSpec is the repository with the digest of the platform's manifest
+	PullSpec string
+	// Digest is what the original pull spec refers to, which for a multi-arch
+	// image is its manifest list's digest
+	Digest         string
+	Platform       string
+	ManifestDigest string
+	// Platforms are all the platforms of a multi-arch image; it's empty for
+	// single-platform images
+	Platforms []string
+}
+
+// RegistryClient reads manifests from registries' v2 API, authenticating
+// with the same credentials and TLS settings as the pullers
+type RegistryClient struct {
+	credentials *RegistryCredentials
+	registries  []*RegistryConfig
+	rateLimiter *RegistryRateLimiter
+}
+
+// NewRegistryClient ...
+func NewRegistryClient(credentials *RegistryCredentials, registries []*RegistryConfig, rateLimiter *RegistryRateLimiter) *RegistryClient {
+	return &RegistryClient{credentials: credentials, registries: registries, rateLimiter: rateLimiter}
+}
+
+// ResolvePlatform finds the image for platform, such as linux/arm64, that
+// pullSpec refers to.  For a manifest list, that's one of its manifests.  A
+// single-platform image must be built for platform if requirePlatform is set;
+// otherwise it's taken as it is.  Registries such as Docker Hub count manifest
+// GETs, but not HEADs, against their pull rate limits, so the manifest is only
+// read once a HEAD says it's a manifest list, or if its platform is required.
+func (rc *RegistryClient) ResolvePlatform(pullSpec string, platform string, requirePlatform bool) (*PlatformResolution, error) {
+	selector, err := ParsePlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+	parsed := ParsePullSpec(pullSpec)
+	reference := parsed.Digest
+	if reference == "" {
+		reference = parsed.Tag
+	}
+	if reference == "" {
+		reference = "latest"
+	}
+	manifestPath := fmt.Sprintf("/v2/%s/manifests/%s", parsed.Repository, reference)
+	if !requirePlatform {
+		header, err := rc.head(pullSpec, manifestPath, manifestMediaTypes)
+		if _, ok := errors.Cause(err).(*TooManyRequestsError); ok {
+			return nil, err
+		}
+		if err != nil {
+			log.Debugf("unable to get manifest type of %s, reading it instead: %s", pullSpec, err.Error())
+		} else if mediaType := contentType(header); mediaType == mediaTypeDockerManifest || mediaType == mediaTypeOCIManifest {
+			return &PlatformResolution{PullSpec: pullSpec, Digest: header.Get("Docker-Content-Digest")}, nil
+		}
+	}
+	body, header, err := rc.get(pullSpec, manifestPath, manifestMediaTypes)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to get manifest of %s", pullSpec)
+	}
+	var m manifest
+	if err = json.Unmarshal(body, &m); err != nil {
+		return nil, errors.Annotatef(err, "unable to parse manifest of %s", pullSpec)
+	}
+	digest := header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+	resolution := &PlatformResolution{Digest: digest}
+
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType = contentType(header)
+	}
+	if mediaType == mediaTypeDockerManifestList || mediaType == mediaTypeOCIIndex {
+		var best *manifestDescriptor
+		bestExact := false
+		for _, descriptor := range m.Manifests {
+			// attestations and other non-images are listed with an unknown platform
+			if descriptor.Platform == nil || descriptor.Platform.OS == "unknown" {
+				continue
+			}
+			resolution.Platforms = append(resolution.Platforms, descriptor.Platform.String())
+			if matches, exact := selector.Matches(descriptor.Platform); matches && (best == nil || (exact && !bestExact)) {
+				best, bestExact = descriptor, exact
+			}
+		}
+		if best == nil {
+			return nil, fmt.Errorf("%s has no image for platform %s, only for %s", pullSpec, platform, strings.Join(resolution.Platforms, ", "))
+		}
+		resolution.Platform = best.Platform.String()
+		resolution.ManifestDigest = best.Digest
+		resolution.PullSpec = RepositoryOf(pullSpec) + "@" + best.Digest
+		return resolution, nil
+	}
+
+	resolution.PullSpec = pullSpec
+	resolution.ManifestDigest = digest
+	if m.Config == nil {
+		// a schema 1 manifest: there's no config to say what platform it's for
+		return resolution, nil
+	}
+	body, _, err = rc.get(pullSpec, fmt.Sprintf("/v2/%s/blobs/%s", parsed.Repository, m.Config.Digest), "")
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to get config of %s", pullSpec)
+	}
+	imagePlatform := &Platform{}
+	if err = json.Unmarshal(body, imagePlatform); err != nil {
+		return nil, errors.Annotatef(err, "unable to parse config of %s", pullSpec)
+	}
+	resolution.Platform = imagePlatform.String()
+	if matches, _ := selector.Matches(imagePlatform); !matches {
+		return nil, fmt.Errorf("%s is built for %s, not %s", pullSpec, resolution.Platform, platform)
+	}
+	return resolution, nil
+}
+
+// contentType returns a response's media type, without its parameters
+func contentType(header http.Header) string {
+	return strings.TrimSpace(strings.Split(header.Get("Content-Type"), ";")[0])
+}
+
+// get reads path from pullSpec's registry, authenticating if it asks us to
+func (rc *RegistryClient) get(pullSpec string, path string, accept string) ([]byte, http.Header, error) {
+	return rc.send("GET", pullSpec, path, accept)
+}
+
+// head returns the headers that get would, without the body
+func (rc *RegistryClient) head(pullSpec string, path string, accept string) (http.Header, error) {
+	_, header, err := rc.send("HEAD", pullSpec, path, accept)
+	return header, err
+}
+
+// send makes a GET or HEAD request to pullSpec's registry, counting the rate
+// limit headers of its response
+func (rc *RegistryClient) send(method string, pullSpec string, path string, accept string) ([]byte, http.Header, error) {
+	config := FindRegistryConfig(pullSpec, rc.registries)
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	// the TLS settings are read for every request, so that rotated
+	// certificates are picked up, and the transport that's built with them
+	// mustn't keep connections open once it's done
+	client := &http.Client{
+		Timeout:   registryClientTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment, DisableKeepAlives: true}}
+	auth := NeedsAuthHeader(&Image{PullSpec: pullSpec}, rc.credentials.Registries())
+
+	host := RegistryHost(pullSpec)
+	if host == dockerHubHost {
+		host = dockerHubAPIHost
+	}
+	schemes := []string{"https"}
+	if config.Insecure {
+		schemes = append(schemes, "http")
+	}
+	var resp *http.Response
+	for _, scheme := range schemes {
+		resp, err = rc.do(client, method, fmt.Sprintf("%s://%s%s", scheme, host, path), accept, auth)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if rc.rateLimiter != nil {
+		rc.rateLimiter.ObserveHeaders(pullSpec, resp.Header)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		body, _ := ioutil.ReadAll(resp.Body)
+		tooManyRequests := NewTooManyRequestsError(pullSpec, resp.Header, string(body))
+		if rc.rateLimiter != nil {
+			rc.rateLimiter.ObserveTooManyRequests(pullSpec, tooManyRequests.RetryAfter)
+		}
+		return nil, nil, tooManyRequests
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s %s returned status %s", method, path, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, errors.Annotatef(err, "unable to read %s", path)
+	}
+	return body, resp.Header, nil
+}
+
+// do sends a request, answering the registry's authentication challenge if it
+// has one
+func (rc *RegistryClient) do(client *http.Client, method string, requestURL string, accept string, auth *RegistryAuth) (*http.Response, error) {
+	resp, err := rc.request(client, method, requestURL, accept, "")
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	authorization, err := rc.authorize(client, challenge, auth)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to authenticate to %s", requestURL)
+	}
+	return rc.request(client, method, requestURL, accept, authorization)
+}
+
+func (rc *RegistryClient) request(client *http.Client, method string, requestURL string, accept string, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(method, requestURL, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := client.Do(req)
+	return resp, errors.Annotatef(err, "%s %s failed", method, requestURL)
+}
+
+// authorize returns the Authorization header that answers a WWW-Authenticate
+// challenge: basic auth, or a bearer token from the registry's token server
+func (rc *RegistryClient) authorize(client *http.Client, challenge string, auth *RegistryAuth) (string, error) {
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+	params := map[string]string{}
+	for _, match := range challengeParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	switch scheme {
+	case "basic":
+		if auth == nil || auth.User == "" {
+			return "", fmt.Errorf("registry requires credentials")
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.User+":"+auth.Password)), nil
+	case "bearer":
+		if auth != nil && auth.RegistryToken != "" {
+			return "Bearer " + auth.RegistryToken, nil
+		}
+		token, err := rc.fetchToken(client, params, auth)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", fmt.Errorf("unsupported authentication challenge %q", scheme)
+	}
+}
+
+// fetchToken gets a bearer token from the realm of a challenge, anonymously or
+// with the registry's credentials
+func (rc *RegistryClient) fetchToken(client *http.Client, params map[string]string, auth *RegistryAuth) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("bearer challenge has no realm")
+	}
+	values := url.Values{}
+	for _, name := range []string{"service", "scope"} {
+		if params[name] != "" {
+			values.Set(name, params[name])
+		}
+	}
+	var req *http.Request
+	var err error
+	if auth != nil && auth.IdentityToken != "" {
+		values.Set("grant_type", "refresh_token")
+		values.Set("refresh_token", auth.IdentityToken)
+		values.Set("client_id", tokenClientID)
+		req, err = http.NewRequest("POST", realm, strings.NewReader(values.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequest("GET", realm+"?"+values.Encode(), nil)
+		if err == nil && auth != nil && auth.User != "" {
+			req.SetBasicAuth(auth.User, auth.Password)
+		}
+	}
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Annotatef(err, "unable to get token from %s", realm)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token server %s returned status %s", realm, resp.Status)
+	}
+	var tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return "", errors.Annotatef(err, "unable to parse token from %s", realm)
+	}
+	token := tokenResponse.Token
+	if token == "" {
+		token = tokenResponse.AccessToken
+	}
+	if token == "" {
+		return "", fmt.Errorf("token server %s returned no token", realm)
+	}
+	// the token is short-lived, and a new one is fetched for every pull, so
+	// it's left to Redact's patterns rather than registered as a secret
+	log.Debugf("got registry token from %s", realm)
+	return token, nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	testManifestList = `{
+  "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+  "manifests": [
+    {"digest": "sha256:amd64", "platform": {"os": "linux", "architecture": "amd64"}},
+    {"digest": "sha256:armv7", "platform": {"os": "linux", "architecture": "arm", "variant": "v7"}},
+    {"digest": "sha256:arm64", "platform": {"os": "linux", "architecture": "arm64", "variant": "v8"}},
+    {"digest": "sha256:attestation", "platform": {"os": "unknown", "architecture": "unknown"}}
+  ]
+}`
+	testManifest = `{
+  "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+  "config": {"digest": "sha256:config"}
+}`
+	testConfig = `{"os": "linux", "architecture": "amd64", "config": {}}`
+)
+
+// fakeRegistry serves the manifest list at nginx:multi and a single amd64
+// manifest at nginx:single, to requests with a bearer token from its realm
+type fakeRegistry struct {
+	server        *httptest.Server
+	tokenRequests int
+	// manifestRequests counts the requests for manifests by method
+	manifestRequests map[string]int
+}
+
+func newFakeRegistry() *fakeRegistry {
+	registry := &fakeRegistry{manifestRequests: map[string]int{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		registry.tokenRequests++
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "user" || password != "password" || r.URL.Query().Get("scope") != "repository:nginx:pull" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token": "abc"}`)
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer abc" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:nginx:pull"`, registry.server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/v2/nginx/manifests/") {
+			registry.manifestRequests[r.Method]++
+		}
+		switch r.URL.Path {
+		case "/v2/nginx/manifests/multi":
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.list.v2+json")
+			w.Header().Set("Docker-Content-Digest", "sha256:list")
+			fmt.Fprint(w, testManifestList)
+		case "/v2/nginx/manifests/single":
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			fmt.Fprint(w, testManifest)
+		case "/v2/nginx/blobs/sha256:config":
+			fmt.Fprint(w, testConfig)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	registry.server = httptest.NewTLSServer(mux)
+	return registry
+}
+
+func RunRegistryClientTests() {
+	Describe("RegistryClient", func() {
+		var registry *fakeRegistry
+		var host string
+		var client *RegistryClient
+
+		BeforeEach(func() {
+			registry = newFakeRegistry()
+			host = strings.TrimPrefix(registry.server.URL, "https://")
+			credentials := NewRegistryCredentials([]*RegistryAuth{{URL: host, User: "user", Password: "password"}}, nil)
+			client = NewRegistryClient(credentials, []*RegistryConfig{{URL: host, Insecure: true}}, nil)
+		})
+
+		AfterEach(func() {
+			registry.server.Close()
+		})
+
+		It("should pick the platform's manifest from a manifest list", func() {
+			resolution, err := client.ResolvePlatform(host+"/nginx:multi", "linux/arm64", false)
+			Expect(err).To(BeNil())
+			Expect(resolution.PullSpec).To(Equal(host + "/nginx@sha256:arm64"))
+			Expect(resolution.Digest).To(Equal("sha256:list"))
+			Expect(resolution.Platform).To(Equal("linux/arm64/v8"))
+			Expect(resolution.ManifestDigest).To(Equal("sha256:arm64"))
+			Expect(resolution.Platforms).To(Equal([]string{"linux/amd64", "linux/arm/v7", "linux/arm64/v8"}))
+			Expect(registry.manifestRequests).To(Equal(map[string]int{"HEAD": 1, "GET": 1}))
+			Expect(registry.tokenRequests).To(Equal(2))
+		})
+
+		It("should fail for a platform that a manifest list doesn't have", func() {
+			_, err := client.ResolvePlatform(host+"/nginx:multi", "linux/s390x", false)
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("should check a single manifest's platform", func() {
+			resolution, err := client.ResolvePlatform(host+"/nginx:single", "linux/amd64", true)
+			Expect(err).To(BeNil())
+			Expect(resolution.PullSpec).To(Equal(host + "/nginx:single"))
+			Expect(resolution.Digest).To(Equal(fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(testManifest)))))
+			Expect(resolution.Platform).To(Equal("linux/amd64"))
+			Expect(resolution.Platforms).To(BeEmpty())
+			_, err = client.ResolvePlatform(host+"/nginx:single", "linux/arm64", true)
+			Expect(err).NotTo(BeNil())
+			Expect(registry.manifestRequests).To(Equal(map[string]int{"GET": 2}))
+		})
+
+		It("should take a single manifest as it is without reading it", func() {
+			resolution, err := client.ResolvePlatform(host+"/nginx:single", "linux/arm64", false)
+			Expect(err).To(BeNil())
+			Expect(resolution).To(Equal(&PlatformResolution{PullSpec: host + "/nginx:single"}))
+			Expect(registry.manifestRequests).To(Equal(map[string]int{"HEAD": 1}))
+		})
+
+		It("should fail without credentials for the token server", func() {
+			client = NewRegistryClient(NewRegistryCredentials(nil, nil), []*RegistryConfig{{URL: host, Insecure: true}}, nil)
+			_, err := client.ResolvePlatform(host+"/nginx:multi", "linux/amd64", false)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+}
@@ -0,0 +1,163 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/errors"
+)
+
+// RegistryConfig holds the settings for the registries that its URL applies
+// to, which is matched in the same way as RegistryAuth's
+type RegistryConfig struct {
+	URL string
+
+	// Insecure turns off certificate verification, and allows plain HTTP.
+	// Certificates are verified unless a registry is explicitly insecure.
+	Insecure bool
+	// CAFile is a PEM bundle of CAs to trust, besides the system's
+	CAFile string
+	// CertFile and KeyFile are a client certificate and key, for registries
+	// that require mutual TLS
+	CertFile string
+	KeyFile  string
+
+	// PullsPerMinute caps pulls from the registry's host, shared by every
+	// config for that host; 0 means no cap
+	PullsPerMinute int
+}
+
+// FindRegistryConfig returns the config that applies to the pull spec, or the
+// default config if none do
+func FindRegistryConfig(pullSpec string, registries []*RegistryConfig) *RegistryConfig {
+	urls := make([]string, len(registries))
+	for i, registry := range registries {
+		urls[i] = registry.URL
+	}
+	if index := bestRegistryMatch(pullSpec, urls); index >= 0 {
+		return registries[index]
+	}
+	return &RegistryConfig{}
+}
+
+// FindRegistryConfigForURL returns the config that applies to a registry URL,
+// such as a RegistryAuth's, which may have a scheme and a path: it's matched
+// on its host, port and path, as though it were a pull spec.
+func FindRegistryConfigForURL(url string, registries []*RegistryConfig) *RegistryConfig {
+	pattern := parseRegistryPattern(url)
+	parsed := &PullSpec{Host: pattern.host, Port: pattern.port, Repository: pattern.pathPrefix}
+	urls := make([]string, len(registries))
+	for i, registry := range registries {
+		urls[i] = registry.URL
+	}
+	if index := bestParsedRegistryMatch(parsed, urls); index >= 0 {
+		return registries[index]
+	}
+	return &RegistryConfig{}
+}
+
+// Validate checks that the TLS files can be read, and the caps make sense
+func (config *RegistryConfig) Validate() error {
+	if config.PullsPerMinute < 0 {
+		return fmt.Errorf("registry %s can't have a negative pull cap", config.URL)
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return fmt.Errorf("registry %s needs both a client certificate and key, or neither", config.URL)
+	}
+	_, err := config.TLSConfig()
+	return errors.Annotatef(err, "invalid TLS settings for registry %s", config.URL)
+}
+
+// TLSConfig returns the TLS settings for connecting to the registry
+func (config *RegistryConfig) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.Insecure}
+	if config.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		bundle, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to read CA bundle %s", config.CAFile)
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to load client certificate %s", config.CertFile)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// HasCertificates returns whether there's a CA bundle or client certificate
+func (config *RegistryConfig) HasCertificates() bool {
+	return config.CAFile != "" || config.CertFile != ""
+}
+
+// InstallCertificates copies the CA bundle and client certificate into dir,
+// laid out as docker's certs.d and skopeo's --cert-dir expect: CAs in
+// ca.crt, the client certificate in client.cert and its key in client.key.
+// They're copied every time, so that rotated certificates are picked up.
+func (config *RegistryConfig) InstallCertificates(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Annotatef(err, "unable to create certificate directory %s", dir)
+	}
+	files := map[string]string{
+		"ca.crt":      config.CAFile,
+		"client.cert": config.CertFile,
+		"client.key":  config.KeyFile}
+	for name, source := range files {
+		if source == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(source)
+		if err != nil {
+			return errors.Annotatef(err, "unable to read %s", source)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			return errors.Annotatef(err, "unable to write %s", filepath.Join(dir, name))
+		}
+	}
+	return nil
+}
+
+// RegistryHost returns the host, with its port if it has one, that a pull
+// spec is pulled from
+func RegistryHost(pullSpec string) string {
+	parsed := ParsePullSpec(pullSpec)
+	if parsed.Port == "" {
+		return parsed.Host
+	}
+	return parsed.Host + ":" + parsed.Port
+}
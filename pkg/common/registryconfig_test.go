@@ -0,0 +1,142 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func RunRegistryConfigTests() {
+	Describe("FindRegistryConfig", func() {
+		registries := []*RegistryConfig{
+			{URL: "insecure.io", Insecure: true},
+			{URL: "*.internal.io", CAFile: "/etc/ca.pem"},
+		}
+		It("should verify certificates of registries that aren't configured", func() {
+			Expect(FindRegistryConfig("registry.io/app:1.0", registries).Insecure).To(BeFalse())
+			Expect(FindRegistryConfig("insecure.io.evil.com/app:1.0", registries).Insecure).To(BeFalse())
+		})
+		It("should find the config for a registry", func() {
+			Expect(FindRegistryConfig("insecure.io/app:1.0", registries)).To(Equal(registries[0]))
+			Expect(FindRegistryConfig("a.internal.io/app:1.0", registries)).To(Equal(registries[1]))
+		})
+		It("should find the config for a registry URL with a scheme and path", func() {
+			Expect(FindRegistryConfigForURL("https://insecure.io/v2/", registries)).To(Equal(registries[0]))
+			Expect(FindRegistryConfigForURL("http://A.internal.io", registries)).To(Equal(registries[1]))
+			Expect(FindRegistryConfigForURL("https://registry.io/", registries).Insecure).To(BeFalse())
+		})
+	})
+
+	Describe("RegistryConfig", func() {
+		var server *httptest.Server
+		var dir string
+		var caFile string
+
+		BeforeEach(func() {
+			server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			}))
+			var err error
+			dir, err = ioutil.TempDir("", "registryconfig")
+			Expect(err).To(BeNil())
+			caFile = filepath.Join(dir, "ca.pem")
+			ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+			Expect(ioutil.WriteFile(caFile, ca, 0600)).To(BeNil())
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+		})
+
+		It("should verify certificates against the CA bundle", func() {
+			host := server.Listener.Addr().String()
+			tlsConfig, err := (&RegistryConfig{URL: host}).TLSConfig()
+			Expect(err).To(BeNil())
+			Expect(PingRegistry(host, tlsConfig, 5*time.Second)).NotTo(BeNil())
+
+			tlsConfig, err = (&RegistryConfig{URL: host, CAFile: caFile}).TLSConfig()
+			Expect(err).To(BeNil())
+			Expect(PingRegistry(host, tlsConfig, 5*time.Second)).To(BeNil())
+
+			tlsConfig, err = (&RegistryConfig{URL: host, Insecure: true}).TLSConfig()
+			Expect(err).To(BeNil())
+			Expect(PingRegistry(host, tlsConfig, 5*time.Second)).To(BeNil())
+		})
+
+		It("should close its connection after a ping", func() {
+			var mutex sync.Mutex
+			open := 0
+			probed := httptest.NewUnstartedServer(server.Config.Handler)
+			probed.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				switch state {
+				case http.StateNew:
+					open++
+				case http.StateClosed, http.StateHijacked:
+					open--
+				}
+			}
+			probed.StartTLS()
+			defer probed.Close()
+
+			host := probed.Listener.Addr().String()
+			tlsConfig, err := (&RegistryConfig{URL: host, Insecure: true}).TLSConfig()
+			Expect(err).To(BeNil())
+			for i := 0; i < 3; i++ {
+				Expect(PingRegistry(host, tlsConfig, 5*time.Second)).To(BeNil())
+			}
+			Eventually(func() int {
+				mutex.Lock()
+				defer mutex.Unlock()
+				return open
+			}).Should(Equal(0))
+		})
+
+		It("should install certificates as docker and skopeo expect them", func() {
+			config := &RegistryConfig{URL: "registry.io", CAFile: caFile}
+			Expect(config.Validate()).To(BeNil())
+			Expect(config.InstallCertificates(filepath.Join(dir, "registry.io"))).To(BeNil())
+			installed, err := ioutil.ReadFile(filepath.Join(dir, "registry.io", "ca.crt"))
+			Expect(err).To(BeNil())
+			original, _ := ioutil.ReadFile(caFile)
+			Expect(installed).To(Equal(original))
+		})
+
+		It("should reject a client certificate without a key", func() {
+			Expect((&RegistryConfig{URL: "registry.io", CertFile: caFile}).Validate()).NotTo(BeNil())
+			Expect((&RegistryConfig{URL: "registry.io", CAFile: filepath.Join(dir, "missing.pem")}).Validate()).NotTo(BeNil())
+		})
+	})
+}
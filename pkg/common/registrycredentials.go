@@ -0,0 +1,173 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// RegistryCredentials holds the credentials for private registries.  Those
+// read from docker config files are reloaded whenever the files change, so
+// that credentials can be rotated without a restart, and those from
+// credential providers are renewed before they expire.
+type RegistryCredentials struct {
+	registries        []*RegistryAuth
+	dockerConfigPaths []string
+	providers         []*cachedCredentials
+	// fromFiles and contents are keyed by docker config path
+	fromFiles map[string][]*RegistryAuth
+	contents  map[string]string
+	mutex     sync.RWMutex
+}
+
+// NewRegistryCredentials loads the docker config files, which don't have to
+// exist yet.  registries are used as well, after the files' credentials.
+func NewRegistryCredentials(registries []*RegistryAuth, dockerConfigPaths []string) *RegistryCredentials {
+	rc := &RegistryCredentials{
+		registries:        registries,
+		dockerConfigPaths: dockerConfigPaths,
+		fromFiles:         map[string][]*RegistryAuth{},
+		contents:          map[string]string{}}
+	for _, path := range dockerConfigPaths {
+		rc.reload(path)
+	}
+	return rc
+}
+
+// AddProvider adds a source of short-lived credentials
+func (rc *RegistryCredentials) AddProvider(provider CredentialProvider) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	rc.providers = append(rc.providers, &cachedCredentials{provider: provider})
+}
+
+// Registries returns the current credentials: those from the docker config
+// files, in the order the files were given, then those from the credential
+// providers, and then the others.  Providers that can't come up with
+// credentials are left out, and backed off from so as not to slow this down.
+func (rc *RegistryCredentials) Registries() []*RegistryAuth {
+	rc.mutex.RLock()
+	registries := []*RegistryAuth{}
+	for _, path := range rc.dockerConfigPaths {
+		registries = append(registries, rc.fromFiles[path]...)
+	}
+	providers := rc.providers
+	rc.mutex.RUnlock()
+	for _, provider := range providers {
+		auth, err := provider.get()
+		if err != nil {
+			log.Errorf("leaving out registry credentials: %s", err.Error())
+			continue
+		}
+		registries = append(registries, auth)
+	}
+	return append(registries, rc.registries...)
+}
+
+// reload reads a docker config file again.  If it's been removed, its
+// credentials are dropped; if it can't be read or parsed, the credentials
+// from its last good version are kept.  So are they if it's empty, since
+// that's how a file that's being rewritten looks to begin with.
+func (rc *RegistryCredentials) reload(path string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		RecordEvent("unable to read docker config")
+		log.Errorf("unable to read registry credentials from %s: %s", path, err.Error())
+		return
+	}
+	if err == nil && len(data) == 0 {
+		log.Debugf("ignoring empty docker config %s", path)
+		return
+	}
+	rc.mutex.RLock()
+	unchanged := string(data) == rc.contents[path]
+	rc.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+	registries := []*RegistryAuth{}
+	if err == nil {
+		registries, err = ParseDockerConfig(data)
+		if err != nil {
+			RecordEvent("unable to parse docker config")
+			log.Errorf("unable to read registry credentials from %s: %s", path, err.Error())
+			return
+		}
+	}
+	rc.mutex.Lock()
+	rc.fromFiles[path] = registries
+	rc.contents[path] = string(data)
+	rc.mutex.Unlock()
+	RecordEvent("load docker config")
+	log.Infof("loaded credentials for %d registries from %s", len(registries), path)
+}
+
+// Watch reloads the docker config files whenever they change, until stop is
+// closed.  Their directories are watched rather than the files themselves,
+// since Kubernetes updates mounted secrets by swapping a symlink to a
+// directory, and editors tend to replace files rather than write them.
+func (rc *RegistryCredentials) Watch(stop <-chan struct{}) error {
+	if len(rc.dockerConfigPaths) == 0 {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Annotate(err, "unable to watch docker config files")
+	}
+	watched := map[string]bool{}
+	for _, path := range rc.dockerConfigPaths {
+		dir := filepath.Dir(path)
+		if watched[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			log.Warnf("unable to watch %s for registry credentials, changes to %s won't be picked up: %s", dir, path, err.Error())
+			continue
+		}
+		watched[dir] = true
+	}
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-stop:
+				return
+			case event := <-watcher.Events:
+				for _, path := range rc.dockerConfigPaths {
+					if filepath.Dir(path) == filepath.Dir(event.Name) {
+						rc.reload(path)
+					}
+				}
+			case err := <-watcher.Errors:
+				log.Errorf("error while watching docker config files: %s", err.Error())
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WaitForShutdown blocks until stop is closed, or the process is asked to
+// terminate with SIGTERM or SIGINT.  stop may be nil.
+func WaitForShutdown(stop <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+	select {
+	case sig := <-signals:
+		log.Infof("received signal %s, shutting down", sig)
+	case <-stop:
+		log.Infof("stop requested, shutting down")
+	}
+}
+
+// ShutdownHTTPServer stops the server from accepting new connections, and
+// gives requests in progress up to timeout to complete
+func ShutdownHTTPServer(server *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Errorf("unable to shut down HTTP server on %s cleanly: %s", server.Addr, err.Error())
+		return
+	}
+	log.Infof("HTTP server on %s shut down", server.Addr)
+}
@@ -22,18 +22,49 @@ under the License.
 package common
 
 import (
-	"strings"
-
 	imageInterface "github.com/blackducksoftware/perceptor-scanner/pkg/interfaces"
 )
 
 // NeedsAuthHeader will verify the given image is required authentication credentials for pulling the Docker image.
-// if Yes, it will return the corresponding registration auth
+// if Yes, it will return the corresponding registration auth.  Registries match as in bestRegistryMatch,
+// and empty or unparseable pull specs match none of them.
 func NeedsAuthHeader(image imageInterface.Image, registries []*RegistryAuth) *RegistryAuth {
-	for _, registry := range registries {
-		if strings.HasPrefix(image.DockerPullSpec(), registry.URL) {
-			return registry
-		}
+	urls := make([]string, len(registries))
+	for i, registry := range registries {
+		urls[i] = registry.URL
+	}
+	if index := bestRegistryMatch(image.DockerPullSpec(), urls); index >= 0 {
+		return registries[index]
 	}
 	return nil
 }
+
+// bestRegistryMatch returns the index of the registry URL that applies to the pull spec, or -1 if none do.
+// Registries match on host and port, with an exact host name winning over a wildcard such as *.example.com,
+// and then the longest repository path prefix winning.  Host names match whatever their case.
+func bestRegistryMatch(pullSpec string, urls []string) int {
+	if !isValidPullSpec(pullSpec) {
+		return -1
+	}
+	return bestParsedRegistryMatch(ParsePullSpec(pullSpec), urls)
+}
+
+// bestParsedRegistryMatch is bestRegistryMatch for a pull spec that's already been parsed
+func bestParsedRegistryMatch(parsed *PullSpec, urls []string) int {
+	best := -1
+	var bestPattern *registryPattern
+	bestExactHost := false
+	for i, url := range urls {
+		pattern := parseRegistryPattern(url)
+		matches, exactHost := pattern.matches(parsed)
+		if !matches {
+			continue
+		}
+		if best < 0 ||
+			(exactHost && !bestExactHost) ||
+			(exactHost == bestExactHost && len(pattern.pathPrefix) > len(bestPattern.pathPrefix)) {
+			best, bestPattern, bestExactHost = i, pattern, exactHost
+		}
+	}
+	return best
+}
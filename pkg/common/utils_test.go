@@ -23,7 +23,6 @@ package common
 
 import (
 	"fmt"
-	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -48,11 +47,31 @@ func RunUtilsTests() {
 			{URL: "abc.def:5000", User: "", Password: ""},
 			{URL: "docker-registry.default.svc:5000", User: "", Password: ""},
 			{URL: "172.1.1.0:abcd", User: "", Password: ""},
+			{URL: "registry.io", User: "", Password: ""},
+			{URL: "registry.io/team", User: "", Password: ""},
+			{URL: "registry.io/team/app", User: "", Password: ""},
+			{URL: "*.example.com", User: "", Password: ""},
+			{URL: "special.example.com", User: "", Password: ""},
+			{URL: "https://index.docker.io/v1/", User: "", Password: ""},
+			{URL: "docker.io/myorg", User: "", Password: ""},
 		}
 		testCases := []*testImage{
 			{
 				pullSpec: "", registryAuth: nil,
 			},
+			// nor do pull specs that don't name a repository
+			{
+				pullSpec: "abc.def:5000/", registryAuth: nil,
+			},
+			{
+				pullSpec: "abc.def:5000//qqq", registryAuth: nil,
+			},
+			{
+				pullSpec: "alpine:", registryAuth: nil,
+			},
+			{
+				pullSpec: "alpine 3.8", registryAuth: nil,
+			},
 			{
 				pullSpec: "abc.def:5000/qqq", registryAuth: internalDockerRegistries[0],
 			},
@@ -65,6 +84,57 @@ func RunUtilsTests() {
 			{
 				pullSpec: "172.1.1.0:abc/abc", registryAuth: nil,
 			},
+			// a prefix of the host name isn't the host
+			{
+				pullSpec: "registry.io.evil.com/team/app:1.0", registryAuth: nil,
+			},
+			// nor is the same host on another port
+			{
+				pullSpec: "registry.io:5000/team/app:1.0", registryAuth: nil,
+			},
+			// the longest repository path prefix wins, whatever the order
+			{
+				pullSpec: "registry.io/other:1.0", registryAuth: internalDockerRegistries[3],
+			},
+			{
+				pullSpec: "registry.io/team/tool:1.0", registryAuth: internalDockerRegistries[4],
+			},
+			{
+				pullSpec: "registry.io/team/app@sha256:abc", registryAuth: internalDockerRegistries[5],
+			},
+			{
+				pullSpec: "registry.io/teamwork/app:1.0", registryAuth: internalDockerRegistries[3],
+			},
+			// wildcards match a single label, and an exact host wins over them
+			{
+				pullSpec: "a.example.com/app:1.0", registryAuth: internalDockerRegistries[6],
+			},
+			{
+				pullSpec: "a.b.example.com/app:1.0", registryAuth: nil,
+			},
+			{
+				pullSpec: "special.example.com/app:1.0", registryAuth: internalDockerRegistries[7],
+			},
+			// host names match whatever their case
+			{
+				pullSpec: "ABC.def:5000/qqq", registryAuth: internalDockerRegistries[0],
+			},
+			{
+				pullSpec: "A.Example.COM/app:1.0", registryAuth: internalDockerRegistries[6],
+			},
+			// Docker Hub, by any of its names
+			{
+				pullSpec: "alpine:3.8", registryAuth: internalDockerRegistries[8],
+			},
+			{
+				pullSpec: "index.docker.io/library/alpine", registryAuth: internalDockerRegistries[8],
+			},
+			{
+				pullSpec: "myorg/app:1.0", registryAuth: internalDockerRegistries[9],
+			},
+			{
+				pullSpec: "docker.io/myorg/app:1.0", registryAuth: internalDockerRegistries[9],
+			},
 		}
 		for _, testCase := range testCases {
 			c := testCase
@@ -75,8 +145,3 @@ func RunUtilsTests() {
 		}
 	})
 }
-
-func TestUtil(t *testing.T) {
-	RunUtilsTests()
-	RunMetricsTests()
-}
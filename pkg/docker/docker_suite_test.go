@@ -31,5 +31,6 @@ import (
 func TestDocker(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunHeaderEncoderTests()
+	RunImagePullerTests()
 	RunSpecs(t, "docker suite")
 }
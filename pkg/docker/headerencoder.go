@@ -23,21 +23,38 @@ package docker
 
 import (
 	b64 "encoding/base64"
-	"fmt"
+	"encoding/json"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/juju/errors"
 )
 
-func base64Encode(data string) string {
-	return b64.StdEncoding.EncodeToString([]byte(data))
+// authConfig is the JSON that the docker daemon expects in X-Registry-Auth
+type authConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+	// IdentityToken is exchanged by the daemon for a registry token
+	IdentityToken string `json:"identitytoken,omitempty"`
+	// RegistryToken is sent to the registry as a bearer token
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+// base64Encode uses URL-safe base64, as the docker API expects for X-Registry-Auth
+func base64Encode(data []byte) string {
+	return b64.URLEncoding.EncodeToString(data)
 }
 
-func encodeAuthHeader(username string, password string) string {
-	data := fmt.Sprintf("{ \"username\": \"%s\", \"password\": \"%s\" }", username, password)
-	// fmt.Printf("debug:<\n%s\n>done debug %d\n\n", data, len(data))
-	// bytesIn := []byte(data)
-	// fmt.Printf("bytes in:<\n%#v\n>done bytes in %d\n\n", bytesIn, len(bytesIn))
-	encoded := base64Encode(data)
-	// fmt.Printf("encoded:<\n%s\n>done encoded %d\n\n", encoded, len(encoded))
-	// bytesOut := []byte(encoded)
-	// fmt.Printf("bytes out:<\n%#v\n>done bytes out %d\n\n", bytesOut, len(bytesOut))
-	return encoded
+// encodeAuthHeader builds the X-Registry-Auth header for pulling from serverAddress
+func encodeAuthHeader(auth *common.RegistryAuth, serverAddress string) (string, error) {
+	data, err := json.Marshal(&authConfig{
+		Username:      auth.User,
+		Password:      auth.Password,
+		ServerAddress: serverAddress,
+		IdentityToken: auth.IdentityToken,
+		RegistryToken: auth.RegistryToken})
+	if err != nil {
+		return "", errors.Annotate(err, "unable to marshal registry auth")
+	}
+	return base64Encode(data), nil
 }
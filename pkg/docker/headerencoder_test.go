@@ -22,11 +22,16 @@ under the License.
 package docker
 
 import (
+	b64 "encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
-var expected1 = `eyAidXNlcm5hbWUiOiAiYWRtaW4iLCAicGFzc3dvcmQiOiAiZXlKaGJHY2lPaUpTVXpJMU5pSXNJblI1Y0NJNklrcFhWQ0o5LmV5SnBjM01pT2lKcmRXSmxjbTVsZEdWekwzTmxjblpwWTJWaFkyTnZkVzUwSWl3aWEzVmlaWEp1WlhSbGN5NXBieTl6WlhKMmFXTmxZV05qYjNWdWRDOXVZVzFsYzNCaFkyVWlPaUppWkhNdGNHVnlZMlZ3ZEc5eUlpd2lhM1ZpWlhKdVpYUmxjeTVwYnk5elpYSjJhV05sWVdOamIzVnVkQzl6WldOeVpYUXVibUZ0WlNJNkluQmxjbU5sY0hSdmNpMXpZMkZ1Ym1WeUxYTmhMWFJ2YTJWdUxUWm1jM3AwSWl3aWEzVmlaWEp1WlhSbGN5NXBieTl6WlhKMmFXTmxZV05qYjNWdWRDOXpaWEoyYVdObExXRmpZMjkxYm5RdWJtRnRaU0k2SW5CbGNtTmxjSFJ2Y2kxelkyRnVibVZ5TFhOaElpd2lhM1ZpWlhKdVpYUmxjeTVwYnk5elpYSjJhV05sWVdOamIzVnVkQzl6WlhKMmFXTmxMV0ZqWTI5MWJuUXVkV2xrSWpvaU5EVTNOamxqTUdRdE1qRTRNUzB4TVdVNExUZzJaREl0TURZMk4yUTRaV1F3WXpoaElpd2ljM1ZpSWpvaWMzbHpkR1Z0T25ObGNuWnBZMlZoWTJOdmRXNTBPbUprY3kxd1pYSmpaWEIwYjNJNmNHVnlZMlZ3ZEc5eUxYTmpZVzV1WlhJdGMyRWlmUS5qLUY5cjZMQ2FwT2ZSSGlhdXV3UnZjaVFPWDZWZHNZWExmdUFTOVpiMWpUMEVnWGt3RzMxYkNSNXZUbDBTY2hxVlk5Vy00QkpNQ3lqOXcyQW9KcG12UW85eGxrU1d3ZkFwV1hIUmFPTVdMY0taQzlvcVVUcEduWm92QUtBOE54ZXJwZlM2d0FzanAtQm44SEpwZ3h3OUVfeTBrZ2ZOYWxhSWIxLUNQS2NIRjZ2aFBRMzFLNkVEc2ZCRTdGTnlja3JscGc4a2ZsRDlBWEQ0c0JQckk0SE11c1dHUmo4Q05jd0lFZEF2OFRXV3c3amVlVWh4bll0LUhBbWhkQUh0elktenRLNE1YZ1l6ME1ra2pUbHFfcjM1YmV4WC1XTGo2Y1A0NnFBYmY0ZWUzc1hOMWt6LTQ1UHhWaFM1VUhDLV9DOU9KX1lRM19GVm4zbU93Qm9JOGN4NUEiIH0=`
+var expected1 = "eyJ1c2VybmFtZSI6ImFkbWluIiwicGFzc3dvcmQiOiJleUpoYkdjaU9pSlNVekkxTmlJc0luUjVjQ0k2SWtwWFZDSjkuZXlKcGMzTWlPaUpyZFdKbGNtNWxkR1Z6TDNObGNuWnBZMlZoWTJOdmRXNTBJaXdpYTNWaVpYSnVaWFJsY3k1cGJ5OXpaWEoyYVdObFlXTmpiM1Z1ZEM5dVlXMWxjM0JoWTJVaU9pSmlaSE10Y0dWeVkyVndkRzl5SWl3aWEzVmlaWEp1WlhSbGN5NXBieTl6WlhKMmFXTmxZV05qYjNWdWRDOXpaV055WlhRdWJtRnRaU0k2SW5CbGNtTmxjSFJ2Y2kxelkyRnVibVZ5TFhOaExYUnZhMlZ1TFRabWMzcDBJaXdpYTNWaVpYSnVaWFJsY3k1cGJ5OXpaWEoyYVdObFlXTmpiM1Z1ZEM5elpYSjJhV05sTFdGalkyOTFiblF1Ym1GdFpTSTZJbkJsY21ObGNIUnZjaTF6WTJGdWJtVnlMWE5oSWl3aWEzVmlaWEp1WlhSbGN5NXBieTl6WlhKMmFXTmxZV05qYjNWdWRDOXpaWEoyYVdObExXRmpZMjkxYm5RdWRXbGtJam9pTkRVM05qbGpNR1F0TWpFNE1TMHhNV1U0TFRnMlpESXRNRFkyTjJRNFpXUXdZemhoSWl3aWMzVmlJam9pYzNsemRHVnRPbk5sY25acFkyVmhZMk52ZFc1ME9tSmtjeTF3WlhKalpYQjBiM0k2Y0dWeVkyVndkRzl5TFhOallXNXVaWEl0YzJFaWZRLmotRjlyNkxDYXBPZlJIaWF1dXdSdmNpUU9YNlZkc1lYTGZ1QVM5WmIxalQwRWdYa3dHMzFiQ1I1dlRsMFNjaHFWWTlXLTRCSk1DeWo5dzJBb0pwbXZRbzl4bGtTV3dmQXBXWEhSYU9NV0xjS1pDOW9xVVRwR25ab3ZBS0E4TnhlcnBmUzZ3QXNqcC1CbjhISnBneHc5RV95MGtnZk5hbGFJYjEtQ1BLY0hGNnZoUFEzMUs2RURzZkJFN0ZOeWNrcmxwZzhrZmxEOUFYRDRzQlBySTRITXVzV0dSajhDTmN3SUVkQXY4VFdXdzdqZWVVaHhuWXQtSEFtaGRBSHR6WS16dEs0TVhnWXowTWtralRscV9yMzViZXhYLVdMajZjUDQ2cUFiZjRlZTNzWE4xa3otNDVQeFZoUzVVSEMtX0M5T0pfWVEzX0ZWbjNtT3dCb0k4Y3g1QSIsInNlcnZlcmFkZHJlc3MiOiJyZWdpc3RyeS5leGFtcGxlLmNvbTo1MDAwIn0="
 
 var password1 = `eyJhbGciOiJSUzI1NiIsInR5cCI6IkpXVCJ9.eyJpc3MiOiJrdWJlcm5ldGVzL3NlcnZpY2VhY2NvdW50Iiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9uYW1lc3BhY2UiOiJiZHMtcGVyY2VwdG9yIiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9zZWNyZXQubmFtZSI6InBlcmNlcHRvci1zY2FubmVyLXNhLXRva2VuLTZmc3p0Iiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9zZXJ2aWNlLWFjY291bnQubmFtZSI6InBlcmNlcHRvci1zY2FubmVyLXNhIiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9zZXJ2aWNlLWFjY291bnQudWlkIjoiNDU3NjljMGQtMjE4MS0xMWU4LTg2ZDItMDY2N2Q4ZWQwYzhhIiwic3ViIjoic3lzdGVtOnNlcnZpY2VhY2NvdW50OmJkcy1wZXJjZXB0b3I6cGVyY2VwdG9yLXNjYW5uZXItc2EifQ.j-F9r6LCapOfRHiauuwRvciQOX6VdsYXLfuAS9Zb1jT0EgXkwG31bCR5vTl0SchqVY9W-4BJMCyj9w2AoJpmvQo9xlkSWwfApWXHRaOMWLcKZC9oqUTpGnZovAKA8NxerpfS6wAsjp-Bn8HJpgxw9E_y0kgfNalaIb1-CPKcHF6vhPQ31K6EDsfBE7FNyckrlpg8kflD9AXD4sBPrI4HMusWGRj8CNcwIEdAv8TWWw7jeeUhxnYt-HAmhdAHtzY-ztK4MXgYz0MkkjTlq_r35bexX-WLj6cP46qAbf4ee3sXN1kz-45PxVhS5UHC-_C9OJ_YQ3_FVn3mOwBoI8cx5A`
 
@@ -38,41 +43,92 @@ var password2 = "abc123def456"
 
 var password3 = "abc123def456sdfjhsafdklhasdkfjlhaslkjhfklashfkjashvnsdvsvkjsadhfkshfkshf3246238946238kjsdjfkhskjfhkashfklashfkashfkjhAHDSFHDKJFHDSKJFHDSKFYERKJHDSFKJDHSFKHSDF"
 
-var expected2 = "eyAidXNlcm5hbWUiOiAiYWRtaW4iLCAicGFzc3dvcmQiOiAiYWJjMTIzZGVmNDU2IiB9"
+var expected2 = "eyJ1c2VybmFtZSI6ImFkbWluIiwicGFzc3dvcmQiOiJhYmMxMjNkZWY0NTYiLCJzZXJ2ZXJhZGRyZXNzIjoicmVnaXN0cnkuZXhhbXBsZS5jb206NTAwMCJ9"
 
-var expected3 = "eyAidXNlcm5hbWUiOiAiYWRtaW4iLCAicGFzc3dvcmQiOiAiYWJjMTIzZGVmNDU2c2RmamhzYWZka2xoYXNka2ZqbGhhc2xramhma2xhc2hma2phc2h2bnNkdnN2a2pzYWRoZmtzaGZrc2hmMzI0NjIzODk0NjIzOGtqc2RqZmtoc2tqZmhrYXNoZmtsYXNoZmthc2hma2poQUhEU0ZIREtKRkhEU0tKRkhEU0tGWUVSS0pIRFNGS0pESFNGS0hTREYiIH0="
+var expected3 = "eyJ1c2VybmFtZSI6ImFkbWluIiwicGFzc3dvcmQiOiJhYmMxMjNkZWY0NTZzZGZqaHNhZmRrbGhhc2RrZmpsaGFzbGtqaGZrbGFzaGZramFzaHZuc2R2c3ZranNhZGhma3NoZmtzaGYzMjQ2MjM4OTQ2MjM4a2pzZGpma2hza2pmaGthc2hma2xhc2hma2FzaGZramhBSERTRkhES0pGSERTS0pGSERTS0ZZRVJLSkhEU0ZLSkRIU0ZLSFNERiIsInNlcnZlcmFkZHJlc3MiOiJyZWdpc3RyeS5leGFtcGxlLmNvbTo1MDAwIn0="
 
-var data4 = `{ "username": "admin", "password": "eyJhbGciOiJSUzI1NiIsInR5cCI6IkpXVCJ9.eyJpc3MiOiJrdWJlcm5ldGVzL3NlcnZpY2VhY2NvdW50Iiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9uYW1lc3BhY2UiOiJiZHMtcGVyY2VwdG9yIiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9zZWNyZXQubmFtZSI6InBlcmNlcHRvci1zY2FubmVyLXNhLXRva2VuLWZtOWs4Iiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9zZXJ2aWNlLWFjY291bnQubmFtZSI6InBlcmNlcHRvci1zY2FubmVyLXNhIiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9zZXJ2aWNlLWFjY291bnQudWlkIjoiN2VmOTNjNzktMjI0MC0xMWU4LWI1NTgtMDZlZGE4YzUwOGZhIiwic3ViIjoic3lzdGVtOnNlcnZpY2VhY2NvdW50OmJkcy1wZXJjZXB0b3I6cGVyY2VwdG9yLXNjYW5uZXItc2EifQ.Nh6ah_FIHSU2zd502dq-_gvEakf1OfdIN6TmarFHz5C05CSjPEfi4R96gjBDWkuynFFDyxFiddWbI9YjHR_HylS9kfelUP5s6fVjHywaNrKKgfmB9LzkU67LUochkIruLIyzVbbDV0NMwOaE4M1VV__ejn3TwJpyb0Q3l9dMcZDcbiENYxfRtNNQ4N_ccR51XkGOR0ySOneOZwCHgVE4mliDYtpPGenKtMd6dXMmE4sGfzAtxqqnSShGhbDp1BvjFxy9ZkbWjhUIRSXupJ4A4J4xtrS0lag4ngeRNNOlMa4HNjpgyjT5ZKnnSAqJS6McoBz-P3qOZTy13qkLRHv4BA" }`
+var data4 = `{"username":"admin","password":"eyJhbGciOiJSUzI1NiIsInR5cCI6IkpXVCJ9.eyJpc3MiOiJrdWJlcm5ldGVzL3NlcnZpY2VhY2NvdW50Iiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9uYW1lc3BhY2UiOiJiZHMtcGVyY2VwdG9yIiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9zZWNyZXQubmFtZSI6InBlcmNlcHRvci1zY2FubmVyLXNhLXRva2VuLWZtOWs4Iiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9zZXJ2aWNlLWFjY291bnQubmFtZSI6InBlcmNlcHRvci1zY2FubmVyLXNhIiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9zZXJ2aWNlLWFjY291bnQudWlkIjoiN2VmOTNjNzktMjI0MC0xMWU4LWI1NTgtMDZlZGE4YzUwOGZhIiwic3ViIjoic3lzdGVtOnNlcnZpY2VhY2NvdW50OmJkcy1wZXJjZXB0b3I6cGVyY2VwdG9yLXNjYW5uZXItc2EifQ.Nh6ah_FIHSU2zd502dq-_gvEakf1OfdIN6TmarFHz5C05CSjPEfi4R96gjBDWkuynFFDyxFiddWbI9YjHR_HylS9kfelUP5s6fVjHywaNrKKgfmB9LzkU67LUochkIruLIyzVbbDV0NMwOaE4M1VV__ejn3TwJpyb0Q3l9dMcZDcbiENYxfRtNNQ4N_ccR51XkGOR0ySOneOZwCHgVE4mliDYtpPGenKtMd6dXMmE4sGfzAtxqqnSShGhbDp1BvjFxy9ZkbWjhUIRSXupJ4A4J4xtrS0lag4ngeRNNOlMa4HNjpgyjT5ZKnnSAqJS6McoBz-P3qOZTy13qkLRHv4BA","serveraddress":"registry.example.com:5000"}`
 var password4 = `eyJhbGciOiJSUzI1NiIsInR5cCI6IkpXVCJ9.eyJpc3MiOiJrdWJlcm5ldGVzL3NlcnZpY2VhY2NvdW50Iiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9uYW1lc3BhY2UiOiJiZHMtcGVyY2VwdG9yIiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9zZWNyZXQubmFtZSI6InBlcmNlcHRvci1zY2FubmVyLXNhLXRva2VuLWZtOWs4Iiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9zZXJ2aWNlLWFjY291bnQubmFtZSI6InBlcmNlcHRvci1zY2FubmVyLXNhIiwia3ViZXJuZXRlcy5pby9zZXJ2aWNlYWNjb3VudC9zZXJ2aWNlLWFjY291bnQudWlkIjoiN2VmOTNjNzktMjI0MC0xMWU4LWI1NTgtMDZlZGE4YzUwOGZhIiwic3ViIjoic3lzdGVtOnNlcnZpY2VhY2NvdW50OmJkcy1wZXJjZXB0b3I6cGVyY2VwdG9yLXNjYW5uZXItc2EifQ.Nh6ah_FIHSU2zd502dq-_gvEakf1OfdIN6TmarFHz5C05CSjPEfi4R96gjBDWkuynFFDyxFiddWbI9YjHR_HylS9kfelUP5s6fVjHywaNrKKgfmB9LzkU67LUochkIruLIyzVbbDV0NMwOaE4M1VV__ejn3TwJpyb0Q3l9dMcZDcbiENYxfRtNNQ4N_ccR51XkGOR0ySOneOZwCHgVE4mliDYtpPGenKtMd6dXMmE4sGfzAtxqqnSShGhbDp1BvjFxy9ZkbWjhUIRSXupJ4A4J4xtrS0lag4ngeRNNOlMa4HNjpgyjT5ZKnnSAqJS6McoBz-P3qOZTy13qkLRHv4BA`
 
-var expected4 = `eyAidXNlcm5hbWUiOiAiYWRtaW4iLCAicGFzc3dvcmQiOiAiZXlKaGJHY2lPaUpTVXpJMU5pSXNJblI1Y0NJNklrcFhWQ0o5LmV5SnBjM01pT2lKcmRXSmxjbTVsZEdWekwzTmxjblpwWTJWaFkyTnZkVzUwSWl3aWEzVmlaWEp1WlhSbGN5NXBieTl6WlhKMmFXTmxZV05qYjNWdWRDOXVZVzFsYzNCaFkyVWlPaUppWkhNdGNHVnlZMlZ3ZEc5eUlpd2lhM1ZpWlhKdVpYUmxjeTVwYnk5elpYSjJhV05sWVdOamIzVnVkQzl6WldOeVpYUXVibUZ0WlNJNkluQmxjbU5sY0hSdmNpMXpZMkZ1Ym1WeUxYTmhMWFJ2YTJWdUxXWnRPV3M0SWl3aWEzVmlaWEp1WlhSbGN5NXBieTl6WlhKMmFXTmxZV05qYjNWdWRDOXpaWEoyYVdObExXRmpZMjkxYm5RdWJtRnRaU0k2SW5CbGNtTmxjSFJ2Y2kxelkyRnVibVZ5TFhOaElpd2lhM1ZpWlhKdVpYUmxjeTVwYnk5elpYSjJhV05sWVdOamIzVnVkQzl6WlhKMmFXTmxMV0ZqWTI5MWJuUXVkV2xrSWpvaU4yVm1PVE5qTnprdE1qSTBNQzB4TVdVNExXSTFOVGd0TURabFpHRTRZelV3T0daaElpd2ljM1ZpSWpvaWMzbHpkR1Z0T25ObGNuWnBZMlZoWTJOdmRXNTBPbUprY3kxd1pYSmpaWEIwYjNJNmNHVnlZMlZ3ZEc5eUxYTmpZVzV1WlhJdGMyRWlmUS5OaDZhaF9GSUhTVTJ6ZDUwMmRxLV9ndkVha2YxT2ZkSU42VG1hckZIejVDMDVDU2pQRWZpNFI5NmdqQkRXa3V5bkZGRHl4RmlkZFdiSTlZakhSX0h5bFM5a2ZlbFVQNXM2ZlZqSHl3YU5yS0tnZm1COUx6a1U2N0xVb2Noa0lydUxJeXpWYmJEVjBOTXdPYUU0TTFWVl9fZWpuM1R3SnB5YjBRM2w5ZE1jWkRjYmlFTll4ZlJ0Tk5RNE5fY2NSNTFYa0dPUjB5U09uZU9ad0NIZ1ZFNG1saURZdHBQR2VuS3RNZDZkWE1tRTRzR2Z6QXR4cXFuU1NoR2hiRHAxQnZqRnh5OVprYldqaFVJUlNYdXBKNEE0SjR4dHJTMGxhZzRuZ2VSTk5PbE1hNEhOanBneWpUNVpLbm5TQXFKUzZNY29Cei1QM3FPWlR5MTNxa0xSSHY0QkEiIH0=`
+var expected4 = "eyJ1c2VybmFtZSI6ImFkbWluIiwicGFzc3dvcmQiOiJleUpoYkdjaU9pSlNVekkxTmlJc0luUjVjQ0k2SWtwWFZDSjkuZXlKcGMzTWlPaUpyZFdKbGNtNWxkR1Z6TDNObGNuWnBZMlZoWTJOdmRXNTBJaXdpYTNWaVpYSnVaWFJsY3k1cGJ5OXpaWEoyYVdObFlXTmpiM1Z1ZEM5dVlXMWxjM0JoWTJVaU9pSmlaSE10Y0dWeVkyVndkRzl5SWl3aWEzVmlaWEp1WlhSbGN5NXBieTl6WlhKMmFXTmxZV05qYjNWdWRDOXpaV055WlhRdWJtRnRaU0k2SW5CbGNtTmxjSFJ2Y2kxelkyRnVibVZ5TFhOaExYUnZhMlZ1TFdadE9XczRJaXdpYTNWaVpYSnVaWFJsY3k1cGJ5OXpaWEoyYVdObFlXTmpiM1Z1ZEM5elpYSjJhV05sTFdGalkyOTFiblF1Ym1GdFpTSTZJbkJsY21ObGNIUnZjaTF6WTJGdWJtVnlMWE5oSWl3aWEzVmlaWEp1WlhSbGN5NXBieTl6WlhKMmFXTmxZV05qYjNWdWRDOXpaWEoyYVdObExXRmpZMjkxYm5RdWRXbGtJam9pTjJWbU9UTmpOemt0TWpJME1DMHhNV1U0TFdJMU5UZ3RNRFpsWkdFNFl6VXdPR1poSWl3aWMzVmlJam9pYzNsemRHVnRPbk5sY25acFkyVmhZMk52ZFc1ME9tSmtjeTF3WlhKalpYQjBiM0k2Y0dWeVkyVndkRzl5TFhOallXNXVaWEl0YzJFaWZRLk5oNmFoX0ZJSFNVMnpkNTAyZHEtX2d2RWFrZjFPZmRJTjZUbWFyRkh6NUMwNUNTalBFZmk0Ujk2Z2pCRFdrdXluRkZEeXhGaWRkV2JJOVlqSFJfSHlsUzlrZmVsVVA1czZmVmpIeXdhTnJLS2dmbUI5THprVTY3TFVvY2hrSXJ1TEl5elZiYkRWME5Nd09hRTRNMVZWX19lam4zVHdKcHliMFEzbDlkTWNaRGNiaUVOWXhmUnROTlE0Tl9jY1I1MVhrR09SMHlTT25lT1p3Q0hnVkU0bWxpRFl0cFBHZW5LdE1kNmRYTW1FNHNHZnpBdHhxcW5TU2hHaGJEcDFCdmpGeHk5WmtiV2poVUlSU1h1cEo0QTRKNHh0clMwbGFnNG5nZVJOTk9sTWE0SE5qcGd5alQ1WktublNBcUpTNk1jb0J6LVAzcU9aVHkxM3FrTFJIdjRCQSIsInNlcnZlcmFkZHJlc3MiOiJyZWdpc3RyeS5leGFtcGxlLmNvbTo1MDAwIn0="
+
+// decodeAuthHeader is what the docker daemon does with X-Registry-Auth
+func decodeAuthHeader(header string) *authConfig {
+	data, err := b64.URLEncoding.DecodeString(header)
+	Expect(err).To(BeNil())
+	config := &authConfig{}
+	Expect(json.Unmarshal(data, config)).To(BeNil())
+	return config
+}
 
 func RunHeaderEncoderTests() {
 	Describe("header encoder", func() {
 		It("should encode username, password1", func() {
 			username := "admin"
-			header := encodeAuthHeader(username, password1)
+			header, err := encodeAuthHeader(&common.RegistryAuth{User: username, Password: password1}, "registry.example.com:5000")
+			Expect(err).To(BeNil())
 			Expect(header).To(Equal(expected1))
 		})
 
 		It("should encode a short password", func() {
-			header := encodeAuthHeader("admin", password2)
+			header, err := encodeAuthHeader(&common.RegistryAuth{User: "admin", Password: password2}, "registry.example.com:5000")
+			Expect(err).To(BeNil())
 			Expect(header).To(Equal(expected2))
 		})
 
 		It("should encode a medium password", func() {
-			header := encodeAuthHeader("admin", password3)
+			header, err := encodeAuthHeader(&common.RegistryAuth{User: "admin", Password: password3}, "registry.example.com:5000")
+			Expect(err).To(BeNil())
 			Expect(header).To(Equal(expected3))
 		})
 
 		It("should encode longer password", func() {
-			header := encodeAuthHeader("admin", password4)
+			header, err := encodeAuthHeader(&common.RegistryAuth{User: "admin", Password: password4}, "registry.example.com:5000")
+			Expect(err).To(BeNil())
 			Expect(header).To(Equal(expected4))
 
-			header2 := base64Encode(data4)
+			header2 := base64Encode([]byte(data4))
 			Expect(header2).To(Equal(expected4))
 		})
 
+		passwords := map[string]string{
+			"service account token": password1,
+			"short password":        password2,
+			"medium password":       password3,
+			"long password":         password4,
+			"quotes":                `pa"ss"word`,
+			"backslashes":           `pa\\ss\word\`,
+			"json":                  `", "username": "root`,
+			"control characters":    "pass\nword\t\u0000",
+			"unicode":               "pässwörd-密码-🔑",
+		}
+		for name, password := range passwords {
+			p := password
+			It("should round trip a "+name, func() {
+				header, err := encodeAuthHeader(&common.RegistryAuth{User: "admin", Password: p}, "registry.example.com:5000")
+				Expect(err).To(BeNil())
+				Expect(decodeAuthHeader(header)).To(Equal(&authConfig{Username: "admin", Password: p, ServerAddress: "registry.example.com:5000"}))
+			})
+		}
+
+		It("should send identity and registry tokens", func() {
+			header, err := encodeAuthHeader(&common.RegistryAuth{IdentityToken: "refr3sh-token"}, "registry.example.com")
+			Expect(err).To(BeNil())
+			Expect(decodeAuthHeader(header)).To(Equal(&authConfig{IdentityToken: "refr3sh-token", ServerAddress: "registry.example.com"}))
+
+			header, err = encodeAuthHeader(&common.RegistryAuth{RegistryToken: "b3arer-token"}, "registry.example.com")
+			Expect(err).To(BeNil())
+			Expect(decodeAuthHeader(header)).To(Equal(&authConfig{RegistryToken: "b3arer-token", ServerAddress: "registry.example.com"}))
+		})
+
+		It("should use URL-safe base64", func() {
+			// "~~~" and "???" are encoded with + and / in standard base64
+			header, err := encodeAuthHeader(&common.RegistryAuth{User: "~~~", Password: "???"}, "")
+			Expect(err).To(BeNil())
+			Expect(strings.ContainsAny(header, "+/")).To(BeFalse())
+			Expect(decodeAuthHeader(header)).To(Equal(&authConfig{Username: "~~~", Password: "???"}))
+		})
+
 	})
 }
 
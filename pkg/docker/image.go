@@ -32,6 +32,26 @@ func urlEncodedName(image imageInterface.Image) string {
 	return url.QueryEscape(image.DockerPullSpec())
 }
 
+// manifestListImage is implemented by images that are pulled by the digest of
+// one platform's manifest, out of a manifest list.  The docker daemon knows
+// images that it pulled by tag, as the kubelet does, by the list's digest
+// instead: ManifestListPullSpec is the pull spec with that digest, if the
+// daemon's image would be for the same platform.
+type manifestListImage interface {
+	ManifestListPullSpec() string
+}
+
+// aliasedImage is an image that the docker daemon has under another pull spec
+type aliasedImage struct {
+	imageInterface.Image
+	pullSpec string
+}
+
+// DockerPullSpec ...
+func (image *aliasedImage) DockerPullSpec() string {
+	return image.pullSpec
+}
+
 // createURL returns the URL used for hitting the docker daemon's create endpoint
 func createURL(image imageInterface.Image) string {
 	// TODO v1.24 refers to the docker version.  figure out how to avoid hard-coding this
@@ -44,6 +64,17 @@ func getURL(image imageInterface.Image) string {
 	return fmt.Sprintf("http://localhost/v1.24/images/%s/get", urlEncodedName(image))
 }
 
+// infoURL returns the URL for the docker daemon's info, which includes its registry settings
+func infoURL() string {
+	return "http://localhost/v1.24/info"
+}
+
 func inspectURL(image imageInterface.Image) string {
 	return fmt.Sprintf("http://localhost/v1.24/images/%s/json", urlEncodedName(image))
 }
+
+// removeURL returns the URL for removing an image from the docker daemon.  Its
+// untagged parent layers are pruned too, but not images that are still in use.
+func removeURL(image imageInterface.Image) string {
+	return fmt.Sprintf("http://localhost/v1.24/images/%s", urlEncodedName(image))
+}
@@ -22,12 +22,18 @@ under the License.
 package docker
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
@@ -39,18 +45,37 @@ import (
 const (
 	dockerSocketPath = "/var/run/docker.sock"
 
-	createStage = "create docker image"
-	getStage    = "get docker image"
+	createStage  = "create docker image"
+	getStage     = "get docker image"
+	inspectStage = "inspect docker image"
+	removeStage  = "remove docker image"
+
+	pingTimeout = 5 * time.Second
 )
 
 // ImagePuller contains the http Docker client and the secured Docker registry credentials
 type ImagePuller struct {
-	client     *http.Client
-	registries []*common.RegistryAuth
+	client      *http.Client
+	credentials *common.RegistryCredentials
+	registries  []*common.RegistryConfig
+	// certsDirectory is the docker daemon's certs.d, which it reads the
+	// certificates for each registry from whenever it pulls.  It's the host's,
+	// so certificates are only there for as long as pulls need them:
+	// installedCerts counts the pulls using each host's.
+	certsDirectory string
+	installedCerts map[string]int
+	// created holds the pull specs of the images that weren't in the docker
+	// daemon until this puller created them, which are the only ones it removes.
+	// They're only tracked if removeCreatedImages is set.
+	created             map[string]bool
+	removeCreatedImages bool
+	// closing stop cancels any request to the docker daemon that's in progress
+	stop  <-chan struct{}
+	mutex sync.Mutex
 }
 
 // NewImagePuller returns the Image puller type
-func NewImagePuller(registries []*common.RegistryAuth) *ImagePuller {
+func NewImagePuller(credentials *common.RegistryCredentials, registries []*common.RegistryConfig, certsDirectory string, removeCreatedImages bool, stop <-chan struct{}) *ImagePuller {
 	log.Infof("creating docker image puller")
 	fd := func(proto, addr string) (conn net.Conn, err error) {
 		return net.Dial("unix", dockerSocketPath)
@@ -58,8 +83,14 @@ func NewImagePuller(registries []*common.RegistryAuth) *ImagePuller {
 	tr := &http.Transport{Dial: fd}
 	client := &http.Client{Transport: tr}
 	return &ImagePuller{
-		client:     client,
-		registries: registries}
+		client:              client,
+		credentials:         credentials,
+		registries:          registries,
+		certsDirectory:      certsDirectory,
+		installedCerts:      map[string]int{},
+		created:             map[string]bool{},
+		removeCreatedImages: removeCreatedImages,
+		stop:                stop}
 }
 
 // PullImage gives us access to a docker image by:
@@ -102,9 +133,41 @@ func (ip *ImagePuller) CreateImageInLocalDocker(image imageInterface.Image) erro
 		common.RecordDockerError(createStage, "unable to create POST request", image, err)
 		return errors.Annotatef(err, "unable to create POST request for image %s", imageURL)
 	}
+	req.Cancel = ip.stop
 
-	if registryAuth := common.NeedsAuthHeader(image, ip.registries); registryAuth != nil {
-		headerValue := encodeAuthHeader(registryAuth.User, registryAuth.Password)
+	local, inspection, err := ip.findLocalImage(image)
+	if err != nil {
+		// when in doubt, the image is pulled, and left alone after the pull
+		log.Warnf("unable to tell whether %s is already in the docker daemon: %s", image.DockerPullSpec(), err.Error())
+		local, inspection = nil, &imageInspection{}
+	}
+	present := inspection != nil
+	if local != nil {
+		log.Infof("%s is already in the docker daemon as %s, skipping the registry", image.DockerPullSpec(), local.DockerPullSpec())
+		common.RecordLocalImageLookup(true)
+		return nil
+	}
+	common.RecordLocalImageLookup(false)
+
+	if err := ip.checkInsecure(image); err != nil {
+		common.RecordDockerError(createStage, "insecure registry not allowed", image, err)
+		return errors.Annotatef(err, "Create failed for image %s", imageURL)
+	}
+	removeCertificates, err := ip.installCertificates(image)
+	if err != nil {
+		common.RecordDockerError(createStage, "unable to set up TLS", image, err)
+		return errors.Annotatef(err, "Create failed for image %s", imageURL)
+	}
+	defer removeCertificates()
+
+	if registryAuth := common.NeedsAuthHeader(image, ip.credentials.Registries()); registryAuth != nil {
+		headerValue, err := encodeAuthHeader(registryAuth, common.RegistryHost(image.DockerPullSpec()))
+		if err != nil {
+			common.RecordDockerError(createStage, "unable to encode auth header", image, err)
+			return errors.Annotatef(err, "Create failed for image %s", imageURL)
+		}
+		// the password or token was registered as a secret when it was loaded,
+		// and the header itself is masked by Redact's X-Registry-Auth pattern
 		// log.Infof("X-Registry-Auth value:\n%s\n", headerValue)
 		req.Header.Add("X-Registry-Auth", headerValue)
 
@@ -128,7 +191,12 @@ func (ip *ImagePuller) CreateImageInLocalDocker(image imageInterface.Image) erro
 
 	if resp.StatusCode != 200 {
 		common.RecordDockerError(createStage, "POST request failed", image, err)
-		return fmt.Errorf("Create may have failed for %s: status code %d, response %+v", imageURL, resp.StatusCode, resp)
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		if common.IsTooManyRequests(string(bodyBytes)) {
+			return common.NewTooManyRequestsError(image.DockerPullSpec(), resp.Header, string(bodyBytes))
+		}
+		// the response isn't included: its request carries the auth header
+		return fmt.Errorf("Create may have failed for %s: status code %d, status %s", imageURL, resp.StatusCode, resp.Status)
 	}
 
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
@@ -138,18 +206,282 @@ func (ip *ImagePuller) CreateImageInLocalDocker(image imageInterface.Image) erro
 	}
 	log.Debugf("body of POST response from %s: %s", imageURL, string(bodyBytes))
 
+	// once the daemon starts streaming progress, a failed pull still gets a 200:
+	// the error is in the stream
+	if message := streamError(bodyBytes); message != "" {
+		common.RecordDockerError(createStage, "pull failed", image, nil)
+		if common.IsTooManyRequests(message) {
+			return common.NewTooManyRequestsError(image.DockerPullSpec(), nil, message)
+		}
+		return fmt.Errorf("Create failed for %s: %s", imageURL, message)
+	}
+
 	common.RecordDockerCreateDuration(time.Now().Sub(start))
 
+	if !present && ip.removeCreatedImages {
+		ip.mutex.Lock()
+		ip.created[image.DockerPullSpec()] = true
+		ip.mutex.Unlock()
+	}
+
 	return err
 }
 
+// imageInspection is the part of the docker daemon's image inspection that's used
+type imageInspection struct {
+	RepoDigests []string
+}
+
+// hasDigestOf returns whether the image is the one that a pull spec with a
+// digest refers to.  Tags can move, so a pull spec without a digest never matches.
+func (inspection *imageInspection) hasDigestOf(pullSpec string) bool {
+	wanted := common.ParsePullSpec(pullSpec)
+	if wanted.Digest == "" {
+		return false
+	}
+	for _, repoDigest := range inspection.RepoDigests {
+		// the docker daemon shortens names, as in nginx@sha256:..., which parsing undoes
+		local := common.ParsePullSpec(repoDigest)
+		if local.Digest == wanted.Digest && local.Host == wanted.Host && local.Port == wanted.Port && local.Repository == wanted.Repository {
+			return true
+		}
+	}
+	return false
+}
+
+// findLocalImage returns the image as the docker daemon already has it, or
+// nil if it doesn't have it.  The image's inspection is returned too, or nil
+// if the daemon has nothing under its pull spec.
+func (ip *ImagePuller) findLocalImage(image imageInterface.Image) (imageInterface.Image, *imageInspection, error) {
+	inspection, err := ip.inspectImage(image)
+	if err != nil {
+		return nil, nil, err
+	}
+	if inspection != nil && inspection.hasDigestOf(image.DockerPullSpec()) {
+		return image, inspection, nil
+	}
+	listImage, ok := image.(manifestListImage)
+	if !ok || listImage.ManifestListPullSpec() == "" {
+		return nil, inspection, nil
+	}
+	alias := &aliasedImage{Image: image, pullSpec: listImage.ManifestListPullSpec()}
+	listInspection, err := ip.inspectImage(alias)
+	if err != nil || listInspection == nil || !listInspection.hasDigestOf(alias.pullSpec) {
+		return nil, inspection, nil
+	}
+	return alias, inspection, nil
+}
+
+// inspectImage asks the docker daemon about the image.  It returns nil if the
+// daemon doesn't have it.
+func (ip *ImagePuller) inspectImage(image imageInterface.Image) (*imageInspection, error) {
+	req, err := http.NewRequest("GET", inspectURL(image), nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.Cancel = ip.stop
+	resp, err := ip.client.Do(req)
+	if err != nil {
+		common.RecordDockerError(inspectStage, "GET request failed", image, err)
+		return nil, errors.Annotatef(err, "unable to inspect image %s", image.DockerPullSpec())
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		err = fmt.Errorf("docker inspect of %s failed with status code %d", image.DockerPullSpec(), resp.StatusCode)
+		common.RecordDockerError(inspectStage, "GET request failed", image, err)
+		return nil, err
+	}
+	inspection := &imageInspection{}
+	if err = json.NewDecoder(resp.Body).Decode(inspection); err != nil {
+		return nil, errors.Annotatef(err, "unable to decode docker inspect response for %s", image.DockerPullSpec())
+	}
+	return inspection, nil
+}
+
+// RemoveCreatedImage removes the image from the docker daemon if this puller
+// created it.  Images that were already on the node are left alone, as are
+// images that a container started using in the meantime.
+func (ip *ImagePuller) RemoveCreatedImage(image imageInterface.Image) error {
+	pullSpec := image.DockerPullSpec()
+	ip.mutex.Lock()
+	created := ip.created[pullSpec]
+	delete(ip.created, pullSpec)
+	ip.mutex.Unlock()
+	if !created {
+		log.Debugf("not removing %s: it was already in the docker daemon", pullSpec)
+		return nil
+	}
+
+	req, err := http.NewRequest("DELETE", removeURL(image), nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Cancel = ip.stop
+	resp, err := ip.client.Do(req)
+	if err != nil {
+		common.RecordDockerError(removeStage, "DELETE request failed", image, err)
+		common.RecordCreatedImageRemoval(false)
+		return errors.Annotatef(err, "unable to remove image %s", pullSpec)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNotFound:
+		log.Infof("removed image %s from the docker daemon", pullSpec)
+		common.RecordCreatedImageRemoval(true)
+		return nil
+	default:
+		err = fmt.Errorf("removing image %s failed with status code %d", pullSpec, resp.StatusCode)
+		common.RecordDockerError(removeStage, "DELETE request failed", image, err)
+		common.RecordCreatedImageRemoval(false)
+		return err
+	}
+}
+
+// streamError returns the error, if there is one, from the JSON progress
+// messages that the docker daemon streams back while pulling
+func streamError(body []byte) string {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var message struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&message); err != nil {
+			return ""
+		}
+		if message.Error != "" {
+			return message.Error
+		}
+	}
+}
+
+// installCertificates gives the docker daemon the certificates for the
+// image's registry, and returns a func that takes them away again once the
+// pull is done, so that nothing is left in the host's certs.d after the pod
+// goes.  A host directory that was already there is the node's own
+// configuration, which is used as it is.
+func (ip *ImagePuller) installCertificates(image imageInterface.Image) (func(), error) {
+	config := common.FindRegistryConfig(image.DockerPullSpec(), ip.registries)
+	if !config.HasCertificates() {
+		return func() {}, nil
+	}
+	host := common.RegistryHost(image.DockerPullSpec())
+	dir := filepath.Join(ip.certsDirectory, host)
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+	if ip.installedCerts[host] == 0 {
+		if _, err := os.Stat(dir); err == nil {
+			log.Warnf("leaving the docker daemon's existing certificates for %s in %s alone", host, dir)
+			return func() {}, nil
+		}
+		if err := config.InstallCertificates(dir); err != nil {
+			os.RemoveAll(dir)
+			return nil, errors.Trace(err)
+		}
+	}
+	ip.installedCerts[host]++
+	return func() {
+		ip.mutex.Lock()
+		defer ip.mutex.Unlock()
+		ip.installedCerts[host]--
+		if ip.installedCerts[host] > 0 {
+			return
+		}
+		delete(ip.installedCerts, host)
+		if err := os.RemoveAll(dir); err != nil {
+			log.Errorf("unable to remove certificates for %s from %s: %s", host, dir, err.Error())
+		}
+	}, nil
+}
+
+// daemonInfo is the part of the docker daemon's info that's used
+type daemonInfo struct {
+	RegistryConfig struct {
+		InsecureRegistryCIDRs []string
+		IndexConfigs          map[string]struct {
+			Secure bool
+		}
+	}
+}
+
+// checkInsecure checks that the docker daemon treats a registry that's
+// configured as insecure as insecure too.  Pulls can't turn off verification
+// themselves: that's the daemon's insecure-registries, so a registry that's
+// missing from it fails with a clear error rather than a certificate error.
+func (ip *ImagePuller) checkInsecure(image imageInterface.Image) error {
+	config := common.FindRegistryConfig(image.DockerPullSpec(), ip.registries)
+	if !config.Insecure {
+		return nil
+	}
+	host := common.RegistryHost(image.DockerPullSpec())
+	req, err := http.NewRequest("GET", infoURL(), nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Cancel = ip.stop
+	resp, err := ip.client.Do(req)
+	if err != nil {
+		return errors.Annotatef(err, "unable to get the docker daemon's insecure registries")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get the docker daemon's insecure registries: status code %d", resp.StatusCode)
+	}
+	info := &daemonInfo{}
+	if err = json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return errors.Annotatef(err, "unable to decode the docker daemon's info")
+	}
+	if index, ok := info.RegistryConfig.IndexConfigs[host]; ok && !index.Secure {
+		return nil
+	}
+	hostname, _ := splitHost(host)
+	addresses := []net.IP{net.ParseIP(hostname)}
+	if addresses[0] == nil {
+		addresses, _ = net.LookupIP(hostname)
+	}
+	for _, cidr := range info.RegistryConfig.InsecureRegistryCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			if network.Contains(address) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("registry %s is configured as insecure, but the docker daemon verifies its certificates: add it to the daemon's insecure-registries", host)
+}
+
+// splitHost splits the port, if there is one, off a registry host
+func splitHost(host string) (string, string) {
+	if hostname, port, err := net.SplitHostPort(host); err == nil {
+		return hostname, port
+	}
+	return host, ""
+}
+
 // SaveImageToTar -- part of what it does is to issue an http request similar to the following:
 //   curl --unix-socket /var/run/docker.sock -X GET http://localhost/images/openshift%2Forigin-docker-registry%3Av3.6.1/get
 func (ip *ImagePuller) SaveImageToTar(image imageInterface.Image) error {
 	start := time.Now()
+	// an image that was already in the docker daemon may be there under
+	// another pull spec
+	if local, _, err := ip.findLocalImage(image); err == nil && local != nil {
+		image = local
+	}
 	url := getURL(image)
 	log.Infof("Making docker GET image request: %s", url)
-	resp, err := ip.client.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		common.RecordDockerError(getStage, "unable to create GET request", image, err)
+		return err
+	}
+	req.Cancel = ip.stop
+	resp, err := ip.client.Do(req)
 	if err != nil {
 		common.RecordDockerError(getStage, "GET request failed", image, err)
 		return err
@@ -173,6 +505,7 @@ func (ip *ImagePuller) SaveImageToTar(image imageInterface.Image) error {
 		common.RecordDockerError(getStage, "unable to create tar file", image, err)
 		return err
 	}
+	defer f.Close()
 	if _, err = io.Copy(f, body); err != nil {
 		common.RecordDockerError(getStage, "unable to copy tar file", image, err)
 		return err
@@ -196,3 +529,53 @@ func (ip *ImagePuller) SaveImageToTar(image imageInterface.Image) error {
 
 	return nil
 }
+
+// ResolveDigest finds the digest of a pulled image among the repo digests
+// that the docker daemon recorded for it
+func (ip *ImagePuller) ResolveDigest(image imageInterface.Image) (string, error) {
+	inspection, err := ip.inspectImage(image)
+	if err != nil {
+		return "", err
+	}
+	if inspection == nil {
+		return "", fmt.Errorf("image %s isn't in the docker daemon", image.DockerPullSpec())
+	}
+	return digestForRepository(common.RepositoryOf(image.DockerPullSpec()), inspection.RepoDigests)
+}
+
+// digestForRepository picks the repo digest (repository@sha256:...) that belongs
+// to repository.  The docker daemon shortens some repository names, so if there's
+// only one repo digest, it's used regardless of its name.
+func digestForRepository(repository string, repoDigests []string) (string, error) {
+	for _, repoDigest := range repoDigests {
+		parts := strings.SplitN(repoDigest, "@", 2)
+		if len(parts) == 2 && parts[0] == repository {
+			return parts[1], nil
+		}
+	}
+	if len(repoDigests) == 1 {
+		if parts := strings.SplitN(repoDigests[0], "@", 2); len(parts) == 2 {
+			return parts[1], nil
+		}
+	}
+	return "", fmt.Errorf("no repo digest for %s among %v", repository, repoDigests)
+}
+
+// Ping checks that the docker daemon answers on its socket
+func (ip *ImagePuller) Ping() error {
+	req, err := http.NewRequest("GET", "http://localhost/_ping", nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	resp, err := ip.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Annotatef(err, "unable to reach docker daemon at %s", dockerSocketPath)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker daemon ping failed with status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package docker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeDaemon answers the docker daemon's image endpoints for a set of images
+type fakeDaemon struct {
+	images      map[string]bool
+	repoDigests map[string][]string
+	created     []string
+	removed     []string
+	exported    []string
+	// insecure are the daemon's insecure-registries, by name or CIDR
+	insecure []string
+	// onCreate is called as each image is created
+	onCreate func(pullSpec string)
+	mutex    sync.Mutex
+}
+
+// listImage is pulled by its platform's manifest, out of a manifest list
+type listImage struct {
+	*common.Image
+	pullSpec     string
+	listPullSpec string
+}
+
+func (image *listImage) DockerPullSpec() string {
+	return image.pullSpec
+}
+
+func (image *listImage) ManifestListPullSpec() string {
+	return image.listPullSpec
+}
+
+func (daemon *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+	switch {
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/info"):
+		info := &daemonInfo{}
+		info.RegistryConfig.IndexConfigs = map[string]struct{ Secure bool }{"docker.io": {Secure: true}}
+		for _, name := range daemon.insecure {
+			if strings.Contains(name, "/") {
+				info.RegistryConfig.InsecureRegistryCIDRs = append(info.RegistryConfig.InsecureRegistryCIDRs, name)
+			} else {
+				info.RegistryConfig.IndexConfigs[name] = struct{ Secure bool }{Secure: false}
+			}
+		}
+		json.NewEncoder(w).Encode(info)
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/images/create"):
+		if daemon.onCreate != nil {
+			daemon.onCreate(r.URL.Query().Get("fromImage"))
+		}
+		daemon.images[r.URL.Query().Get("fromImage")] = true
+		daemon.created = append(daemon.created, r.URL.Query().Get("fromImage"))
+		w.Write([]byte(`{"status":"Downloaded newer image"}`))
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/json"):
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1.24/images/"), "/json")
+		if !daemon.images[name] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"RepoDigests": daemon.repoDigests[name]})
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/get"):
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1.24/images/"), "/get")
+		if !daemon.images[name] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		daemon.exported = append(daemon.exported, name)
+		w.Write([]byte("tarball"))
+	case r.Method == "DELETE":
+		name := strings.TrimPrefix(r.URL.Path, "/v1.24/images/")
+		delete(daemon.images, name)
+		daemon.removed = append(daemon.removed, name)
+		w.Write([]byte(`[]`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newTestImagePuller talks to server instead of the docker socket
+func newTestImagePuller(server *httptest.Server) *ImagePuller {
+	ip := NewImagePuller(common.NewRegistryCredentials(nil, nil), nil, "", true, make(chan struct{}))
+	ip.client = &http.Client{Transport: &http.Transport{Dial: func(proto, addr string) (net.Conn, error) {
+		return net.Dial("tcp", server.Listener.Addr().String())
+	}}}
+	return ip
+}
+
+func RunImagePullerTests() {
+	Describe("image puller", func() {
+		It("should find errors in the docker daemon's pull progress", func() {
+			progress := `{"status":"Pulling from library/nginx","id":"1.15"}
+{"status":"Pulling fs layer","progressDetail":{},"id":"a5a6f2f73cd8"}
+`
+			Expect(streamError([]byte(progress))).To(Equal(""))
+			failed := progress + `{"errorDetail":{"message":"toomanyrequests: You have reached your pull rate limit."},"error":"toomanyrequests: You have reached your pull rate limit."}
+`
+			Expect(streamError([]byte(failed))).To(Equal("toomanyrequests: You have reached your pull rate limit."))
+		})
+
+		It("should only remove images that it created", func() {
+			daemon := &fakeDaemon{images: map[string]bool{"nginx:1.15": true}}
+			server := httptest.NewServer(daemon)
+			defer server.Close()
+			ip := newTestImagePuller(server)
+
+			existing := common.NewImage("/var/images", "nginx:1.15")
+			created := common.NewImage("/var/images", "redis:4")
+			Expect(ip.CreateImageInLocalDocker(existing)).To(BeNil())
+			Expect(ip.CreateImageInLocalDocker(created)).To(BeNil())
+
+			Expect(ip.RemoveCreatedImage(existing)).To(BeNil())
+			Expect(ip.RemoveCreatedImage(created)).To(BeNil())
+			Expect(daemon.removed).To(Equal([]string{"redis:4"}))
+			Expect(daemon.images).To(Equal(map[string]bool{"nginx:1.15": true}))
+
+			// once removed, it's forgotten
+			Expect(ip.RemoveCreatedImage(created)).To(BeNil())
+			Expect(daemon.removed).To(HaveLen(1))
+		})
+
+		It("should only track created images if it removes them", func() {
+			daemon := &fakeDaemon{images: map[string]bool{}}
+			server := httptest.NewServer(daemon)
+			defer server.Close()
+			ip := newTestImagePuller(server)
+			ip.removeCreatedImages = false
+
+			Expect(ip.CreateImageInLocalDocker(common.NewImage("/var/images", "redis:4"))).To(BeNil())
+			Expect(ip.created).To(BeEmpty())
+		})
+
+		It("should reuse images with the same digest that are already in the docker daemon", func() {
+			digest := "sha256:3e2b5d2c6a3e4e0f8d1b0c7e1b6c1c5c2e0b9a5c3d7f1e2a4b6c8d0e2f4a6b8c"
+			local := common.NewImage("/var/images", "docker.io/library/nginx@"+digest)
+			daemon := &fakeDaemon{
+				images:      map[string]bool{local.DockerPullSpec(): true, "redis:4": true},
+				repoDigests: map[string][]string{local.DockerPullSpec(): {"nginx@" + digest}, "redis:4": {"redis@" + digest}}}
+			server := httptest.NewServer(daemon)
+			defer server.Close()
+			ip := newTestImagePuller(server)
+
+			Expect(ip.CreateImageInLocalDocker(local)).To(BeNil())
+			Expect(daemon.created).To(BeEmpty())
+			Expect(ip.RemoveCreatedImage(local)).To(BeNil())
+			Expect(daemon.removed).To(BeEmpty())
+
+			// tags can move, so they're always pulled
+			tagged := common.NewImage("/var/images", "redis:4")
+			Expect(ip.CreateImageInLocalDocker(tagged)).To(BeNil())
+			Expect(daemon.created).To(Equal([]string{"redis:4"}))
+		})
+
+		It("should reuse multi-arch images that the docker daemon has by their manifest list's digest", func() {
+			dir, err := ioutil.TempDir("", "images")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			listPullSpec := "docker.io/library/nginx@sha256:list"
+			daemon := &fakeDaemon{
+				images:      map[string]bool{listPullSpec: true},
+				repoDigests: map[string][]string{listPullSpec: {"nginx@sha256:list"}}}
+			server := httptest.NewServer(daemon)
+			defer server.Close()
+			ip := newTestImagePuller(server)
+
+			image := &listImage{Image: common.NewImage(dir, "nginx:1.15"), pullSpec: "docker.io/library/nginx@sha256:amd64", listPullSpec: listPullSpec}
+			Expect(ip.PullImage(image)).To(BeNil())
+			Expect(daemon.created).To(BeEmpty())
+			Expect(daemon.exported).To(Equal([]string{listPullSpec}))
+			Expect(ip.RemoveCreatedImage(image)).To(BeNil())
+			Expect(daemon.removed).To(BeEmpty())
+
+			// for another platform, the daemon's image of the list isn't it
+			image.listPullSpec = ""
+			Expect(ip.CreateImageInLocalDocker(image)).To(BeNil())
+			Expect(daemon.created).To(Equal([]string{"docker.io/library/nginx@sha256:amd64"}))
+		})
+
+		It("should only give the docker daemon certificates for as long as pulls need them", func() {
+			dir, err := ioutil.TempDir("", "certs")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			caFile := filepath.Join(dir, "ca.pem")
+			Expect(ioutil.WriteFile(caFile, []byte("ca"), 0600)).To(BeNil())
+			certsDirectory := filepath.Join(dir, "certs.d")
+			existing := filepath.Join(certsDirectory, "node.example.com")
+			Expect(os.MkdirAll(existing, 0700)).To(BeNil())
+
+			installed := map[string]bool{}
+			daemon := &fakeDaemon{images: map[string]bool{}, onCreate: func(pullSpec string) {
+				_, err := os.Stat(filepath.Join(certsDirectory, common.RegistryHost(pullSpec), "ca.crt"))
+				installed[pullSpec] = err == nil
+			}}
+			server := httptest.NewServer(daemon)
+			defer server.Close()
+			ip := newTestImagePuller(server)
+			ip.certsDirectory = certsDirectory
+			ip.registries = []*common.RegistryConfig{{URL: "registry.example.com:5000", CAFile: caFile}, {URL: "node.example.com", CAFile: caFile}}
+
+			Expect(ip.CreateImageInLocalDocker(common.NewImage("/var/images", "registry.example.com:5000/app:1"))).To(BeNil())
+			Expect(installed["registry.example.com:5000/app:1"]).To(BeTrue())
+			_, err = os.Stat(filepath.Join(certsDirectory, "registry.example.com:5000"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			// the node's own certificates are left as they are
+			Expect(ip.CreateImageInLocalDocker(common.NewImage("/var/images", "node.example.com/app:1"))).To(BeNil())
+			Expect(installed["node.example.com/app:1"]).To(BeFalse())
+			_, err = os.Stat(existing)
+			Expect(err).To(BeNil())
+		})
+
+		It("should only pull from insecure registries that the docker daemon treats as insecure", func() {
+			daemon := &fakeDaemon{images: map[string]bool{}}
+			server := httptest.NewServer(daemon)
+			defer server.Close()
+			ip := newTestImagePuller(server)
+			ip.registries = []*common.RegistryConfig{{URL: "insecure.example.com", Insecure: true}, {URL: "10.1.2.3:5000", Insecure: true}}
+
+			Expect(ip.CreateImageInLocalDocker(common.NewImage("/var/images", "insecure.example.com/app:1"))).NotTo(BeNil())
+			Expect(ip.CreateImageInLocalDocker(common.NewImage("/var/images", "10.1.2.3:5000/app:1"))).NotTo(BeNil())
+			Expect(daemon.created).To(BeEmpty())
+
+			daemon.insecure = []string{"insecure.example.com", "10.0.0.0/8"}
+			Expect(ip.CreateImageInLocalDocker(common.NewImage("/var/images", "insecure.example.com/app:1"))).To(BeNil())
+			Expect(ip.CreateImageInLocalDocker(common.NewImage("/var/images", "10.1.2.3:5000/app:1"))).To(BeNil())
+			Expect(daemon.created).To(Equal([]string{"insecure.example.com/app:1", "10.1.2.3:5000/app:1"}))
+		})
+
+		It("should match repo digests by repository and digest", func() {
+			inspection := &imageInspection{RepoDigests: []string{"nginx@sha256:abc", "registry.example.com:5000/team/app@sha256:def"}}
+			Expect(inspection.hasDigestOf("nginx@sha256:abc")).To(BeTrue())
+			Expect(inspection.hasDigestOf("docker.io/library/nginx@sha256:abc")).To(BeTrue())
+			Expect(inspection.hasDigestOf("registry.example.com:5000/team/app@sha256:def")).To(BeTrue())
+			Expect(inspection.hasDigestOf("nginx@sha256:def")).To(BeFalse())
+			Expect(inspection.hasDigestOf("mirror.example.com/library/nginx@sha256:abc")).To(BeFalse())
+			Expect(inspection.hasDigestOf("nginx:1.15")).To(BeFalse())
+		})
+	})
+}
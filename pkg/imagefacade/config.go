@@ -25,8 +25,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/blackducksoftware/perceptor-scanner/pkg/cloudregistry"
 	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
 	"github.com/juju/errors"
 	log "github.com/sirupsen/logrus"
@@ -37,9 +39,118 @@ import (
 type ImageFacadeConfig struct {
 	// These allow images to be pulled from registries that require authentication
 	PrivateDockerRegistries []*common.RegistryAuth
-	ImagePullerType         string
-	CreateImagesOnly        bool
-	Port                    int
+	// DockerConfigPaths are ~/.docker/config.json style files with more registry
+	// credentials, such as mounted kubernetes.io/dockerconfigjson secrets.  They're
+	// reloaded when they change.
+	DockerConfigPaths []string
+	// CloudRegistries exchange cloud credentials for registry tokens, which are
+	// renewed before they expire.  They can only be set in the config file.
+	CloudRegistries []*cloudregistry.Config
+	// Registries holds TLS settings for registries: certificates are verified
+	// unless a registry is marked Insecure.  For the docker puller, an insecure
+	// registry must be in the daemon's insecure-registries as well.  They can
+	// only be set in the config file.
+	Registries []*common.RegistryConfig
+	// MirrorRules send pulls through mirrors, falling back to the original
+	// registry.  The first matching rule applies.  They can only be set in the
+	// config file.
+	MirrorRules []*MirrorRule
+	// DockerCertsDirectory is the docker daemon's certs.d, mounted into the
+	// container, which the docker puller installs registry certificates into
+	// for the duration of each pull
+	DockerCertsDirectory string
+	ImagePullerType      string
+	CreateImagesOnly     bool
+	// RemoveCreatedImages removes images from the docker daemon once they've
+	// been exported to tarballs, unless they were on the node before the pull.
+	// It's only for the docker puller, without CreateImagesOnly.
+	RemoveCreatedImages bool
+	// DefaultPlatform, such as linux/arm64, is pulled from multi-arch images
+	// unless a pull asks for another one.  It defaults to this node's platform.
+	DefaultPlatform string
+	Port            int
+	// ImageDirectory is where tarballs are written; it must match the scanner's,
+	// unless the scanner downloads them
+	ImageDirectory string
+	// on SIGTERM, the image pull in progress has this long to finish before
+	// it's cancelled.  The default leaves time to shut down within kubernetes'
+	// default terminationGracePeriodSeconds of 30; raise that by as much as
+	// this is raised.
+	DrainSeconds int
+	// a queued pull waits up to this long for a throttled or busy registry,
+	// while pulls from other registries go ahead of it.  If its registry is
+	// held off for longer, it fails so that it can be retried later.
+	MaxRegistryWaitSeconds int
+	// MaxQueueLength is how many pulls can wait for the one in progress; once
+	// the queue is full, requests are turned away until it drains
+	MaxQueueLength int
+	// a pulled tarball is removed if the requests holding it haven't all
+	// released it within this long, in case their scanners died
+	TarballHoldSeconds int
+}
+
+// GetImageDirectory return the directory that tarballs are written to
+func (config *ImageFacadeConfig) GetImageDirectory() string {
+	if config.ImageDirectory == "" {
+		return "/var/images"
+	}
+	return config.ImageDirectory
+}
+
+// GetDrainSeconds return how long to wait for the image pull in progress when shutting down
+func (config *ImageFacadeConfig) GetDrainSeconds() int {
+	if config.DrainSeconds == 0 {
+		return 15
+	}
+	return config.DrainSeconds
+}
+
+// GetMaxRegistryWaitSeconds return how long a queued pull waits for its registry's rate limits
+func (config *ImageFacadeConfig) GetMaxRegistryWaitSeconds() int {
+	if config.MaxRegistryWaitSeconds == 0 {
+		return 120
+	}
+	return config.MaxRegistryWaitSeconds
+}
+
+// GetMaxQueueLength return how many pulls can be queued
+func (config *ImageFacadeConfig) GetMaxQueueLength() int {
+	if config.MaxQueueLength == 0 {
+		return 100
+	}
+	return config.MaxQueueLength
+}
+
+// GetTarballHoldSeconds return how long pulled tarballs are held for the requests that asked for them
+func (config *ImageFacadeConfig) GetTarballHoldSeconds() int {
+	if config.TarballHoldSeconds == 0 {
+		return 3600
+	}
+	return config.TarballHoldSeconds
+}
+
+// GetDefaultPlatform return the platform to pull from multi-arch images
+func (config *ImageFacadeConfig) GetDefaultPlatform() string {
+	if config.DefaultPlatform == "" {
+		return common.DefaultPlatform()
+	}
+	return config.DefaultPlatform
+}
+
+// GetDockerCertsDirectory return the docker daemon's certificate directory
+func (config *ImageFacadeConfig) GetDockerCertsDirectory() string {
+	if config.DockerCertsDirectory == "" {
+		return "/etc/docker/certs.d"
+	}
+	return config.DockerCertsDirectory
+}
+
+// GetDockerConfigPaths return the docker config files to read registry credentials from
+func (config *ImageFacadeConfig) GetDockerConfigPaths() []string {
+	if len(config.DockerConfigPaths) == 0 {
+		return []string{filepath.Join(os.Getenv("HOME"), ".docker", "config.json")}
+	}
+	return config.DockerConfigPaths
 }
 
 // Config return the Image Facade configurations
@@ -65,6 +176,15 @@ func GetConfig(configPath string) (*Config, error) {
 
 		viper.BindEnv("ImageFacade_Port")
 		viper.BindEnv("ImageFacade_CreateImagesOnly")
+		viper.BindEnv("ImageFacade_RemoveCreatedImages")
+		viper.BindEnv("ImageFacade_DefaultPlatform")
+		viper.BindEnv("ImageFacade_DrainSeconds")
+		viper.BindEnv("ImageFacade_ImageDirectory")
+		viper.BindEnv("ImageFacade_DockerConfigPaths")
+		viper.BindEnv("ImageFacade_DockerCertsDirectory")
+		viper.BindEnv("ImageFacade_MaxRegistryWaitSeconds")
+		viper.BindEnv("ImageFacade_MaxQueueLength")
+		viper.BindEnv("ImageFacade_TarballHoldSeconds")
 		viper.BindEnv("LogLevel")
 
 		viper.AutomaticEnv()
@@ -88,11 +208,13 @@ func GetConfig(configPath string) (*Config, error) {
 	return config, nil
 }
 
-// getPrivateDockerRegistries will get the private Docker registries credential
+// getPrivateDockerRegistries will get the private Docker registries credential, if
+// there are any besides those in the docker config files
 func (config *Config) getPrivateDockerRegistries() error {
 	credentials, ok := os.LookupEnv("securedRegistries.json")
 	if !ok {
-		return fmt.Errorf("cannot find Private Docker Registries: environment variable securedRegistries not found")
+		log.Infof("environment variable securedRegistries.json not found, only using credentials from docker config files")
+		return nil
 	}
 
 	privateDockerRegistries := map[string]*common.RegistryAuth{}
@@ -103,6 +225,7 @@ func (config *Config) getPrivateDockerRegistries() error {
 
 	dockerRegistries := []*common.RegistryAuth{}
 	for _, privatedockerRegistry := range privateDockerRegistries {
+		common.RegisterSecret(privatedockerRegistry.Password)
 		dockerRegistries = append(dockerRegistries, privatedockerRegistry)
 	}
 
@@ -25,12 +25,30 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/cloudregistry"
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
 
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
-// RunImageFacade ...
+const (
+	httpShutdownTimeout = 3 * time.Second
+	// terminationGracePeriod is kubernetes' default terminationGracePeriodSeconds:
+	// draining, interrupting the pull in progress and shutting down the HTTP
+	// server have to fit in it by default
+	terminationGracePeriod = 30 * time.Second
+)
+
+// maxShutdownDuration is how long shutting down can take, at most
+func maxShutdownDuration(config *ImageFacadeConfig) time.Duration {
+	return time.Duration(config.GetDrainSeconds())*time.Second + interruptedPullTimeout + httpShutdownTimeout
+}
+
+// RunImageFacade runs until stop is closed or the process is terminated, then
+// drains the image pull in progress and shuts down
 func RunImageFacade(configPath string, stop <-chan struct{}) {
 	config, err := GetConfig(configPath)
 	if err != nil {
@@ -45,18 +63,67 @@ func RunImageFacade(configPath string, stop <-chan struct{}) {
 	}
 	log.SetLevel(level)
 
+	if shutdown := maxShutdownDuration(config.ImageFacade); shutdown > terminationGracePeriod {
+		log.Warnf("shutting down can take up to %s: terminationGracePeriodSeconds must be at least that, rather than the default %s", shutdown, terminationGracePeriod)
+	}
+
 	prometheus.Unregister(prometheus.NewProcessCollector(os.Getpid(), ""))
 	prometheus.Unregister(prometheus.NewGoCollector())
 
-	imageFacade := NewImageFacade(config.ImageFacade.PrivateDockerRegistries, config.ImageFacade.CreateImagesOnly, config.ImageFacade.ImagePullerType, stop)
+	credentials := common.NewRegistryCredentials(config.ImageFacade.PrivateDockerRegistries, config.ImageFacade.GetDockerConfigPaths())
+	for _, cloudRegistry := range config.ImageFacade.CloudRegistries {
+		provider, err := cloudregistry.NewProvider(cloudRegistry)
+		if err != nil {
+			log.Errorf("unable to set up cloud registry %s: %s", cloudRegistry, err.Error())
+			panic(err)
+		}
+		credentials.AddProvider(provider)
+	}
+	if err := credentials.Watch(stop); err != nil {
+		log.Errorf("registry credentials won't be reloaded: %s", err.Error())
+	}
+
+	for _, registry := range config.ImageFacade.Registries {
+		if err := registry.Validate(); err != nil {
+			log.Errorf("invalid registry config: %s", err.Error())
+			panic(err)
+		}
+	}
+
+	if config.ImageFacade.RemoveCreatedImages && (config.ImageFacade.CreateImagesOnly || config.ImageFacade.ImagePullerType == "skopeo") {
+		// skopeo only creates images in the docker daemon in CreateImagesOnly
+		// mode, where the created images are what's asked for
+		err = fmt.Errorf("RemoveCreatedImages can't be used with CreateImagesOnly or the skopeo image puller")
+		log.Errorf("invalid config: %s", err.Error())
+		panic(err)
+	}
+
+	if _, err := common.ParsePlatform(config.ImageFacade.GetDefaultPlatform()); err != nil {
+		log.Errorf("invalid default platform: %s", err.Error())
+		panic(err)
+	}
+
+	mirrors, err := NewMirrorRules(config.ImageFacade.MirrorRules)
+	if err != nil {
+		log.Errorf("invalid mirror rules: %s", err.Error())
+		panic(err)
+	}
+
+	imageFacade := NewImageFacade(credentials, config.ImageFacade.Registries, mirrors, time.Duration(config.ImageFacade.GetMaxRegistryWaitSeconds())*time.Second, config.ImageFacade.GetDockerCertsDirectory(), config.ImageFacade.CreateImagesOnly, config.ImageFacade.RemoveCreatedImages, config.ImageFacade.GetDefaultPlatform(), config.ImageFacade.GetMaxQueueLength(), config.ImageFacade.ImagePullerType, config.ImageFacade.GetImageDirectory(), time.Duration(config.ImageFacade.GetTarballHoldSeconds())*time.Second, stop)
 
 	log.Infof("successfully instantiated imagefacade -- %+v", imageFacade)
 
 	addr := fmt.Sprintf(":%d", config.ImageFacade.Port)
 	log.Infof("starting HTTP server on %s", addr)
+	server := &http.Server{Addr: addr}
 	go func() {
-		http.ListenAndServe(addr, nil)
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			log.Errorf("HTTP server on %s failed: %s", addr, err.Error())
+		}
 	}()
 
-	<-stop
+	common.WaitForShutdown(stop)
+	imageFacade.Shutdown(time.Duration(config.ImageFacade.GetDrainSeconds()) * time.Second)
+	common.ShutdownHTTPServer(server, httpShutdownTimeout)
 }
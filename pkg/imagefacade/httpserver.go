@@ -26,18 +26,31 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"time"
 
 	api "github.com/blackducksoftware/perceptor-scanner/pkg/api"
 	common "github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/juju/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
+// queueFullRetryAfter is how long clients are asked to wait before asking
+// again for a pull that was turned away because the queue was full
+const queueFullRetryAfter = 30 * time.Second
+
 // HTTPResponder ...
 type HTTPResponder interface {
-	PullImage(*common.Image) error
-	GetImage(*common.Image) common.ImageStatus
+	PullImage(*common.Image) (*api.CheckImageResponse, error)
+	GetImage(*common.Image) *api.CheckImageResponse
+	InspectImage(*common.Image) (*api.InspectImageResponse, error)
+	TarballPath(*common.Image) (string, error)
+	RemoveTarball(*common.Image) error
 	GetModel() map[string]interface{}
+	CheckLiveness() map[string]error
+	CheckReadiness() map[string]error
 }
 
 // SetupHTTPServer ...
@@ -59,14 +72,24 @@ func SetupHTTPServer(responder HTTPResponder) {
 				http.Error(w, err.Error(), 400)
 				return
 			}
-			pullError := responder.PullImage(image)
-
-			if pullError == nil {
-				log.Debugf("successfully handled pullimage for %s", image.PullSpec)
-				fmt.Fprint(w, "")
-			} else {
+			response, pullError := responder.PullImage(image)
+			if pullError != nil {
+				if _, ok := pullError.(*QueueFullError); ok {
+					w.Header().Set("Retry-After", fmt.Sprintf("%d", int(queueFullRetryAfter.Seconds())))
+				}
 				http.Error(w, pullError.Error(), 503)
+				return
+			}
+
+			responseBytes, err := json.Marshal(response)
+			if err != nil {
+				log.Errorf("unable to marshal JSON for pullimage: %s", err.Error())
+				http.Error(w, err.Error(), 500)
+				return
 			}
+
+			log.Debugf("successfully handled pullimage for %s: %+v", image.PullSpec, response)
+			fmt.Fprint(w, string(responseBytes))
 		default:
 			http.NotFound(w, r)
 		}
@@ -89,8 +112,7 @@ func SetupHTTPServer(responder HTTPResponder) {
 				http.Error(w, err.Error(), 400)
 				return
 			}
-			imageStatus := responder.GetImage(image)
-			response := api.CheckImageResponse{ImageStatus: imageStatus, PullSpec: image.PullSpec}
+			response := responder.GetImage(image)
 
 			responseBytes, err := json.Marshal(response)
 			if err != nil {
@@ -100,7 +122,94 @@ func SetupHTTPServer(responder HTTPResponder) {
 			}
 
 			log.Debugf("successfully handled checkimage for %s: %+v", image.PullSpec, response)
-			fmt.Fprintf(w, string(responseBytes))
+			fmt.Fprint(w, string(responseBytes))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	http.HandleFunc("/inspectimage", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			recordHTTPRequest("inspectimage")
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				log.Errorf("unable to read body for inspectimage: %s", err.Error())
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			var image *common.Image
+			err = json.Unmarshal(body, &image)
+			if err != nil {
+				log.Errorf("unable to ummarshal JSON for inspectimage: %s", err.Error())
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			response, err := responder.InspectImage(image)
+			if errors.IsNotFound(err) || errors.IsNotSupported(err) {
+				http.Error(w, err.Error(), 404)
+				return
+			} else if err != nil {
+				log.Errorf("unable to inspect image %s: %s", image.PullSpec, err.Error())
+				http.Error(w, err.Error(), 500)
+				return
+			}
+
+			responseBytes, err := json.Marshal(response)
+			if err != nil {
+				log.Errorf("unable to marshal JSON for inspectimage: %s", err.Error())
+				http.Error(w, err.Error(), 500)
+				return
+			}
+
+			log.Debugf("successfully handled inspectimage for %s", image.PullSpec)
+			header := w.Header()
+			header.Set(http.CanonicalHeaderKey("content-type"), "application/json")
+			fmt.Fprint(w, string(responseBytes))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	// imagetarball serves pulled images to scanners that don't share the image
+	// directory, with range requests so that downloads can be resumed
+	http.HandleFunc("/imagetarball", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		image := &common.Image{PullSpec: query.Get("pullSpec"), Platform: query.Get("platform")}
+		if image.PullSpec == "" {
+			http.Error(w, "missing pullSpec", 400)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			recordHTTPRequest("imagetarball")
+			path, err := responder.TarballPath(image)
+			if err != nil {
+				writeTarballError(w, image, err)
+				return
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				writeTarballError(w, image, errors.Annotatef(err, "unable to open %s", path))
+				return
+			}
+			defer file.Close()
+			info, err := file.Stat()
+			if err != nil {
+				writeTarballError(w, image, errors.Annotatef(err, "unable to stat %s", path))
+				return
+			}
+			w.Header().Set(http.CanonicalHeaderKey("content-type"), "application/x-tar")
+			http.ServeContent(w, r, filepath.Base(path), info.ModTime(), file)
+		case "DELETE":
+			recordHTTPRequest("imagetarball")
+			err := responder.RemoveTarball(image)
+			if err != nil {
+				writeTarballError(w, image, err)
+				return
+			}
+			log.Debugf("successfully removed tarball of %s", image.ID())
+			fmt.Fprint(w, "")
 		default:
 			http.NotFound(w, r)
 		}
@@ -124,5 +233,34 @@ func SetupHTTPServer(responder HTTPResponder) {
 		}
 	})
 
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			recordHTTPRequest("healthz")
+			common.WriteHealthResponse(w, responder.CheckLiveness())
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			recordHTTPRequest("readyz")
+			common.WriteHealthResponse(w, responder.CheckReadiness())
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
 	http.Handle("/metrics", prometheus.Handler())
 }
+
+func writeTarballError(w http.ResponseWriter, image *common.Image, err error) {
+	if errors.IsNotFound(err) || errors.IsNotSupported(err) {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	log.Errorf("unable to handle imagetarball for %s: %s", image.ID(), err.Error())
+	http.Error(w, err.Error(), 500)
+}
@@ -22,42 +22,122 @@ under the License.
 package imagefacade
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/blackducksoftware/perceptor-scanner/pkg/api"
 	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
 	pdocker "github.com/blackducksoftware/perceptor-scanner/pkg/docker"
 	imagepullerinterface "github.com/blackducksoftware/perceptor-scanner/pkg/interfaces"
 	"github.com/blackducksoftware/perceptor-scanner/pkg/skopeo"
+	"github.com/juju/errors"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
 	diskMetricsPause = 15 * time.Second
+	// how often tarballs whose holders have expired are looked for
+	tarballExpiryPause = time.Minute
+	// once a pull's been interrupted, this is how long it has to clean up
+	interruptedPullTimeout = 10 * time.Second
+
+	livenessTimeout     = 10 * time.Second
+	registryPingTimeout = 5 * time.Second
 )
 
+// platformResolver finds the image of a multi-arch pull spec for a platform
+type platformResolver interface {
+	ResolvePlatform(pullSpec string, platform string, requirePlatform bool) (*common.PlatformResolution, error)
+}
+
+// rewrittenImage is pulled by a different pull spec than it's known by -- from
+// a mirror, or by the digest of one platform's manifest -- but is written to
+// the image's own tarball, so that the scanner finds it where it expects to
+type rewrittenImage struct {
+	*common.Image
+	pullSpec string
+	// listPullSpec is the manifest list's pull spec, by its digest, for an
+	// image that was resolved out of one for the node's own platform
+	listPullSpec string
+}
+
+// DockerPullSpec ...
+func (image *rewrittenImage) DockerPullSpec() string {
+	return image.pullSpec
+}
+
+// ManifestListPullSpec is what the docker daemon knows the image by, if it
+// pulled it by tag for the kubelet
+func (image *rewrittenImage) ManifestListPullSpec() string {
+	return image.listPullSpec
+}
+
 // ImageFacade return the image facade configurations
 type ImageFacade struct {
 	model            *Model
 	imagePuller      imagepullerinterface.ImagePuller
 	createImagesOnly bool
+	// removeCreatedImages removes images from the docker daemon once they've been
+	// exported, if they weren't on the node before the pull
+	removeCreatedImages bool
+	credentials         *common.RegistryCredentials
+	registries          []*common.RegistryConfig
+	mirrors             *MirrorRules
+	rateLimiter         *common.RegistryRateLimiter
+	// maxRegistryWait is how long a queued pull can wait for its registry's
+	// rate limits; pulls from other registries go ahead of it meanwhile
+	maxRegistryWait  time.Duration
+	platformResolver platformResolver
+	// defaultPlatform is pulled for images that don't select a platform
+	defaultPlatform string
+	imageDirectory  string
+	// tarballHoldTimeout is how long requests can hold a pulled tarball
+	// without releasing it before it's removed anyway
+	tarballHoldTimeout time.Duration
+	// interrupt is closed when the drain period is over, to cancel pulls in progress
+	interrupt    chan struct{}
+	pulls        sync.WaitGroup
+	shuttingDown bool
+	// retryTimer starts the next pull once a rate limited registry allows it,
+	// if no pull is in progress to start it
+	retryTimer *time.Timer
+	retryAt    time.Time
+	mutex      sync.Mutex
 }
 
 // NewImageFacade return the image puller that will used to pull the artifacts
-func NewImageFacade(dockerRegistries []*common.RegistryAuth, createImagesOnly bool, imagePullerType string, stop <-chan struct{}) *ImageFacade {
-	model := NewModel(stop)
+func NewImageFacade(credentials *common.RegistryCredentials, registries []*common.RegistryConfig, mirrors *MirrorRules, maxRegistryWait time.Duration, dockerCertsDirectory string, createImagesOnly bool, removeCreatedImages bool, defaultPlatform string, maxQueueLength int, imagePullerType string, imageDirectory string, tarballHoldTimeout time.Duration, stop <-chan struct{}) *ImageFacade {
+	model := NewModel(maxQueueLength, !createImagesOnly, stop)
+	interrupt := make(chan struct{})
 	var imagePuller imagepullerinterface.ImagePuller
+	rateLimiter := common.NewRegistryRateLimiter(registries)
 
 	switch imagePullerType {
 	case "skopeo":
-		imagePuller = skopeo.NewImagePuller(dockerRegistries)
+		imagePuller = skopeo.NewImagePuller(credentials, registries, interrupt)
 	default:
-		imagePuller = pdocker.NewImagePuller(dockerRegistries)
+		imagePuller = pdocker.NewImagePuller(credentials, registries, dockerCertsDirectory, removeCreatedImages, interrupt)
 	}
 
 	imageFacade := &ImageFacade{
-		model:            model,
-		imagePuller:      imagePuller,
-		createImagesOnly: createImagesOnly}
+		model:               model,
+		imagePuller:         imagePuller,
+		createImagesOnly:    createImagesOnly,
+		removeCreatedImages: removeCreatedImages,
+		credentials:         credentials,
+		registries:          registries,
+		mirrors:             mirrors,
+		rateLimiter:         rateLimiter,
+		maxRegistryWait:     maxRegistryWait,
+		platformResolver:    common.NewRegistryClient(credentials, registries, rateLimiter),
+		defaultPlatform:     defaultPlatform,
+		imageDirectory:      imageDirectory,
+		tarballHoldTimeout:  tarballHoldTimeout,
+		interrupt:           interrupt}
 
 	SetupHTTPServer(imageFacade)
 
@@ -72,19 +152,227 @@ func NewImageFacade(dockerRegistries []*common.RegistryAuth, createImagesOnly bo
 		}
 	}()
 
+	if !createImagesOnly {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(tarballExpiryPause):
+					imageFacade.removeExpiredTarballs()
+				}
+			}
+		}()
+	}
+
 	return imageFacade
 }
 
-// pullImage is used to pull the artifacts into local for scanning
-func (imf *ImageFacade) pullImage(image *common.Image) error {
+// pullImage is used to pull the artifacts into local for scanning.  Images
+// that match a mirror rule are pulled from each of the rule's mirrors in turn,
+// then from their original registry.
+func (imf *ImageFacade) pullImage(image *common.Image) (*PullResult, error) {
 	var err error
+	for _, pullSpec := range imf.mirrors.pullSpecs(image.PullSpec) {
+		var result *PullResult
+		result, err = imf.pullImageFrom(image, pullSpec)
+		if pullSpec == image.PullSpec {
+			return result, err
+		}
+		recordMirrorPullResult(err == nil)
+		if err == nil {
+			log.Infof("pulled %s from mirror %s", image.PullSpec, pullSpec)
+			return result, nil
+		}
+		if imf.isInterrupted() {
+			return nil, err
+		}
+		log.Warnf("unable to pull %s from mirror %s, falling back: %s", image.PullSpec, pullSpec, err.Error())
+	}
+	return nil, err
+}
+
+// pullImageFrom pulls an image by one of its pull specs: its own, or a
+// mirror's.  For a multi-arch image, it pulls the selected platform's manifest.
+func (imf *ImageFacade) pullImageFrom(image *common.Image, pullSpec string) (*PullResult, error) {
+	err := imf.rateLimiter.Acquire(pullSpec)
+	if err != nil {
+		recordImagePullResult(false)
+		return nil, err
+	}
+
+	resolution, err := imf.resolvePlatform(image, pullSpec)
+	if err != nil {
+		recordImagePullResult(false)
+		return nil, err
+	}
+	var pulled imagepullerinterface.Image = image
+	if resolution.PullSpec != image.PullSpec {
+		pulled = &rewrittenImage{Image: image, pullSpec: resolution.PullSpec, listPullSpec: manifestListPullSpec(pullSpec, resolution)}
+	}
+	// whether the pull worked or not, including when a mirror's failed and
+	// the next one's tried, what it left in the docker daemon is cleaned up
+	defer imf.removeCreatedImage(pulled)
+
 	if imf.createImagesOnly {
-		err = imf.imagePuller.CreateImageInLocalDocker(image)
+		err = imf.imagePuller.CreateImageInLocalDocker(pulled)
 	} else {
-		err = imf.imagePuller.PullImage(image)
+		err = imf.imagePuller.PullImage(pulled)
+		if err != nil {
+			removePartialTarFile(pulled.DockerTarFilePath())
+		}
 	}
 	recordImagePullResult(err == nil)
-	return err
+	if tooManyRequests, ok := errors.Cause(err).(*common.TooManyRequestsError); ok {
+		imf.rateLimiter.ObserveTooManyRequests(pullSpec, tooManyRequests.RetryAfter)
+	} else if err == nil {
+		imf.rateLimiter.ObserveSuccess(pullSpec)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PullResult{
+		Digest:         resolution.Digest,
+		Platform:       resolution.Platform,
+		ManifestDigest: resolution.ManifestDigest,
+		Platforms:      resolution.Platforms}
+	if !imf.createImagesOnly {
+		result.TarballPath = image.DockerTarFilePath()
+	}
+	if result.Digest == "" {
+		result.Digest = imf.resolveDigest(image, pulled)
+	}
+	return result, nil
+}
+
+// resolvePlatform finds the image to pull for the selected platform, or the
+// default one.  If the registry can't tell, an image without a selected
+// platform is pulled as is, and the puller picks the platform.  So is a
+// single-platform image, unless another platform than the default was
+// selected, which it has to be built for.
+func (imf *ImageFacade) resolvePlatform(image *common.Image, pullSpec string) (*common.PlatformResolution, error) {
+	platform := image.Platform
+	if platform == "" {
+		platform = imf.defaultPlatform
+	}
+	requirePlatform := platform != imf.defaultPlatform
+	resolution, err := imf.platformResolver.ResolvePlatform(pullSpec, platform, requirePlatform)
+	recordPlatformResolution(err == nil)
+	if err == nil {
+		log.Infof("resolved %s for platform %s to %s", pullSpec, resolution.Platform, resolution.PullSpec)
+		return resolution, nil
+	}
+	if image.Platform != "" {
+		return nil, errors.Annotatef(err, "unable to resolve %s for platform %s", pullSpec, image.Platform)
+	}
+	log.Warnf("unable to resolve %s for platform %s, pulling it as is: %s", pullSpec, platform, err.Error())
+	return &common.PlatformResolution{PullSpec: pullSpec}, nil
+}
+
+// manifestListPullSpec returns the pull spec of the manifest list that an image
+// was resolved out of, unless the image is for another platform than the
+// node's, which the docker daemon's image of the list isn't
+func manifestListPullSpec(pullSpec string, resolution *common.PlatformResolution) string {
+	if resolution.ManifestDigest == "" || resolution.ManifestDigest == resolution.Digest {
+		return ""
+	}
+	node, err := common.ParsePlatform(common.DefaultPlatform())
+	if err != nil {
+		return ""
+	}
+	resolved, err := common.ParsePlatform(resolution.Platform)
+	if err != nil {
+		return ""
+	}
+	if matches, _ := node.Matches(resolved); !matches {
+		return ""
+	}
+	return common.RepositoryOf(pullSpec) + "@" + resolution.Digest
+}
+
+// removeCreatedImage cleans the docker daemon up after an image has been
+// exported to its tarball, or has failed to be.  In CreateImagesOnly mode, the
+// image in the docker daemon is what was asked for, so it's kept.
+func (imf *ImageFacade) removeCreatedImage(image imagepullerinterface.Image) {
+	if !imf.removeCreatedImages || imf.createImagesOnly {
+		return
+	}
+	if err := imf.imagePuller.RemoveCreatedImage(image); err != nil {
+		log.Errorf("unable to remove image %s from the docker daemon: %s", image.DockerPullSpec(), err.Error())
+	}
+}
+
+// isInterrupted returns true once the drain period is over
+func (imf *ImageFacade) isInterrupted() bool {
+	select {
+	case <-imf.interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// resolveDigest returns the digest of a pulled image, when the registry
+// couldn't be asked for it.  Failing to resolve it doesn't fail the pull: the
+// tarball is still good to scan.  Mirrors serve the same content, so the
+// digest resolved through one is the image's.
+func (imf *ImageFacade) resolveDigest(image *common.Image, pulled imagepullerinterface.Image) string {
+	if digest := common.DigestOf(image.PullSpec); digest != "" {
+		return digest
+	}
+	digest, err := imf.imagePuller.ResolveDigest(pulled)
+	if err != nil {
+		log.Errorf("unable to resolve digest of %s: %s", image.PullSpec, err.Error())
+		recordDigestResolution(false)
+		return ""
+	}
+	log.Infof("resolved %s to %s", image.PullSpec, digest)
+	recordDigestResolution(true)
+	return digest
+}
+
+// removePartialTarFile cleans up after a pull that failed part of the way through
+func removePartialTarFile(path string) {
+	err := os.Remove(path)
+	if err == nil {
+		log.Infof("removed partially written tar file %s", path)
+	} else if !os.IsNotExist(err) {
+		log.Errorf("unable to remove partially written tar file %s: %s", path, err.Error())
+	}
+}
+
+// Shutdown stops accepting image pulls, and gives the pull in progress until the
+// end of drainPeriod to finish.  After that, the pull is cancelled.
+func (imf *ImageFacade) Shutdown(drainPeriod time.Duration) {
+	imf.mutex.Lock()
+	if imf.shuttingDown {
+		imf.mutex.Unlock()
+		return
+	}
+	imf.shuttingDown = true
+	imf.mutex.Unlock()
+
+	log.Infof("draining image pulls for up to %s", drainPeriod)
+	done := make(chan struct{})
+	go func() {
+		imf.pulls.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Infof("image pulls drained")
+		return
+	case <-time.After(drainPeriod):
+	}
+
+	log.Warnf("drain period of %s is over, interrupting the image pull in progress", drainPeriod)
+	close(imf.interrupt)
+	select {
+	case <-done:
+	case <-time.After(interruptedPullTimeout):
+		log.Errorf("timed out waiting for the interrupted image pull to finish")
+	}
 }
 
 // pullDiskMetrics is to print the host disk metrics
@@ -99,30 +387,201 @@ func (imf *ImageFacade) pullDiskMetrics() {
 	}
 }
 
+// removeExpiredTarballs removes the tarballs that have been held for longer
+// than the hold timeout without being released
+func (imf *ImageFacade) removeExpiredTarballs() {
+	for _, path := range imf.model.ExpireHolders(imf.tarballHoldTimeout) {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		recordTarballRemoval(err == nil)
+		if err != nil {
+			log.Errorf("unable to remove expired tarball %s: %s", path, err.Error())
+		} else {
+			log.Infof("removed expired tarball %s", path)
+		}
+	}
+}
+
 // HTTPResponder implementation
 
-// PullImage is used to pull the artifacts into local for scanning
-func (imf *ImageFacade) PullImage(image *common.Image) error {
-	err := imf.model.StartImagePull(image)
+// PullImage queues an image to be pulled into local for scanning, and returns
+// its status, which includes its place in the queue
+func (imf *ImageFacade) PullImage(image *common.Image) (*api.CheckImageResponse, error) {
+	imf.mutex.Lock()
+	shuttingDown := imf.shuttingDown
+	imf.mutex.Unlock()
+	if shuttingDown {
+		return nil, fmt.Errorf("unable to pull image %s: shutting down", image.PullSpec)
+	}
+	image = imf.localImage(image)
+	err := imf.model.QueueImagePull(image)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	imf.startNextPull()
+	return imf.model.CheckImage(image), nil
+}
+
+// startNextPull starts pulling the next queued image, unless a pull is in
+// progress.  Each pull starts the next one when it's done.
+func (imf *ImageFacade) startNextPull() {
+	imf.mutex.Lock()
+	defer imf.mutex.Unlock()
+	if imf.shuttingDown {
+		return
+	}
+	image, wait := imf.model.StartNextImagePull(imf.registryDelay, imf.maxRegistryWait)
+	if image == nil {
+		if wait > 0 {
+			imf.retryPullAfter(wait)
+		}
+		return
+	}
+	imf.pulls.Add(1)
 	go func() {
-		pullErr := imf.pullImage(image)
+		defer imf.pulls.Done()
+		result, pullErr := imf.pullImage(image)
 		if pullErr != nil {
 			log.Errorf("unable to pull image: %s", pullErr.Error())
 		}
-		finishErr := imf.model.finishImagePull(image, pullErr)
+		finishErr := imf.model.FinishImagePull(image, result, pullErr)
 		if finishErr != nil {
 			log.Errorf("unable to finish image pull: %s", finishErr.Error())
 		}
+		imf.startNextPull()
 	}()
-	return nil
+}
+
+// registryDelay returns how long a pull of image has to wait for the first
+// registry it's pulled from
+func (imf *ImageFacade) registryDelay(image *common.Image) time.Duration {
+	return imf.rateLimiter.Delay(imf.mirrors.pullSpecs(image.PullSpec)[0])
+}
+
+// retryPullAfter starts the next pull after wait, unless it's already due to
+// start sooner.  It must be called with the mutex held.
+func (imf *ImageFacade) retryPullAfter(wait time.Duration) {
+	retryAt := time.Now().Add(wait)
+	if imf.retryTimer != nil {
+		if !imf.retryAt.After(retryAt) {
+			return
+		}
+		imf.retryTimer.Stop()
+	}
+	log.Infof("every queued pull is rate limited, trying again in %s", wait)
+	imf.retryAt = retryAt
+	imf.retryTimer = time.AfterFunc(wait, func() {
+		imf.mutex.Lock()
+		imf.retryTimer = nil
+		imf.mutex.Unlock()
+		imf.startNextPull()
+	})
 }
 
 // GetImage is used to get to the image status
-func (imf *ImageFacade) GetImage(image *common.Image) common.ImageStatus {
-	return imf.model.GetImageStatus(image)
+func (imf *ImageFacade) GetImage(image *common.Image) *api.CheckImageResponse {
+	return imf.model.CheckImage(image)
+}
+
+// InspectImage reads a pulled image's config and layers from its tarball
+func (imf *ImageFacade) InspectImage(image *common.Image) (*api.InspectImageResponse, error) {
+	if status := imf.model.CheckImage(image).ImageStatus; status != common.ImageStatusDone {
+		return nil, errors.NotFoundf("pulled image %s (status %s)", image.ID(), status.String())
+	}
+	if imf.createImagesOnly {
+		return nil, errors.NotSupportedf("inspecting images that were only created in the docker daemon")
+	}
+	response, err := InspectTarball(imf.localImage(image).DockerTarFilePath())
+	recordImageInspection(err == nil)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to inspect %s", image.ID())
+	}
+	response.PullSpec = image.PullSpec
+	return response, nil
+}
+
+// TarballPath returns where a pulled image's tarball is, for it to be
+// downloaded.  Only tarballs in the image directory are served: images pulled
+// to a directory of their own are for scanners that share it.
+func (imf *ImageFacade) TarballPath(image *common.Image) (string, error) {
+	if imf.createImagesOnly {
+		return "", errors.NotSupportedf("downloading images that were only created in the docker daemon")
+	}
+	path, err := imf.model.Tarball(image)
+	if err != nil {
+		return "", err
+	}
+	if filepath.Dir(path) != filepath.Clean(imf.imageDirectory) {
+		return "", errors.NotFoundf("tarball of %s in image directory %s", image.ID(), imf.imageDirectory)
+	}
+	return path, nil
+}
+
+// RemoveTarball releases a pulled image's tarball, once a request for it is
+// done with it.  The tarball is removed once every request has released it.
+func (imf *ImageFacade) RemoveTarball(image *common.Image) error {
+	if imf.createImagesOnly {
+		return errors.NotSupportedf("removing images that were only created in the docker daemon")
+	}
+	path, holders, err := imf.model.ReleaseTarball(image)
+	if err != nil {
+		return err
+	}
+	if holders > 0 {
+		log.Infof("keeping tarball %s for %d more requests", path, holders)
+		return nil
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return errors.NotFoundf("tarball %s", path)
+	}
+	recordTarballRemoval(err == nil)
+	return errors.Annotatef(err, "unable to remove tarball %s", path)
+}
+
+// localImage writes images that don't name a directory, which are for
+// scanners that download their tarballs, to the image directory
+func (imf *ImageFacade) localImage(image *common.Image) *common.Image {
+	if image.Directory != "" {
+		return image
+	}
+	local := *image
+	local.Directory = imf.imageDirectory
+	return &local
+}
+
+// CheckLiveness checks that the model's action loop isn't stalled
+func (imf *ImageFacade) CheckLiveness() map[string]error {
+	return map[string]error{"model": imf.model.Ping(livenessTimeout)}
+}
+
+// CheckReadiness checks that images can be pulled: the puller's backend and the
+// registries are reachable, and tarballs can be written
+func (imf *ImageFacade) CheckReadiness() map[string]error {
+	results := map[string]error{
+		"imagePuller":    imf.imagePuller.Ping(),
+		"imageDirectory": common.CheckDirectoryWritable(imf.imageDirectory),
+	}
+	for _, registry := range imf.credentials.Registries() {
+		// a wildcard doesn't name a registry that can be pinged
+		if strings.Contains(registry.URL, "*") {
+			continue
+		}
+		tlsConfig, err := common.FindRegistryConfigForURL(registry.URL, imf.registries).TLSConfig()
+		if err != nil {
+			results["registry "+registry.URL] = err
+			continue
+		}
+		results["registry "+registry.URL] = common.PingRegistry(registry.URL, tlsConfig, registryPingTimeout)
+	}
+	imf.mutex.Lock()
+	if imf.shuttingDown {
+		results["shutdown"] = fmt.Errorf("shutting down")
+	}
+	imf.mutex.Unlock()
+	return results
 }
 
 // GetModel returns the api model
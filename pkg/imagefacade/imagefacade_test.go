@@ -0,0 +1,379 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package imagefacade
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/blackducksoftware/perceptor-scanner/pkg/interfaces"
+	"github.com/juju/errors"
+)
+
+func TestRemoveCreatedImages(t *testing.T) {
+	testCases := []struct {
+		removeCreatedImages bool
+		createImagesOnly    bool
+		expectedRemovals    int
+	}{
+		{false, false, 0},
+		{true, false, 1},
+		{true, true, 0},
+	}
+	for _, testCase := range testCases {
+		rules, _ := NewMirrorRules(nil)
+		puller := &fakeImagePuller{}
+		imf := &ImageFacade{
+			imagePuller:         puller,
+			mirrors:             rules,
+			rateLimiter:         common.NewRegistryRateLimiter(nil),
+			platformResolver:    &fakePlatformResolver{},
+			removeCreatedImages: testCase.removeCreatedImages,
+			createImagesOnly:    testCase.createImagesOnly,
+			interrupt:           make(chan struct{})}
+		if _, err := imf.pullImage(common.NewImage("/var/images", "nginx:1.15")); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if len(puller.removed) != testCase.expectedRemovals {
+			t.Errorf("expected %d removals with removeCreatedImages %t and createImagesOnly %t, got %d",
+				testCase.expectedRemovals, testCase.removeCreatedImages, testCase.createImagesOnly, len(puller.removed))
+		}
+	}
+}
+
+func TestRemoveCreatedImagesOfFailedPulls(t *testing.T) {
+	rules, err := NewMirrorRules([]*MirrorRule{
+		{Prefix: "docker.io/", Mirrors: []string{"mirror.example.com/"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	puller := &fakeImagePuller{failures: map[string]bool{
+		"mirror.example.com/library/nginx:1.15": true,
+		"quay.io/coreos/etcd":                   true,
+	}}
+	imf := &ImageFacade{
+		imagePuller:         puller,
+		mirrors:             rules,
+		rateLimiter:         common.NewRegistryRateLimiter(nil),
+		platformResolver:    &fakePlatformResolver{},
+		removeCreatedImages: true,
+		interrupt:           make(chan struct{})}
+
+	// the mirror's attempt is cleaned up before falling back to the origin
+	if _, err = imf.pullImage(common.NewImage("/var/images", "nginx:1.15")); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(puller.removed) != 2 || puller.removed[0].DockerPullSpec() != "mirror.example.com/library/nginx:1.15" {
+		t.Errorf("expected the mirror's and the origin's images to be removed, got %v", puller.removed)
+	}
+
+	if _, err = imf.pullImage(common.NewImage("/var/images", "quay.io/coreos/etcd")); err == nil {
+		t.Fatalf("expected error pulling etcd")
+	}
+	if len(puller.removed) != 3 || puller.removed[2].DockerPullSpec() != "quay.io/coreos/etcd" {
+		t.Errorf("expected the failed pull's image to be removed, got %v", puller.removed)
+	}
+}
+
+func TestPullImageResolvesPlatform(t *testing.T) {
+	resolver := &fakePlatformResolver{resolutions: map[string]*common.PlatformResolution{
+		"nginx:1.15 linux/arm64": {
+			PullSpec:       "docker.io/library/nginx@sha256:arm64",
+			Digest:         "sha256:list",
+			Platform:       "linux/arm64/v8",
+			ManifestDigest: "sha256:arm64",
+			Platforms:      []string{"linux/amd64", "linux/arm64/v8"}},
+	}}
+	rules, err := NewMirrorRules(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	puller := &fakeImagePuller{}
+	imf := &ImageFacade{
+		imagePuller:      puller,
+		mirrors:          rules,
+		rateLimiter:      common.NewRegistryRateLimiter(nil),
+		platformResolver: resolver,
+		defaultPlatform:  "linux/amd64",
+		interrupt:        make(chan struct{})}
+
+	image := common.NewImage("/var/images", "nginx:1.15")
+	image.Platform = "linux/arm64"
+	result, err := imf.pullImage(image)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if result.Digest != "sha256:list" || result.ManifestDigest != "sha256:arm64" || result.Platform != "linux/arm64/v8" || len(result.Platforms) != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if puller.pulled[0].DockerPullSpec() != "docker.io/library/nginx@sha256:arm64" {
+		t.Errorf("expected the platform's manifest to be pulled, got %s", puller.pulled[0].DockerPullSpec())
+	}
+	if puller.pulled[0].DockerTarFilePath() != image.DockerTarFilePath() {
+		t.Errorf("expected tarball %s, got %s", image.DockerTarFilePath(), puller.pulled[0].DockerTarFilePath())
+	}
+
+	// the default platform can't be resolved: the image is pulled as is
+	result, err = imf.pullImage(common.NewImage("/var/images", "nginx:1.15"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if result.Digest != "sha256:nginx:1.15" || result.Platform != "" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// a selected platform that can't be resolved fails the pull
+	image = common.NewImage("/var/images", "nginx:1.15")
+	image.Platform = "linux/s390x"
+	if _, err = imf.pullImage(image); err == nil {
+		t.Errorf("expected error pulling unresolvable platform")
+	}
+	if len(puller.pulled) != 2 {
+		t.Errorf("expected 2 pulls, got %d", len(puller.pulled))
+	}
+	// only platforms other than the default have to be checked on single-platform images
+	if !reflect.DeepEqual(resolver.required, []bool{true, false, true}) {
+		t.Errorf("expected only the selected platforms to be required, got %v", resolver.required)
+	}
+}
+
+func TestManifestListPullSpec(t *testing.T) {
+	resolution := &common.PlatformResolution{
+		PullSpec:       "nginx@sha256:platform",
+		Digest:         "sha256:list",
+		Platform:       common.DefaultPlatform(),
+		ManifestDigest: "sha256:platform"}
+	if pullSpec := manifestListPullSpec("nginx:1.15", resolution); pullSpec != "nginx@sha256:list" {
+		t.Errorf("expected the list's pull spec for the node's platform, got %s", pullSpec)
+	}
+	resolution.Platform = "plan9/mips"
+	if pullSpec := manifestListPullSpec("nginx:1.15", resolution); pullSpec != "" {
+		t.Errorf("expected no list pull spec for another platform, got %s", pullSpec)
+	}
+	// a single-platform image's digest is its manifest's
+	resolution = &common.PlatformResolution{PullSpec: "nginx:1.15", Digest: "sha256:abc", Platform: common.DefaultPlatform(), ManifestDigest: "sha256:abc"}
+	if pullSpec := manifestListPullSpec("nginx:1.15", resolution); pullSpec != "" {
+		t.Errorf("expected no list pull spec for a single-platform image, got %s", pullSpec)
+	}
+}
+
+func TestTarballs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tarballs")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	stop := make(chan struct{})
+	defer close(stop)
+	imf := &ImageFacade{model: NewModel(10, true, stop), imageDirectory: dir}
+
+	// the image doesn't name a directory, so it's written to the image directory
+	image := imf.localImage(&common.Image{PullSpec: "nginx:1.15", Platform: "linux/arm64"})
+	if image.Directory != dir {
+		t.Errorf("expected image in %s, got %s", dir, image.Directory)
+	}
+	if _, err = imf.TarballPath(image); !errors.IsNotFound(err) {
+		t.Errorf("expected not found error for an image that isn't pulled, got %v", err)
+	}
+	// two scanners ask for the same image: the second is coalesced with the first
+	for i := 0; i < 2; i++ {
+		if err = imf.model.QueueImagePull(image); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	imf.model.StartNextImagePull(noRegistryDelay, 0)
+	if err = imf.model.FinishImagePull(image, &PullResult{Digest: "sha256:abc", TarballPath: image.DockerTarFilePath()}, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err = ioutil.WriteFile(image.DockerTarFilePath(), []byte("tarball"), 0644); err != nil {
+		t.Fatalf("unable to write tarball: %s", err.Error())
+	}
+
+	path, err := imf.TarballPath(image)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if path != image.DockerTarFilePath() {
+		t.Errorf("expected tarball %s, got %s", image.DockerTarFilePath(), path)
+	}
+
+	// the first scanner is done with it: it's kept for the second
+	if err = imf.RemoveTarball(image); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, err = os.Stat(path); err != nil {
+		t.Errorf("expected %s to be kept for the second scanner, got %s", path, err.Error())
+	}
+	if _, err = imf.TarballPath(image); err != nil {
+		t.Errorf("expected the second scanner to find the tarball, got %s", err.Error())
+	}
+
+	if err = imf.RemoveTarball(image); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", path)
+	}
+	if _, err = imf.TarballPath(image); !errors.IsNotFound(err) {
+		t.Errorf("expected not found error for a removed tarball, got %v", err)
+	}
+	if err = imf.RemoveTarball(image); !errors.IsNotFound(err) {
+		t.Errorf("expected not found error for a removed tarball, got %v", err)
+	}
+
+	// an image pulled to a directory of its own isn't served
+	shared := &common.Image{Directory: filepath.Join(dir, "shared"), PullSpec: "redis"}
+	if err = imf.model.QueueImagePull(shared); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	imf.model.StartNextImagePull(noRegistryDelay, 0)
+	if err = imf.model.FinishImagePull(shared, &PullResult{Digest: "sha256:def", TarballPath: shared.DockerTarFilePath()}, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, err = imf.TarballPath(shared); !errors.IsNotFound(err) {
+		t.Errorf("expected not found error for a tarball outside the image directory, got %v", err)
+	}
+
+	// once it's been removed, the image is pulled again
+	if err = imf.model.QueueImagePull(image); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if status := imf.model.CheckImage(image).ImageStatus; status != common.ImageStatusQueued {
+		t.Errorf("expected the image to be queued again, got %s", status.String())
+	}
+}
+
+// blockingImagePuller's pulls run until they're released or interrupted
+type blockingImagePuller struct {
+	fakeImagePuller
+	started   chan struct{}
+	release   chan struct{}
+	interrupt chan struct{}
+}
+
+func (puller *blockingImagePuller) PullImage(image interfaces.Image) error {
+	close(puller.started)
+	select {
+	case <-puller.release:
+		return puller.fakeImagePuller.PullImage(image)
+	case <-puller.interrupt:
+		return fmt.Errorf("pull of %s was interrupted", image.DockerPullSpec())
+	}
+}
+
+// newShutdownTestImageFacade returns an image facade with a pull in progress
+func newShutdownTestImageFacade(t *testing.T, dir string, stop chan struct{}) (*ImageFacade, *blockingImagePuller, *common.Image) {
+	rules, err := NewMirrorRules(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	interrupt := make(chan struct{})
+	puller := &blockingImagePuller{started: make(chan struct{}), release: make(chan struct{}), interrupt: interrupt}
+	imf := &ImageFacade{
+		model:            NewModel(10, false, stop),
+		imagePuller:      puller,
+		mirrors:          rules,
+		rateLimiter:      common.NewRegistryRateLimiter(nil),
+		platformResolver: &fakePlatformResolver{},
+		credentials:      common.NewRegistryCredentials(nil, nil),
+		imageDirectory:   dir,
+		interrupt:        interrupt}
+	image := common.NewImage(dir, "nginx:1.15")
+	if _, err = imf.PullImage(image); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	<-puller.started
+	return imf, puller, image
+}
+
+func TestShutdownDrainsPulls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shutdown")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	stop := make(chan struct{})
+	defer close(stop)
+	imf, puller, image := newShutdownTestImageFacade(t, dir, stop)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(puller.release)
+	}()
+	imf.Shutdown(time.Minute)
+
+	select {
+	case <-imf.interrupt:
+		t.Errorf("expected a pull that finished within the drain period not to be interrupted")
+	default:
+	}
+	if status := imf.model.CheckImage(image).ImageStatus; status != common.ImageStatusDone {
+		t.Errorf("expected the drained pull to be done, got %s", status)
+	}
+	if _, err = imf.PullImage(common.NewImage(dir, "redis:4")); err == nil {
+		t.Errorf("expected pulls to be refused while shutting down")
+	}
+	if _, ok := imf.CheckReadiness()["shutdown"]; !ok {
+		t.Errorf("expected not to be ready while shutting down")
+	}
+}
+
+func TestShutdownInterruptsPulls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shutdown")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	stop := make(chan struct{})
+	defer close(stop)
+	imf, puller, image := newShutdownTestImageFacade(t, dir, stop)
+
+	start := time.Now()
+	imf.Shutdown(10 * time.Millisecond)
+	if elapsed := time.Now().Sub(start); elapsed > interruptedPullTimeout {
+		t.Errorf("expected the shutdown to finish once the interrupted pull did, took %s", elapsed)
+	}
+	select {
+	case <-imf.interrupt:
+	default:
+		t.Errorf("expected the pull to be interrupted once the drain period was over")
+	}
+	if len(puller.pulled) != 0 {
+		t.Errorf("expected the interrupted pull not to complete, got %v", puller.pulled)
+	}
+	if status := imf.model.CheckImage(image).ImageStatus; status != common.ImageStatusError {
+		t.Errorf("expected the interrupted pull to fail, got %s", status)
+	}
+}
+
+func TestDefaultShutdownFitsGracePeriod(t *testing.T) {
+	if shutdown := maxShutdownDuration(&ImageFacadeConfig{}); shutdown > terminationGracePeriod {
+		t.Errorf("expected the default shutdown to fit in %s, it can take %s", terminationGracePeriod, shutdown)
+	}
+}
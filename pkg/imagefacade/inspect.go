@@ -0,0 +1,199 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package imagefacade
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/api"
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/juju/errors"
+)
+
+const tarballManifestPath = "manifest.json"
+
+// tarballManifest is an entry of a docker-archive tarball's manifest.json,
+// as written by both docker save and skopeo
+type tarballManifest struct {
+	Config string
+	Layers []string
+}
+
+// imageConfigFile holds the fields of an image's config that are reported
+type imageConfigFile struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant"`
+	Config       struct {
+		Entrypoint   []string
+		Cmd          []string
+		Env          []string
+		Labels       map[string]string
+		ExposedPorts map[string]struct{}
+		User         string
+		WorkingDir   string
+	} `json:"config"`
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+	History []struct {
+		Created    string `json:"created"`
+		CreatedBy  string `json:"created_by"`
+		Comment    string `json:"comment"`
+		EmptyLayer bool   `json:"empty_layer"`
+	} `json:"history"`
+}
+
+// InspectTarball reads an image's config and layers from its docker-archive
+// tarball
+func InspectTarball(path string) (*api.InspectImageResponse, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to open %s", path)
+	}
+	defer file.Close()
+
+	// manifest.json names the config, but may come after it in the tarball
+	var manifestBytes []byte
+	sizes := map[string]int64{}
+	err = walkTarball(file, func(header *tar.Header, reader io.Reader) (bool, error) {
+		sizes[header.Name] = header.Size
+		if header.Name == tarballManifestPath {
+			bytes, readErr := ioutil.ReadAll(reader)
+			manifestBytes = bytes
+			return false, readErr
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to read %s", path)
+	}
+	if manifestBytes == nil {
+		return nil, fmt.Errorf("%s has no %s", path, tarballManifestPath)
+	}
+	var manifests []*tarballManifest
+	if err = json.Unmarshal(manifestBytes, &manifests); err != nil {
+		return nil, errors.Annotatef(err, "unable to parse %s of %s", tarballManifestPath, path)
+	}
+	if len(manifests) != 1 {
+		return nil, fmt.Errorf("expected 1 image in %s, found %d", path, len(manifests))
+	}
+	manifest := manifests[0]
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Annotatef(err, "unable to rewind %s", path)
+	}
+	var configBytes []byte
+	err = walkTarball(file, func(header *tar.Header, reader io.Reader) (bool, error) {
+		if header.Name != manifest.Config {
+			return false, nil
+		}
+		bytes, readErr := ioutil.ReadAll(reader)
+		configBytes = bytes
+		return true, readErr
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to read %s", path)
+	}
+	if configBytes == nil {
+		return nil, fmt.Errorf("%s has no config %s", path, manifest.Config)
+	}
+	var config imageConfigFile
+	if err = json.Unmarshal(configBytes, &config); err != nil {
+		return nil, errors.Annotatef(err, "unable to parse config of %s", path)
+	}
+
+	response := &api.InspectImageResponse{
+		ID:           fmt.Sprintf("sha256:%x", sha256.Sum256(configBytes)),
+		OS:           config.OS,
+		Architecture: config.Architecture,
+		Variant:      config.Variant,
+		Entrypoint:   config.Config.Entrypoint,
+		Cmd:          config.Config.Cmd,
+		Env:          redactEnv(config.Config.Env),
+		Labels:       config.Config.Labels,
+		ExposedPorts: []string{},
+		User:         config.Config.User,
+		WorkingDir:   config.Config.WorkingDir,
+		Layers:       []*api.ImageLayer{},
+		History:      []*api.ImageHistory{}}
+	for port := range config.Config.ExposedPorts {
+		response.ExposedPorts = append(response.ExposedPorts, port)
+	}
+	sort.Strings(response.ExposedPorts)
+	for i, layerPath := range manifest.Layers {
+		size, ok := sizes[layerPath]
+		if !ok {
+			return nil, fmt.Errorf("%s has no layer %s", path, layerPath)
+		}
+		layer := &api.ImageLayer{Size: size}
+		if i < len(config.RootFS.DiffIDs) {
+			layer.Digest = config.RootFS.DiffIDs[i]
+		}
+		response.Layers = append(response.Layers, layer)
+		response.Size += size
+	}
+	for _, history := range config.History {
+		response.History = append(response.History, &api.ImageHistory{
+			Created:    history.Created,
+			CreatedBy:  history.CreatedBy,
+			Comment:    history.Comment,
+			EmptyLayer: history.EmptyLayer})
+	}
+	return response, nil
+}
+
+// walkTarball calls visit with each file in a tarball, until it says it's done
+func walkTarball(reader io.Reader, visit func(header *tar.Header, reader io.Reader) (bool, error)) error {
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		done, err := visit(header, tarReader)
+		if err != nil || done {
+			return errors.Trace(err)
+		}
+	}
+}
+
+// redactEnv keeps the names of environment variables, but not their values
+func redactEnv(env []string) []string {
+	redacted := []string{}
+	for _, variable := range env {
+		name := strings.SplitN(variable, "=", 2)[0]
+		redacted = append(redacted, name+"="+common.RedactedValue)
+	}
+	return redacted
+}
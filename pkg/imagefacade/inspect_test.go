@@ -0,0 +1,128 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package imagefacade
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/api"
+)
+
+const testImageConfig = `{
+  "os": "linux",
+  "architecture": "arm64",
+  "variant": "v8",
+  "config": {
+    "Entrypoint": ["/docker-entrypoint.sh"],
+    "Cmd": ["nginx", "-g", "daemon off;"],
+    "Env": ["PATH=/usr/sbin:/usr/bin", "DB_PASSWORD=hunter22"],
+    "Labels": {"maintainer": "NGINX Docker Maintainers"},
+    "ExposedPorts": {"80/tcp": {}, "443/tcp": {}},
+    "User": "nginx"
+  },
+  "rootfs": {"type": "layers", "diff_ids": ["sha256:layer1", "sha256:layer2"]},
+  "history": [
+    {"created": "2018-06-01T00:00:00Z", "created_by": "ADD file:abc in /"},
+    {"created": "2018-06-01T00:00:01Z", "created_by": "ENV PATH=/usr/sbin:/usr/bin", "empty_layer": true},
+    {"created": "2018-06-01T00:00:02Z", "created_by": "RUN apt-get install nginx"}
+  ]
+}`
+
+// writeTestTarball writes a docker save tarball, with the config after
+// manifest.json
+func writeTestTarball(t *testing.T, path string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", path, err.Error())
+	}
+	defer file.Close()
+	writer := tar.NewWriter(file)
+	entries := []struct {
+		name     string
+		contents string
+	}{
+		{"manifest.json", `[{"Config":"abc.json","RepoTags":["nginx:1.15"],"Layers":["1/layer.tar","2/layer.tar"]}]`},
+		{"abc.json", testImageConfig},
+		{"1/layer.tar", "0123456789"},
+		{"2/layer.tar", "01234"},
+	}
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.contents))}
+		if err = writer.WriteHeader(header); err != nil {
+			t.Fatalf("unable to write %s: %s", entry.name, err.Error())
+		}
+		if _, err = writer.Write([]byte(entry.contents)); err != nil {
+			t.Fatalf("unable to write %s: %s", entry.name, err.Error())
+		}
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("unable to close %s: %s", path, err.Error())
+	}
+}
+
+func TestInspectTarball(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspect")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nginx_1.15.tar")
+	writeTestTarball(t, path)
+
+	response, err := InspectTarball(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if response.ID != fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(testImageConfig))) {
+		t.Errorf("unexpected ID %s", response.ID)
+	}
+	if response.OS != "linux" || response.Architecture != "arm64" || response.Variant != "v8" || response.User != "nginx" {
+		t.Errorf("unexpected platform or user in %+v", response)
+	}
+	if !reflect.DeepEqual(response.Env, []string{"PATH=<redacted>", "DB_PASSWORD=<redacted>"}) {
+		t.Errorf("expected redacted env, got %v", response.Env)
+	}
+	if !reflect.DeepEqual(response.ExposedPorts, []string{"443/tcp", "80/tcp"}) {
+		t.Errorf("unexpected exposed ports %v", response.ExposedPorts)
+	}
+	if response.Labels["maintainer"] != "NGINX Docker Maintainers" || len(response.Entrypoint) != 1 || len(response.Cmd) != 3 {
+		t.Errorf("unexpected config in %+v", response)
+	}
+	expectedLayers := []*api.ImageLayer{{Digest: "sha256:layer1", Size: 10}, {Digest: "sha256:layer2", Size: 5}}
+	if !reflect.DeepEqual(response.Layers, expectedLayers) || response.Size != 15 {
+		t.Errorf("unexpected layers %v, size %d", response.Layers, response.Size)
+	}
+	if len(response.History) != 3 || !response.History[1].EmptyLayer || response.History[2].CreatedBy != "RUN apt-get install nginx" {
+		t.Errorf("unexpected history %v", response.History)
+	}
+
+	if _, err = InspectTarball(filepath.Join(dir, "missing.tar")); err == nil {
+		t.Errorf("expected error inspecting missing tarball")
+	}
+}
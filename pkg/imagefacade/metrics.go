@@ -33,6 +33,13 @@ var actionsCounter *prometheus.CounterVec
 var reducerActivityCounter *prometheus.CounterVec
 var diskMetricsGauge *prometheus.GaugeVec
 var imagePullResultCounter *prometheus.CounterVec
+var digestResolutionCounter *prometheus.CounterVec
+var mirrorPullResultCounter *prometheus.CounterVec
+var platformResolutionCounter *prometheus.CounterVec
+var imageInspectionCounter *prometheus.CounterVec
+var tarballRemovalCounter *prometheus.CounterVec
+var pullRequestCounter *prometheus.CounterVec
+var pullQueueLengthGauge prometheus.Gauge
 
 func recordHTTPRequest(path string) {
 	httpRequestsCounter.With(prometheus.Labels{"path": path}).Inc()
@@ -67,6 +74,39 @@ func recordImagePullResult(success bool) {
 	imagePullResultCounter.With(prometheus.Labels{"success": successString}).Inc()
 }
 
+func recordDigestResolution(success bool) {
+	successString := fmt.Sprintf("%t", success)
+	digestResolutionCounter.With(prometheus.Labels{"success": successString}).Inc()
+}
+
+func recordMirrorPullResult(success bool) {
+	successString := fmt.Sprintf("%t", success)
+	mirrorPullResultCounter.With(prometheus.Labels{"success": successString}).Inc()
+}
+
+func recordPlatformResolution(success bool) {
+	successString := fmt.Sprintf("%t", success)
+	platformResolutionCounter.With(prometheus.Labels{"success": successString}).Inc()
+}
+
+func recordImageInspection(success bool) {
+	successString := fmt.Sprintf("%t", success)
+	imageInspectionCounter.With(prometheus.Labels{"success": successString}).Inc()
+}
+
+func recordTarballRemoval(success bool) {
+	successString := fmt.Sprintf("%t", success)
+	tarballRemovalCounter.With(prometheus.Labels{"success": successString}).Inc()
+}
+
+func recordPullRequest(outcome string) {
+	pullRequestCounter.With(prometheus.Labels{"outcome": outcome}).Inc()
+}
+
+func recordPullQueueLength(length int) {
+	pullQueueLengthGauge.Set(float64(length))
+}
+
 func init() {
 	httpRequestsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "perceptor",
@@ -107,4 +147,60 @@ func init() {
 		Help:      "whether image pull/get succeeded or failed",
 	}, []string{"success"})
 	prometheus.MustRegister(imagePullResultCounter)
+
+	digestResolutionCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "imagefacade",
+		Name:      "digest_resolution_result",
+		Help:      "whether resolving the digest of a pulled image succeeded or failed",
+	}, []string{"success"})
+	prometheus.MustRegister(digestResolutionCounter)
+
+	mirrorPullResultCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "imagefacade",
+		Name:      "mirror_pull_result",
+		Help:      "whether pulling an image from a mirror succeeded or failed, before falling back to the original registry",
+	}, []string{"success"})
+	prometheus.MustRegister(mirrorPullResultCounter)
+
+	platformResolutionCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "imagefacade",
+		Name:      "platform_resolution_result",
+		Help:      "whether reading an image's manifest from its registry, to pick the platform to pull, succeeded or failed",
+	}, []string{"success"})
+	prometheus.MustRegister(platformResolutionCounter)
+
+	imageInspectionCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "imagefacade",
+		Name:      "image_inspection_result",
+		Help:      "whether reading a pulled image's config and layers from its tarball succeeded or failed",
+	}, []string{"success"})
+	prometheus.MustRegister(imageInspectionCounter)
+
+	tarballRemovalCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "imagefacade",
+		Name:      "tarball_removal_result",
+		Help:      "whether removing a tarball, once a scanner downloaded it, succeeded or failed",
+	}, []string{"success"})
+	prometheus.MustRegister(tarballRemovalCounter)
+
+	pullRequestCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "imagefacade",
+		Name:      "pull_requests",
+		Help:      "requests to pull images: queued, coalesced with a pull that's queued or in progress, rejected because the queue is full, or failed because their registry is rate limited for too long",
+	}, []string{"outcome"})
+	prometheus.MustRegister(pullRequestCounter)
+
+	pullQueueLengthGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "perceptor",
+		Subsystem: "imagefacade",
+		Name:      "pull_queue_length",
+		Help:      "number of image pulls waiting in the queue",
+	})
+	prometheus.MustRegister(pullQueueLengthGauge)
 }
@@ -32,6 +32,13 @@ func TestMetrics(t *testing.T) {
 	recordDiskMetrics(&DiskMetrics{})
 	recordActionType("abc")
 	recordHTTPRequest("qrs")
+	recordDigestResolution(true)
+	recordMirrorPullResult(false)
+	recordPlatformResolution(true)
+	recordImageInspection(false)
+	recordTarballRemoval(true)
+	recordPullRequest("coalesced")
+	recordPullQueueLength(3)
 	then := time.Now()
 	recordReducerActivity(false, time.Now().Sub(then))
 
@@ -0,0 +1,123 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package imagefacade
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/juju/errors"
+)
+
+// MirrorRule sends pulls of matching images through mirrors.  Rules match
+// normalized pull specs -- docker.io/library/nginx:1.15, never nginx:1.15 --
+// and either Prefix or Regex must be set.
+type MirrorRule struct {
+	// Prefix matches pull specs that start with it, such as docker.io/; it's
+	// replaced with each mirror, such as mirror.example.com/dockerhub/
+	Prefix string
+	// Regex matches pull specs, which are rewritten by using each mirror as the
+	// replacement, so mirrors can refer to submatches as $1 and so on
+	Regex string
+	// Mirrors are tried in order; if they all fail, the original pull spec is
+	Mirrors []string
+}
+
+func (rule *MirrorRule) String() string {
+	if rule.Regex != "" {
+		return fmt.Sprintf("regex %s -> %s", rule.Regex, strings.Join(rule.Mirrors, ", "))
+	}
+	return fmt.Sprintf("prefix %s -> %s", rule.Prefix, strings.Join(rule.Mirrors, ", "))
+}
+
+// MirrorRules is the compiled form of the configured rules
+type MirrorRules struct {
+	rules   []*MirrorRule
+	regexps []*regexp.Regexp
+}
+
+// NewMirrorRules validates rules and compiles their regexes
+func NewMirrorRules(rules []*MirrorRule) (*MirrorRules, error) {
+	compiled := &MirrorRules{rules: rules, regexps: make([]*regexp.Regexp, len(rules))}
+	for i, rule := range rules {
+		if (rule.Prefix == "") == (rule.Regex == "") {
+			return nil, fmt.Errorf("mirror rule %s needs exactly one of Prefix and Regex", rule)
+		}
+		if len(rule.Mirrors) == 0 {
+			return nil, fmt.Errorf("mirror rule %s has no mirrors", rule)
+		}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, errors.Annotatef(err, "invalid regex in mirror rule %s", rule)
+			}
+			compiled.regexps[i] = re
+		}
+	}
+	return compiled, nil
+}
+
+// pullSpecs returns the pull specs to try for an image, in order: the mirrors
+// of the first rule that matches, then the original pull spec
+func (mr *MirrorRules) pullSpecs(pullSpec string) []string {
+	normalized := normalizePullSpec(pullSpec)
+	for i, rule := range mr.rules {
+		var mirrored []string
+		if re := mr.regexps[i]; re != nil {
+			if !re.MatchString(normalized) {
+				continue
+			}
+			for _, mirror := range rule.Mirrors {
+				mirrored = append(mirrored, re.ReplaceAllString(normalized, mirror))
+			}
+		} else {
+			if !strings.HasPrefix(normalized, rule.Prefix) {
+				continue
+			}
+			for _, mirror := range rule.Mirrors {
+				mirrored = append(mirrored, mirror+strings.TrimPrefix(normalized, rule.Prefix))
+			}
+		}
+		return append(mirrored, pullSpec)
+	}
+	return []string{pullSpec}
+}
+
+// normalizePullSpec spells out a pull spec's registry, as well as the library/
+// prefix of official Docker Hub images
+func normalizePullSpec(pullSpec string) string {
+	parsed := common.ParsePullSpec(pullSpec)
+	normalized := parsed.Host
+	if parsed.Port != "" {
+		normalized += ":" + parsed.Port
+	}
+	normalized += "/" + parsed.Repository
+	if parsed.Tag != "" {
+		normalized += ":" + parsed.Tag
+	}
+	if parsed.Digest != "" {
+		normalized += "@" + parsed.Digest
+	}
+	return normalized
+}
@@ -0,0 +1,178 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package imagefacade
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/blackducksoftware/perceptor-scanner/pkg/interfaces"
+)
+
+func TestMirrorRulesPullSpecs(t *testing.T) {
+	rules, err := NewMirrorRules([]*MirrorRule{
+		{Prefix: "docker.io/", Mirrors: []string{"mirror1.example.com/dockerhub/", "mirror2.example.com/"}},
+		{Regex: `^quay\.io/(.*)$`, Mirrors: []string{"mirror.example.com/quay/$1"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	testCases := []struct {
+		pullSpec string
+		expected []string
+	}{
+		{"nginx@sha256:abc", []string{"mirror1.example.com/dockerhub/library/nginx@sha256:abc", "mirror2.example.com/library/nginx@sha256:abc", "nginx@sha256:abc"}},
+		{"docker.io/library/nginx:1.15", []string{"mirror1.example.com/dockerhub/library/nginx:1.15", "mirror2.example.com/library/nginx:1.15", "docker.io/library/nginx:1.15"}},
+		{"quay.io/coreos/etcd:v3", []string{"mirror.example.com/quay/coreos/etcd:v3", "quay.io/coreos/etcd:v3"}},
+		{"registry.example.com:5000/app:1", []string{"registry.example.com:5000/app:1"}},
+	}
+	for _, testCase := range testCases {
+		actual := rules.pullSpecs(testCase.pullSpec)
+		if !reflect.DeepEqual(actual, testCase.expected) {
+			t.Errorf("expected %v for %s, got %v", testCase.expected, testCase.pullSpec, actual)
+		}
+	}
+}
+
+func TestNewMirrorRulesValidation(t *testing.T) {
+	invalid := []*MirrorRule{
+		{Mirrors: []string{"mirror.example.com/"}},
+		{Prefix: "docker.io/", Regex: "^docker", Mirrors: []string{"mirror.example.com/"}},
+		{Prefix: "docker.io/"},
+		{Regex: "(", Mirrors: []string{"mirror.example.com/"}},
+	}
+	for _, rule := range invalid {
+		if _, err := NewMirrorRules([]*MirrorRule{rule}); err == nil {
+			t.Errorf("expected error for mirror rule %s", rule)
+		}
+	}
+}
+
+type fakeImagePuller struct {
+	failures map[string]bool
+	pulled   []interfaces.Image
+	removed  []interfaces.Image
+}
+
+func (puller *fakeImagePuller) PullImage(image interfaces.Image) error {
+	puller.pulled = append(puller.pulled, image)
+	if puller.failures[image.DockerPullSpec()] {
+		return fmt.Errorf("unable to pull %s", image.DockerPullSpec())
+	}
+	return nil
+}
+
+func (puller *fakeImagePuller) CreateImageInLocalDocker(image interfaces.Image) error {
+	return puller.PullImage(image)
+}
+
+func (puller *fakeImagePuller) SaveImageToTar(image interfaces.Image) error {
+	return nil
+}
+
+func (puller *fakeImagePuller) ResolveDigest(image interfaces.Image) (string, error) {
+	return "sha256:" + image.DockerPullSpec(), nil
+}
+
+func (puller *fakeImagePuller) RemoveCreatedImage(image interfaces.Image) error {
+	puller.removed = append(puller.removed, image)
+	return nil
+}
+
+func (puller *fakeImagePuller) Ping() error {
+	return nil
+}
+
+// fakePlatformResolver can't reach any registry, unless it's given resolutions
+type fakePlatformResolver struct {
+	resolutions map[string]*common.PlatformResolution
+	// required records whether each resolution required its platform
+	required []bool
+}
+
+func (resolver *fakePlatformResolver) ResolvePlatform(pullSpec string, platform string, requirePlatform bool) (*common.PlatformResolution, error) {
+	resolver.required = append(resolver.required, requirePlatform)
+	resolution, ok := resolver.resolutions[pullSpec+" "+platform]
+	if !ok {
+		return nil, fmt.Errorf("unable to reach the registry of %s", pullSpec)
+	}
+	return resolution, nil
+}
+
+func TestPullImageFallsBackToOrigin(t *testing.T) {
+	rules, err := NewMirrorRules([]*MirrorRule{
+		{Prefix: "docker.io/", Mirrors: []string{"mirror1.example.com/", "mirror2.example.com/"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	puller := &fakeImagePuller{failures: map[string]bool{
+		"mirror1.example.com/library/nginx:1.15": true,
+		"mirror2.example.com/library/nginx:1.15": true,
+	}}
+	imf := &ImageFacade{imagePuller: puller, mirrors: rules, rateLimiter: common.NewRegistryRateLimiter(nil), platformResolver: &fakePlatformResolver{}, interrupt: make(chan struct{})}
+	image := common.NewImage("/var/images", "nginx:1.15")
+
+	result, err := imf.pullImage(image)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if result.Digest != "sha256:nginx:1.15" {
+		t.Errorf("expected digest resolved from origin, got %s", result.Digest)
+	}
+	if len(puller.pulled) != 3 {
+		t.Fatalf("expected 3 pull attempts, got %d", len(puller.pulled))
+	}
+	for _, pulled := range puller.pulled {
+		if pulled.DockerTarFilePath() != image.DockerTarFilePath() {
+			t.Errorf("expected tarball %s, got %s", image.DockerTarFilePath(), pulled.DockerTarFilePath())
+		}
+	}
+	if puller.pulled[2].DockerPullSpec() != "nginx:1.15" {
+		t.Errorf("expected origin to be pulled last, got %s", puller.pulled[2].DockerPullSpec())
+	}
+}
+
+func TestPullImageFromMirror(t *testing.T) {
+	rules, err := NewMirrorRules([]*MirrorRule{
+		{Prefix: "docker.io/", Mirrors: []string{"mirror.example.com/"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	puller := &fakeImagePuller{}
+	imf := &ImageFacade{imagePuller: puller, mirrors: rules, rateLimiter: common.NewRegistryRateLimiter(nil), platformResolver: &fakePlatformResolver{}, interrupt: make(chan struct{})}
+	image := common.NewImage("/var/images", "nginx")
+
+	result, err := imf.pullImage(image)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if result.Digest != "sha256:mirror.example.com/library/nginx" {
+		t.Errorf("expected digest resolved through mirror, got %s", result.Digest)
+	}
+	if len(puller.pulled) != 1 {
+		t.Errorf("expected only the mirror to be pulled, got %d pulls", len(puller.pulled))
+	}
+}
@@ -25,7 +25,9 @@ import (
 	"fmt"
 	"time"
 
+	api "github.com/blackducksoftware/perceptor-scanner/pkg/api"
 	common "github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/juju/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -38,15 +40,63 @@ type action struct {
 type Model struct {
 	actions chan *action
 	State   ModelState
-	Images  map[string]common.ImageStatus
+	// Images and Results are keyed by the image's ID: its pull spec, and its
+	// platform if one was selected
+	Images map[string]common.ImageStatus
+	// Results holds what each successfully pulled image resolved to
+	Results map[string]*PullResult
+	// Holders counts the requests that a pull is serving, including those
+	// coalesced with it.  Each of them releases the pulled image's tarball
+	// when it's done with it, and the tarball is removed after the last one.
+	// Pulls that only create images in the docker daemon aren't counted.
+	Holders map[string]int
+	// heldAt is when each pulled tarball was written or last released.
+	// Requests that hold one for longer than the hold timeout are given up
+	// on, so that a scanner that died can't keep its tarball forever.
+	heldAt        map[string]time.Time
+	holdsTarballs bool
+	// queue holds the pulls waiting for the one in progress; once it has
+	// maxQueueLength pulls, more are turned away
+	queue          *pullQueue
+	maxQueueLength int
+}
+
+// QueueFullError is returned for pulls that are turned away because the queue
+// is full; they can be requested again later
+type QueueFullError struct {
+	Length int
+}
+
+func (err *QueueFullError) Error() string {
+	return fmt.Sprintf("pull queue is full with %d images", err.Length)
+}
+
+// PullResult describes what was pulled for an image
+type PullResult struct {
+	// Digest is what the pull spec resolved to
+	Digest string
+	// Platform, ManifestDigest and Platforms are only known when the image's
+	// manifest could be read from its registry
+	Platform       string
+	ManifestDigest string
+	Platforms      []string
+	// TarballPath is where the image was written to, unless it was only
+	// created in the docker daemon
+	TarballPath string
 }
 
 // NewModel ...
-func NewModel(stop <-chan struct{}) *Model {
+func NewModel(maxQueueLength int, holdsTarballs bool, stop <-chan struct{}) *Model {
 	model := &Model{
-		actions: make(chan *action),
-		State:   ModelStateReady,
-		Images:  map[string]common.ImageStatus{},
+		actions:        make(chan *action),
+		State:          ModelStateReady,
+		Images:         map[string]common.ImageStatus{},
+		Results:        map[string]*PullResult{},
+		Holders:        map[string]int{},
+		heldAt:         map[string]time.Time{},
+		holdsTarballs:  holdsTarballs,
+		queue:          newPullQueue(),
+		maxQueueLength: maxQueueLength,
 	}
 
 	go func() {
@@ -84,39 +134,120 @@ func NewModel(stop <-chan struct{}) *Model {
 
 // public interface
 
-// StartImagePull ...
-func (model *Model) StartImagePull(image *common.Image) error {
+// QueueImagePull adds an image to the pull queue.  A request for an image
+// that's already queued or being pulled is coalesced with it, as is a request
+// for a pulled image whose tarball hasn't been released by every request yet.
+func (model *Model) QueueImagePull(image *common.Image) error {
 	ch := make(chan error)
-	model.actions <- &action{"startImagePull", func() error {
-		err := model.pullImage(image)
+	model.actions <- &action{"queueImagePull", func() error {
+		err := model.queueImagePull(image)
 		ch <- err
 		return err
 	}}
 	return <-ch
 }
 
-// GetImageStatus ...
-func (model *Model) GetImageStatus(image *common.Image) common.ImageStatus {
-	ch := make(chan common.ImageStatus)
-	model.actions <- &action{"getImageStatus", func() error {
+// StartNextImagePull returns the highest priority queued image that can be
+// pulled now, or nil if a pull is in progress or none can start.  delay says
+// how long an image's registry has to be waited for: images that have to wait
+// are skipped, so that they don't hold up images from other registries, and
+// images that would have to wait longer than maxWait fail.  If no image can
+// start, it also returns how long until one can.
+func (model *Model) StartNextImagePull(delay func(image *common.Image) time.Duration, maxWait time.Duration) (*common.Image, time.Duration) {
+	type next struct {
+		image *common.Image
+		wait  time.Duration
+	}
+	ch := make(chan next)
+	model.actions <- &action{"startNextImagePull", func() error {
+		image, wait := model.startNextImagePull(delay, maxWait)
+		ch <- next{image, wait}
+		return nil
+	}}
+	result := <-ch
+	return result.image, result.wait
+}
+
+// CheckImage ...
+func (model *Model) CheckImage(image *common.Image) *api.CheckImageResponse {
+	ch := make(chan *api.CheckImageResponse)
+	model.actions <- &action{"checkImage", func() error {
 		status, err := model.imageStatus(image)
-		ch <- status
+		response := &api.CheckImageResponse{PullSpec: image.PullSpec, ImageStatus: status}
+		if status == common.ImageStatusQueued {
+			response.QueuePosition = model.queue.position(image.ID())
+		}
+		if result, ok := model.Results[image.ID()]; ok {
+			response.Digest = result.Digest
+			response.Platform = result.Platform
+			response.ManifestDigest = result.ManifestDigest
+			response.Platforms = result.Platforms
+		}
+		ch <- response
 		return err
 	}}
 	return <-ch
 }
 
 // FinishImagePull ...
-func (model *Model) FinishImagePull(image *common.Image, imagePullError error) error {
+func (model *Model) FinishImagePull(image *common.Image, result *PullResult, imagePullError error) error {
 	ch := make(chan error)
 	model.actions <- &action{"finishImagePull", func() error {
-		err := model.finishImagePull(image, imagePullError)
+		err := model.finishImagePull(image, result, imagePullError)
 		ch <- err
 		return err
 	}}
 	return <-ch
 }
 
+// Tarball returns the path of a pulled image's tarball, unless every request
+// for it has released it
+func (model *Model) Tarball(image *common.Image) (string, error) {
+	type tarball struct {
+		path string
+		err  error
+	}
+	ch := make(chan tarball)
+	model.actions <- &action{"tarball", func() error {
+		path, err := model.tarball(image)
+		ch <- tarball{path, err}
+		return nil
+	}}
+	result := <-ch
+	return result.path, result.err
+}
+
+// ReleaseTarball is called by each request for a pulled image once it's done
+// with its tarball.  It returns the tarball's path, and how many requests
+// still hold it.
+func (model *Model) ReleaseTarball(image *common.Image) (string, int, error) {
+	type release struct {
+		path    string
+		holders int
+		err     error
+	}
+	ch := make(chan release)
+	model.actions <- &action{"releaseTarball", func() error {
+		path, holders, err := model.releaseTarball(image)
+		ch <- release{path, holders, err}
+		return err
+	}}
+	result := <-ch
+	return result.path, result.holders, result.err
+}
+
+// ExpireHolders gives up on the requests holding tarballs that were written
+// or last released more than timeout ago, and returns the tarballs' paths so
+// that they can be removed
+func (model *Model) ExpireHolders(timeout time.Duration) []string {
+	ch := make(chan []string)
+	model.actions <- &action{"expireHolders", func() error {
+		ch <- model.expireHolders(time.Now().Add(-timeout))
+		return nil
+	}}
+	return <-ch
+}
+
 // GetAPIModel ...
 func (model *Model) GetAPIModel() map[string]interface{} {
 	ch := make(chan map[string]interface{})
@@ -127,38 +258,183 @@ func (model *Model) GetAPIModel() map[string]interface{} {
 	return <-ch
 }
 
+// Ping checks that the action loop is processing actions
+func (model *Model) Ping(timeout time.Duration) error {
+	ch := make(chan struct{}, 1)
+	select {
+	case model.actions <- &action{"ping", func() error {
+		ch <- struct{}{}
+		return nil
+	}}:
+	case <-time.After(timeout):
+		return fmt.Errorf("action loop did not accept an action within %s", timeout)
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("action loop did not process an action within %s", timeout)
+	}
+}
+
 // private interface
 
-func (model *Model) pullImage(image *common.Image) error {
+func (model *Model) queueImagePull(image *common.Image) error {
+	switch model.Images[image.ID()] {
+	case common.ImageStatusInProgress:
+		log.Infof("coalesced request for image %s with its pull in progress", image.ID())
+		model.hold(image)
+		recordPullRequest("coalesced")
+		return nil
+	case common.ImageStatusQueued:
+		log.Infof("coalesced request for image %s with its queued pull", image.ID())
+		model.queue.raise(image.ID(), image.Priority)
+		model.hold(image)
+		recordPullRequest("coalesced")
+		return nil
+	case common.ImageStatusDone:
+		// pulling it again would overwrite the tarball that's being scanned
+		if model.Holders[image.ID()] > 0 {
+			log.Infof("coalesced request for image %s with its pulled tarball", image.ID())
+			model.hold(image)
+			recordPullRequest("coalesced")
+			return nil
+		}
+	}
+	if model.queue.len() >= model.maxQueueLength {
+		recordPullRequest("rejected")
+		return &QueueFullError{Length: model.queue.len()}
+	}
+
+	log.Infof("queueing pull of image %s with priority %d behind %d others", image.ID(), image.Priority, model.queue.len())
+	model.queue.push(image)
+	model.Images[image.ID()] = common.ImageStatusQueued
+	// a new pull replaces what an earlier one found
+	delete(model.Results, image.ID())
+	model.hold(image)
+	recordPullRequest("queued")
+	recordPullQueueLength(model.queue.len())
+	return nil
+}
+
+func (model *Model) startNextImagePull(delay func(image *common.Image) time.Duration, maxWait time.Duration) (*common.Image, time.Duration) {
 	if model.State != ModelStateReady {
-		return fmt.Errorf("unable to pull image %s, image pull in progress (%s)", image.PullSpec, model.State.String())
+		return nil, 0
+	}
+	var image *common.Image
+	var shortestWait time.Duration
+	for _, pull := range model.queue.list() {
+		wait := delay(pull.image)
+		if wait == 0 {
+			image = pull.image
+			break
+		}
+		if wait > maxWait {
+			log.Errorf("not pulling image %s: its registry is rate limited for %s", pull.image.ID(), wait)
+			model.queue.remove(pull.image.ID())
+			model.Images[pull.image.ID()] = common.ImageStatusError
+			model.unhold(pull.image)
+			recordPullRequest("rate_limited")
+			continue
+		}
+		log.Debugf("skipping image %s: its registry is rate limited for %s", pull.image.ID(), wait)
+		if shortestWait == 0 || wait < shortestWait {
+			shortestWait = wait
+		}
+	}
+	recordPullQueueLength(model.queue.len())
+	if image == nil {
+		return nil, shortestWait
 	}
+	model.queue.remove(image.ID())
 
-	log.Infof("about to start pulling image %s -- model state %s", image.PullSpec, model.State.String())
-	model.Images[image.PullSpec] = common.ImageStatusInProgress
+	log.Infof("about to start pulling image %s -- model state %s", image.ID(), model.State.String())
+	model.Images[image.ID()] = common.ImageStatusInProgress
 	model.State = ModelStatePulling
-	return nil
+	recordPullQueueLength(model.queue.len())
+	return image, 0
 }
 
-func (model *Model) finishImagePull(image *common.Image, imagePullError error) error {
-	if _, ok := model.Images[image.PullSpec]; !ok {
-		return fmt.Errorf("finishImagePull %s with error %t: image not found", image.PullSpec, imagePullError == nil)
+func (model *Model) finishImagePull(image *common.Image, result *PullResult, imagePullError error) error {
+	if _, ok := model.Images[image.ID()]; !ok {
+		return fmt.Errorf("finishImagePull %s with error %t: image not found", image.ID(), imagePullError == nil)
 	}
 	if imagePullError == nil {
-		log.Infof("successfully finished image pull for %s", image.PullSpec)
-		model.Images[image.PullSpec] = common.ImageStatusDone
+		log.Infof("successfully finished image pull for %s, digest %s, platform %s", image.ID(), result.Digest, result.Platform)
+		model.Images[image.ID()] = common.ImageStatusDone
+		model.Results[image.ID()] = result
+		model.heldAt[image.ID()] = time.Now()
 	} else {
-		log.Errorf("finished image pull for %s with error %s", image.PullSpec, imagePullError.Error())
-		model.Images[image.PullSpec] = common.ImageStatusError
+		log.Errorf("finished image pull for %s with error %s", image.ID(), imagePullError.Error())
+		model.Images[image.ID()] = common.ImageStatusError
+		model.unhold(image)
 	}
 	model.State = ModelStateReady
 	return nil
 }
 
+// hold counts another request for an image's tarball
+func (model *Model) hold(image *common.Image) {
+	if model.holdsTarballs {
+		model.Holders[image.ID()]++
+		model.heldAt[image.ID()] = time.Now()
+	}
+}
+
+// unhold forgets every request for an image's tarball
+func (model *Model) unhold(image *common.Image) {
+	delete(model.Holders, image.ID())
+	delete(model.heldAt, image.ID())
+}
+
+func (model *Model) tarball(image *common.Image) (string, error) {
+	status, _ := model.imageStatus(image)
+	if status != common.ImageStatusDone {
+		return "", errors.NotFoundf("pulled image %s (status %s)", image.ID(), status.String())
+	}
+	if model.Holders[image.ID()] == 0 {
+		// every request released it, so it's been removed
+		return "", errors.NotFoundf("tarball of %s", image.ID())
+	}
+	return model.Results[image.ID()].TarballPath, nil
+}
+
+func (model *Model) releaseTarball(image *common.Image) (string, int, error) {
+	path, err := model.tarball(image)
+	if err != nil {
+		return "", 0, err
+	}
+	model.Holders[image.ID()]--
+	holders := model.Holders[image.ID()]
+	if holders == 0 {
+		model.unhold(image)
+	} else {
+		model.heldAt[image.ID()] = time.Now()
+	}
+	return path, holders, nil
+}
+
+func (model *Model) expireHolders(cutoff time.Time) []string {
+	paths := []string{}
+	for id, holders := range model.Holders {
+		// tarballs that are still being pulled aren't held yet
+		if model.Images[id] != common.ImageStatusDone || model.heldAt[id].After(cutoff) {
+			continue
+		}
+		log.Warnf("giving up on %d requests holding the tarball of %s since %s", holders, id, model.heldAt[id])
+		if result := model.Results[id]; result != nil && result.TarballPath != "" {
+			paths = append(paths, result.TarballPath)
+		}
+		delete(model.Holders, id)
+		delete(model.heldAt, id)
+	}
+	return paths
+}
+
 func (model *Model) imageStatus(image *common.Image) (common.ImageStatus, error) {
-	imageStatus, ok := model.Images[image.PullSpec]
+	imageStatus, ok := model.Images[image.ID()]
 	if !ok {
-		return common.ImageStatusUnknown, fmt.Errorf("image %s not found", image.PullSpec)
+		return common.ImageStatusUnknown, fmt.Errorf("image %s not found", image.ID())
 	}
 	return imageStatus, nil
 }
@@ -168,8 +444,28 @@ func (model *Model) getAPIModel() map[string]interface{} {
 	for key, val := range model.Images {
 		images[key] = val.String()
 	}
+	digests := map[string]string{}
+	platforms := map[string]string{}
+	for key, val := range model.Results {
+		digests[key] = val.Digest
+		if val.Platform != "" {
+			platforms[key] = val.Platform
+		}
+	}
+	queue := []map[string]interface{}{}
+	for _, pull := range model.queue.list() {
+		queue = append(queue, map[string]interface{}{
+			"Image":    pull.image.ID(),
+			"Priority": pull.priority,
+			"QueuedAt": pull.queuedAt,
+		})
+	}
 	return map[string]interface{}{
-		"State":  model.State.String(),
-		"Images": images,
+		"State":     model.State.String(),
+		"Images":    images,
+		"Digests":   digests,
+		"Platforms": platforms,
+		"Queue":     queue,
+		"Holders":   model.Holders,
 	}
 }
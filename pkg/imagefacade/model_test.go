@@ -0,0 +1,205 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package imagefacade
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+)
+
+func noRegistryDelay(image *common.Image) time.Duration {
+	return 0
+}
+
+func TestModelPing(t *testing.T) {
+	stop := make(chan struct{})
+	model := NewModel(10, true, stop)
+	if err := model.Ping(time.Second); err != nil {
+		t.Errorf("expected running action loop to answer ping, got %s", err.Error())
+	}
+
+	close(stop)
+	if err := model.Ping(100 * time.Millisecond); err == nil {
+		t.Errorf("expected stopped action loop to fail ping")
+	}
+}
+
+func TestModelTracksPlatformsSeparately(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	model := NewModel(10, true, stop)
+
+	image := common.NewImage("/var/images", "nginx:1.15")
+	arm64 := common.NewImage("/var/images", "nginx:1.15")
+	arm64.Platform = "linux/arm64"
+	if err := model.QueueImagePull(arm64); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	model.StartNextImagePull(noRegistryDelay, 0)
+	result := &PullResult{Digest: "sha256:list", Platform: "linux/arm64/v8", ManifestDigest: "sha256:arm64", Platforms: []string{"linux/amd64", "linux/arm64/v8"}}
+	if err := model.FinishImagePull(arm64, result, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	response := model.CheckImage(arm64)
+	if response.ImageStatus != common.ImageStatusDone || response.Digest != "sha256:list" || response.Platform != "linux/arm64/v8" || response.ManifestDigest != "sha256:arm64" || len(response.Platforms) != 2 {
+		t.Errorf("unexpected response %+v", response)
+	}
+	if response = model.CheckImage(image); response.ImageStatus != common.ImageStatusUnknown {
+		t.Errorf("expected the default platform's pull to be unknown, got %s", response.ImageStatus.String())
+	}
+}
+
+func TestModelQueuesPulls(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	model := NewModel(3, true, stop)
+
+	image := func(pullSpec string, priority int) *common.Image {
+		image := common.NewImage("/var/images", pullSpec)
+		image.Priority = priority
+		return image
+	}
+	for _, queued := range []*common.Image{image("nginx", 0), image("redis", 0), image("alpine", 5)} {
+		if err := model.QueueImagePull(queued); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	// coalesced with the queued pull, which goes up to its priority
+	if err := model.QueueImagePull(image("redis", 7)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := model.QueueImagePull(image("busybox", 9)); err == nil {
+		t.Errorf("expected queue full error")
+	} else if _, ok := err.(*QueueFullError); !ok {
+		t.Errorf("expected queue full error, got %s", err.Error())
+	}
+
+	response := model.CheckImage(image("nginx", 0))
+	if response.ImageStatus != common.ImageStatusQueued || response.QueuePosition != 3 {
+		t.Errorf("expected nginx to be queued third, got %s at %d", response.ImageStatus.String(), response.QueuePosition)
+	}
+	queue := model.GetAPIModel()["Queue"].([]map[string]interface{})
+	if len(queue) != 3 || queue[0]["Image"] != "redis" || queue[1]["Image"] != "alpine" || queue[2]["Image"] != "nginx" {
+		t.Errorf("unexpected queue %v", queue)
+	}
+
+	next, _ := model.StartNextImagePull(noRegistryDelay, 0)
+	if next == nil || next.PullSpec != "redis" {
+		t.Fatalf("expected redis to be pulled first, got %v", next)
+	}
+	if second, _ := model.StartNextImagePull(noRegistryDelay, 0); second != nil {
+		t.Errorf("expected one pull at a time")
+	}
+	// coalesced with the pull in progress
+	if err := model.QueueImagePull(image("redis", 0)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if response = model.CheckImage(next); response.ImageStatus != common.ImageStatusInProgress || response.QueuePosition != 0 {
+		t.Errorf("expected redis to be in progress, got %s at %d", response.ImageStatus.String(), response.QueuePosition)
+	}
+	if err := model.FinishImagePull(next, &PullResult{Digest: "sha256:redis"}, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if next, _ = model.StartNextImagePull(noRegistryDelay, 0); next == nil || next.PullSpec != "alpine" {
+		t.Errorf("expected alpine to be pulled next, got %v", next)
+	}
+	if response = model.CheckImage(image("nginx", 0)); response.QueuePosition != 1 {
+		t.Errorf("expected nginx to move up the queue, got %d", response.QueuePosition)
+	}
+}
+
+func TestModelSkipsRateLimitedRegistries(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	model := NewModel(10, true, stop)
+
+	for _, pullSpec := range []string{"quay.io/coreos/etcd", "registry.example.com/app", "nginx"} {
+		if err := model.QueueImagePull(common.NewImage("/var/images", pullSpec)); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	delays := map[string]time.Duration{"quay.io": time.Hour, "registry.example.com": time.Minute}
+	delay := func(image *common.Image) time.Duration {
+		return delays[common.RegistryHost(image.PullSpec)]
+	}
+
+	// etcd's registry is held off for longer than the maximum wait, so it
+	// fails; app waits, and nginx goes ahead of it
+	next, _ := model.StartNextImagePull(delay, 2*time.Minute)
+	if next == nil || next.PullSpec != "nginx" {
+		t.Fatalf("expected nginx to be pulled first, got %v", next)
+	}
+	if status := model.CheckImage(common.NewImage("/var/images", "quay.io/coreos/etcd")).ImageStatus; status != common.ImageStatusError {
+		t.Errorf("expected etcd to fail, got %s", status.String())
+	}
+	if err := model.FinishImagePull(next, &PullResult{Digest: "sha256:nginx"}, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	next, wait := model.StartNextImagePull(delay, 2*time.Minute)
+	if next != nil || wait != time.Minute {
+		t.Errorf("expected to wait a minute for app, got %v and %s", next, wait)
+	}
+	if response := model.CheckImage(common.NewImage("/var/images", "registry.example.com/app")); response.ImageStatus != common.ImageStatusQueued || response.QueuePosition != 1 {
+		t.Errorf("expected app to still be queued, got %s at %d", response.ImageStatus.String(), response.QueuePosition)
+	}
+	delete(delays, "registry.example.com")
+	if next, _ = model.StartNextImagePull(delay, 2*time.Minute); next == nil || next.PullSpec != "registry.example.com/app" {
+		t.Errorf("expected app to be pulled once its registry allows it, got %v", next)
+	}
+}
+
+func TestModelExpiresHolders(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	model := NewModel(10, true, stop)
+
+	image := common.NewImage("/var/images", "nginx:1.15")
+	queued := common.NewImage("/var/images", "redis")
+	for _, i := range []*common.Image{image, queued} {
+		if err := model.QueueImagePull(i); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	model.StartNextImagePull(noRegistryDelay, 0)
+	if err := model.FinishImagePull(image, &PullResult{Digest: "sha256:abc", TarballPath: image.DockerTarFilePath()}, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if paths := model.ExpireHolders(time.Hour); len(paths) != 0 {
+		t.Errorf("expected no tarballs to expire yet, got %v", paths)
+	}
+	// the queued image hasn't been pulled, so there's no tarball to expire
+	paths := model.ExpireHolders(0)
+	if len(paths) != 1 || paths[0] != image.DockerTarFilePath() {
+		t.Errorf("expected %s to expire, got %v", image.DockerTarFilePath(), paths)
+	}
+	if _, _, err := model.ReleaseTarball(image); err == nil {
+		t.Errorf("expected an expired tarball not to be found")
+	}
+	if status := model.CheckImage(queued).ImageStatus; status != common.ImageStatusQueued {
+		t.Errorf("expected the queued image to be left alone, got %s", status.String())
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package imagefacade
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+
+	common "github.com/blackducksoftware/perceptor-scanner/pkg/common"
+)
+
+// pullQueue holds image pulls that have been requested but not started yet.
+// Higher priority pulls come out first; pulls with the same priority come out
+// in the order they were requested.
+// It is not safe for concurrent use.
+type pullQueue struct {
+	pulls    queuedPulls
+	received int
+}
+
+type queuedPull struct {
+	image    *common.Image
+	priority int
+	// order is when the pull was requested, relative to the other pulls
+	order    int
+	queuedAt time.Time
+}
+
+// queuedPulls implements heap.Interface
+type queuedPulls []*queuedPull
+
+func (qp queuedPulls) Len() int { return len(qp) }
+
+func (qp queuedPulls) Less(i, j int) bool {
+	if qp[i].priority != qp[j].priority {
+		return qp[i].priority > qp[j].priority
+	}
+	return qp[i].order < qp[j].order
+}
+
+func (qp queuedPulls) Swap(i, j int) { qp[i], qp[j] = qp[j], qp[i] }
+
+func (qp *queuedPulls) Push(x interface{}) { *qp = append(*qp, x.(*queuedPull)) }
+
+func (qp *queuedPulls) Pop() interface{} {
+	old := *qp
+	pull := old[len(old)-1]
+	*qp = old[:len(old)-1]
+	return pull
+}
+
+func newPullQueue() *pullQueue {
+	return &pullQueue{pulls: queuedPulls{}}
+}
+
+func (q *pullQueue) push(image *common.Image) {
+	q.received++
+	heap.Push(&q.pulls, &queuedPull{image: image, priority: image.Priority, order: q.received, queuedAt: time.Now()})
+}
+
+// remove takes a pull out of the queue, wherever it is
+func (q *pullQueue) remove(id string) {
+	for i, pull := range q.pulls {
+		if pull.image.ID() == id {
+			heap.Remove(&q.pulls, i)
+			return
+		}
+	}
+}
+
+// raise moves a queued pull up to priority, if that's higher than its own.
+// Its order is kept, so that it still goes ahead of pulls with the same
+// priority that were requested later.
+func (q *pullQueue) raise(id string, priority int) {
+	for i, pull := range q.pulls {
+		if pull.image.ID() == id && pull.priority < priority {
+			pull.priority = priority
+			heap.Fix(&q.pulls, i)
+			return
+		}
+	}
+}
+
+func (q *pullQueue) len() int {
+	return len(q.pulls)
+}
+
+// list returns the queued pulls in the order they'll start
+func (q *pullQueue) list() []*queuedPull {
+	pulls := make(queuedPulls, len(q.pulls))
+	copy(pulls, q.pulls)
+	sort.Sort(pulls)
+	return pulls
+}
+
+// position returns where a pull is in the queue, starting from 1, or 0 if it
+// isn't queued
+func (q *pullQueue) position(id string) int {
+	for i, pull := range q.list() {
+		if pull.image.ID() == id {
+			return i + 1
+		}
+	}
+	return 0
+}
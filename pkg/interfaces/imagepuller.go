@@ -26,4 +26,12 @@ type ImagePuller interface {
 	PullImage(image Image) error
 	CreateImageInLocalDocker(image Image) error
 	SaveImageToTar(image Image) error
+	// ResolveDigest returns the digest, such as sha256:abc..., of the image
+	// that a pull of image's pull spec gets
+	ResolveDigest(image Image) (string, error)
+	// RemoveCreatedImage removes the image from the docker daemon, if it wasn't
+	// there until the puller created it
+	RemoveCreatedImage(image Image) error
+	// Ping checks that the puller's backend can be used
+	Ping() error
 }
@@ -25,6 +25,7 @@ import (
 	"io"
 	"os"
 
+	api "github.com/blackducksoftware/perceptor-scanner/pkg/api"
 	common "github.com/blackducksoftware/perceptor-scanner/pkg/common"
 	imagefacade "github.com/blackducksoftware/perceptor-scanner/pkg/imagefacade"
 	log "github.com/sirupsen/logrus"
@@ -61,24 +62,58 @@ func copyFile(source string, destination string) error {
 }
 
 // PullImage ...
-func (mif *MockImagefacade) PullImage(image *common.Image) error {
+func (mif *MockImagefacade) PullImage(image *common.Image) (*api.CheckImageResponse, error) {
 	log.Infof("received pullImage: %+v", image)
-	return nil
+	return &api.CheckImageResponse{PullSpec: image.PullSpec, ImageStatus: common.ImageStatusInProgress}, nil
 }
 
 // GetImage ...
-func (mif *MockImagefacade) GetImage(image *common.Image) common.ImageStatus {
+func (mif *MockImagefacade) GetImage(image *common.Image) *api.CheckImageResponse {
 	log.Infof("received getImage: %+v", image)
+	response := &api.CheckImageResponse{PullSpec: image.PullSpec, ImageStatus: common.ImageStatusDone}
 	sourcePath := "/tmp/alpine.tar"
 	err := copyFile(sourcePath, image.DockerTarFilePath())
 	if err != nil {
 		log.Errorf("unable to copy file from %s to %s: %s", sourcePath, image.DockerTarFilePath(), err.Error())
-		return common.ImageStatusError
+		response.ImageStatus = common.ImageStatusError
 	}
-	return common.ImageStatusDone
+	return response
+}
+
+// InspectImage ...
+func (mif *MockImagefacade) InspectImage(image *common.Image) (*api.InspectImageResponse, error) {
+	log.Infof("received inspectImage: %+v", image)
+	response, err := imagefacade.InspectTarball(image.DockerTarFilePath())
+	if err != nil {
+		return nil, err
+	}
+	response.PullSpec = image.PullSpec
+	return response, nil
+}
+
+// TarballPath ...
+func (mif *MockImagefacade) TarballPath(image *common.Image) (string, error) {
+	log.Infof("received tarballPath: %+v", image)
+	return "/tmp/alpine.tar", nil
+}
+
+// RemoveTarball ...
+func (mif *MockImagefacade) RemoveTarball(image *common.Image) error {
+	log.Infof("received removeTarball: %+v", image)
+	return nil
 }
 
 // GetModel ...
 func (mif *MockImagefacade) GetModel() map[string]interface{} {
 	return map[string]interface{}{"todo": "unimplemented"}
 }
+
+// CheckLiveness ...
+func (mif *MockImagefacade) CheckLiveness() map[string]error {
+	return map[string]error{}
+}
+
+// CheckReadiness ...
+func (mif *MockImagefacade) CheckReadiness() map[string]error {
+	return map[string]error{}
+}
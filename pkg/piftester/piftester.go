@@ -56,7 +56,7 @@ func NewPifTester(imageFacadeHost string, imageFacadePort int, stop <-chan struc
 		ImageMap:          map[m.Image]bool{},
 		ImageErrors:       map[m.Image][]string{},
 		ImageQueue:        []m.Image{},
-		imageFacadeClient: scanner.NewImageFacadeClient(imageFacadeHost, imageFacadePort),
+		imageFacadeClient: scanner.NewImageFacadeClient(imageFacadeHost, imageFacadePort, stop),
 		actions:           make(chan *action),
 		stop:              stop,
 	}
@@ -147,7 +147,7 @@ func (pif *PifTester) startPullingImages() {
 	for {
 		image := pif.getNextImage()
 		if image != nil {
-			err := pif.imageFacadeClient.PullImage(&common.Image{PullSpec: image.PullSpec()})
+			_, err := pif.imageFacadeClient.PullImage(&common.Image{PullSpec: image.PullSpec()})
 			pif.finishImage(*image, err)
 		}
 		select {
@@ -0,0 +1,53 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import "time"
+
+// backoff computes exponentially increasing pauses between retries
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func newBackoff(initial time.Duration, max time.Duration) *backoff {
+	return &backoff{initial: initial, max: max}
+}
+
+// next returns the pause before the next retry, and doubles it for the one after
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.initial
+	} else {
+		b.current *= 2
+	}
+	if b.current > b.max {
+		b.current = b.max
+	}
+	return b.current
+}
+
+// reset goes back to the initial pause, after a successful attempt
+func (b *backoff) reset() {
+	b.current = 0
+}
@@ -0,0 +1,100 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// loadBlackDuckConnections reads the Black Duck connections configured locally, as
+// a JSON map of Host objects, from the environment variable named in the config
+// and/or from a file.  Entries from the file win.  The result is keyed by domain.
+func loadBlackDuckConnections(config *BlackDuckConfig) (map[string]*Host, error) {
+	connections := map[string]*Host{}
+	if config.ConnectionsEnvironmentVariableName != "" {
+		value, ok := os.LookupEnv(config.ConnectionsEnvironmentVariableName)
+		if ok {
+			err := addBlackDuckConnections(connections, []byte(value))
+			if err != nil {
+				return nil, errors.Annotatef(err, "unable to read Black Duck connections from environment variable %s", config.ConnectionsEnvironmentVariableName)
+			}
+		} else {
+			log.Warnf("environment variable %s for Black Duck connections not found", config.ConnectionsEnvironmentVariableName)
+		}
+	}
+	if config.ConnectionsFilePath != "" {
+		bytes, err := ioutil.ReadFile(config.ConnectionsFilePath)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to read Black Duck connections file %s", config.ConnectionsFilePath)
+		}
+		err = addBlackDuckConnections(connections, bytes)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to read Black Duck connections from file %s", config.ConnectionsFilePath)
+		}
+	}
+	return connections, nil
+}
+
+func addBlackDuckConnections(connections map[string]*Host, jsonBytes []byte) error {
+	hosts := map[string]*Host{}
+	err := json.Unmarshal(jsonBytes, &hosts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for key, host := range hosts {
+		if host == nil {
+			continue
+		}
+		// the domain is optional, if it's the same as the key
+		if host.Domain == "" {
+			host.Domain = key
+		}
+		common.RegisterSecret(host.Password)
+		common.RegisterSecret(host.Token)
+		connections[host.Domain] = host
+	}
+	return nil
+}
+
+// withLocalCredentials returns the connection to use for a job's Black Duck host:
+// if it's configured locally, the local credentials replace anything sent by
+// perceptor, and the local scheme and port are used unless the job has its own.
+func withLocalCredentials(host *Host, connections map[string]*Host) *Host {
+	local, ok := connections[host.Domain]
+	if !ok {
+		return host
+	}
+	merged := *local
+	if host.Scheme != "" {
+		merged.Scheme = host.Scheme
+	}
+	if host.Port != 0 {
+		merged.Port = host.Port
+	}
+	return &merged
+}
@@ -0,0 +1,62 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLoadBlackDuckConnections(t *testing.T) {
+	os.Setenv("TEST_BLACKDUCK_CONNECTIONS", `{"hub.one": {"scheme": "https", "port": 443, "user": "sysadmin", "password": "from-env"}}`)
+	defer os.Unsetenv("TEST_BLACKDUCK_CONNECTIONS")
+	file, err := ioutil.TempFile("", "blackduckconnections")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"two": {"scheme": "https", "domain": "hub.two", "port": 8443, "token": "from-file"}}`)
+	file.Close()
+
+	connections, err := loadBlackDuckConnections(&BlackDuckConfig{
+		ConnectionsEnvironmentVariableName: "TEST_BLACKDUCK_CONNECTIONS",
+		ConnectionsFilePath:                file.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connections) != 2 || connections["hub.one"].Password != "from-env" || connections["hub.two"].Token != "from-file" {
+		t.Errorf("unexpected connections %+v", connections)
+	}
+
+	// jobs naming a known host get the local credentials
+	host := withLocalCredentials(&Host{Domain: "hub.two", Port: 443, User: "perceptor", Password: "from-perceptor"}, connections)
+	expected := Host{Scheme: "https", Domain: "hub.two", Port: 443, Token: "from-file"}
+	if *host != expected {
+		t.Errorf("expected %+v, got %+v", expected, *host)
+	}
+	// others are left alone
+	unknown := &Host{Domain: "hub.three", User: "perceptor", Password: "from-perceptor"}
+	if withLocalCredentials(unknown, connections) != unknown {
+		t.Errorf("expected unknown host to be left alone")
+	}
+}
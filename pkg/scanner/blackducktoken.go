@@ -0,0 +1,130 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const tokenAuthenticationPath = "api/tokens/authenticate"
+
+// bearerTokenRefreshMargin is how long before it expires a bearer token is
+// replaced, so that a token isn't handed out just as Black Duck stops taking it
+const bearerTokenRefreshMargin = 5 * time.Minute
+
+// blackDuckBearerTokens are the bearer tokens exchanged for API tokens so far
+var blackDuckBearerTokens = newBearerTokenCache()
+
+type bearerTokenResponse struct {
+	BearerToken           string `json:"bearerToken"`
+	ExpiresInMilliseconds int64  `json:"expiresInMilliseconds"`
+}
+
+type bearerToken struct {
+	token string
+	// refreshAt is when the token has to be replaced: a little before it expires
+	refreshAt time.Time
+}
+
+// bearerTokenCache keeps the bearer token for each Black Duck host and API
+// token until it's about to expire
+type bearerTokenCache struct {
+	mutex  sync.Mutex
+	tokens map[string]*bearerToken
+	now    func() time.Time
+}
+
+func newBearerTokenCache() *bearerTokenCache {
+	return &bearerTokenCache{tokens: map[string]*bearerToken{}, now: time.Now}
+}
+
+// get returns the cached bearer token for baseURL and apiToken, or exchanges
+// apiToken for a new one if there isn't one or it's about to expire
+func (cache *bearerTokenCache) get(baseURL string, apiToken string, tlsVerification bool, timeout time.Duration) (string, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	key := fmt.Sprintf("%s %s", baseURL, apiToken)
+	now := cache.now()
+	if cached, ok := cache.tokens[key]; ok && now.Before(cached.refreshAt) {
+		return cached.token, nil
+	}
+	delete(cache.tokens, key)
+	token, expiresIn, err := authenticateWithAPIToken(baseURL, apiToken, tlsVerification, timeout)
+	if err != nil {
+		return "", err
+	}
+	margin := bearerTokenRefreshMargin
+	if expiresIn < 2*margin {
+		margin = expiresIn / 2
+	}
+	if expiresIn > 0 {
+		cache.tokens[key] = &bearerToken{token: token, refreshAt: now.Add(expiresIn - margin)}
+	}
+	return token, nil
+}
+
+// authenticateWithAPIToken exchanges a Black Duck API token for a bearer token,
+// which can then be used to create a session until it expires
+func authenticateWithAPIToken(baseURL string, apiToken string, tlsVerification bool, timeout time.Duration) (string, time.Duration, error) {
+	httpClient := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: !tlsVerification}},
+		Timeout:   timeout,
+	}
+	url := fmt.Sprintf("%s/%s", baseURL, tokenAuthenticationPath)
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return "", 0, errors.Annotatef(err, "unable to create request to %s", url)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("token %s", apiToken))
+	req.Header.Set("Accept", "application/vnd.blackducksoftware.user-4+json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", 0, errors.Annotatef(err, "unable to authenticate to %s", url)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, errors.Annotatef(err, "unable to read response body from %s", url)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, errors.Errorf("token authentication to %s failed with status code %d", url, resp.StatusCode)
+	}
+
+	var tokenResponse bearerTokenResponse
+	err = json.Unmarshal(body, &tokenResponse)
+	if err != nil {
+		return "", 0, errors.Annotatef(err, "unable to unmarshal token authentication response from %s", url)
+	}
+	if tokenResponse.BearerToken == "" {
+		return "", 0, errors.Errorf("token authentication response from %s did not include a bearer token", url)
+	}
+	return tokenResponse.BearerToken, time.Duration(tokenResponse.ExpiresInMilliseconds) * time.Millisecond, nil
+}
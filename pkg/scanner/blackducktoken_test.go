@@ -0,0 +1,93 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthenticateWithAPIToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/tokens/authenticate" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "token my-api-token" {
+			http.Error(w, "unauthorized", 401)
+			return
+		}
+		fmt.Fprint(w, `{"bearerToken": "my-bearer-token", "expiresInMilliseconds": 7199999}`)
+	}))
+	defer server.Close()
+
+	bearerToken, expiresIn, err := authenticateWithAPIToken(server.URL, "my-api-token", true, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bearerToken != "my-bearer-token" {
+		t.Errorf("expected my-bearer-token, got %s", bearerToken)
+	}
+	if expiresIn != 7199999*time.Millisecond {
+		t.Errorf("expected the token to expire in 7199999ms, got %s", expiresIn)
+	}
+
+	_, _, err = authenticateWithAPIToken(server.URL, "wrong-token", true, 5*time.Second)
+	if err == nil {
+		t.Errorf("expected error for rejected token")
+	}
+}
+
+func TestBearerTokenCacheRefreshesBeforeExpiry(t *testing.T) {
+	exchanges := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exchanges++
+		fmt.Fprintf(w, `{"bearerToken": "bearer-token-%d", "expiresInMilliseconds": 3600000}`, exchanges)
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	now := start
+	cache := newBearerTokenCache()
+	cache.now = func() time.Time { return now }
+	get := func(expected string) {
+		bearerToken, err := cache.get(server.URL, "my-api-token", true, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bearerToken != expected {
+			t.Errorf("at %s, expected %s, got %s", now.Sub(start), expected, bearerToken)
+		}
+	}
+
+	get("bearer-token-1")
+	now = start.Add(time.Hour - bearerTokenRefreshMargin - time.Second)
+	get("bearer-token-1")
+	now = start.Add(time.Hour - bearerTokenRefreshMargin)
+	get("bearer-token-2")
+	if exchanges != 2 {
+		t.Errorf("expected 2 token exchanges, got %d", exchanges)
+	}
+}
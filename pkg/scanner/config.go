@@ -22,8 +22,11 @@ under the License.
 package scanner
 
 import (
+	"encoding/json"
+	"fmt"
 	"strings"
 
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
 	"github.com/juju/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -31,8 +34,15 @@ import (
 
 // BlackDuckConfig stores the Black Duck configuration
 type BlackDuckConfig struct {
+	// Black Duck connections, including credentials, are read from the
+	// environment variable and/or the file; jobs from perceptor then only
+	// need to name the host
 	ConnectionsEnvironmentVariableName string
+	ConnectionsFilePath                string
 	TLSVerification                    bool
+	// Token is a Black Duck API token.  If set, it's used instead of the
+	// username and password from perceptor.
+	Token string
 }
 
 // ImageFacadeConfig stores the image facade configuration
@@ -60,6 +70,62 @@ type ScannerConfig struct {
 	ImageDirectory       string
 	Port                 int
 	ClientTimeoutSeconds int
+
+	// DryRunDirectory turns on offline scanning: the scan client writes its
+	// results there instead of uploading them, and they're uploaded to
+	// Black Duck in the background once it's reachable.  It needs
+	// ScanClientDirectory, since Black Duck may not be there to download the
+	// scan client from.
+	DryRunDirectory    string
+	DryRunMaxMegabytes int
+	DryRunMaxPending   int
+
+	// ScanClientDirectory holds a scan client that's already unpacked, in a
+	// scan.cli-<version> directory, which is used instead of downloading one
+	// from Black Duck
+	ScanClientDirectory string
+
+	// the scan client's output for each job is kept in its own file
+	JobLogDirectory    string
+	JobLogMaxMegabytes int
+	JobLogMaxJobs      int
+
+	// PrefetchCount is how many jobs to request from perceptor ahead of time,
+	// so that the highest priority one among them can be run next
+	PrefetchCount int
+
+	// the HTTP API shows this many of the most recently completed jobs
+	JobHistorySize int
+
+	// while a job runs, perceptor is sent a heartbeat this often; if it
+	// misses three in a row, it can reassign the job
+	HeartbeatSeconds int
+
+	// finished scan reports are kept here until perceptor has received them;
+	// it should be on a volume that survives restarts of the container
+	OutboxDirectory string
+
+	// on SIGTERM, the current job has this long to finish before it's
+	// interrupted and handed back to perceptor.  The default leaves time to
+	// shut down within kubernetes' default terminationGracePeriodSeconds of
+	// 30; raise that by as much as this is raised.
+	DrainSeconds int
+
+	// Platform, such as linux/arm64, is scanned from multi-arch images; if
+	// it's empty, the image facade's default platform is
+	Platform string
+	// ScanAllPlatforms also scans every other platform of a multi-arch image,
+	// each under its own scan name
+	ScanAllPlatforms bool
+
+	// ScanNameMetadata appends the image's platform and ID, as read from its
+	// config, to the scan name.  Perceptor finds scans by the names it gave
+	// them, so this is only for scans that are looked up in Black Duck.
+	ScanNameMetadata bool
+
+	// DownloadTarballs copies each pulled image from the image facade into
+	// ImageDirectory, so that the two don't need to share a volume
+	DownloadTarballs bool
 }
 
 // Config stores the input scanner configurqtion
@@ -80,11 +146,119 @@ func (config *ScannerConfig) GetImageDirectory() string {
 	return config.ImageDirectory
 }
 
+// GetDryRunMaxMegabytes return the disk cap for scans waiting to be uploaded
+func (config *ScannerConfig) GetDryRunMaxMegabytes() int {
+	if config.DryRunMaxMegabytes == 0 {
+		return 10240
+	}
+	return config.DryRunMaxMegabytes
+}
+
+// GetDryRunMaxPending return the maximum number of scans waiting to be uploaded
+func (config *ScannerConfig) GetDryRunMaxPending() int {
+	if config.DryRunMaxPending == 0 {
+		return 1000
+	}
+	return config.DryRunMaxPending
+}
+
+// GetJobLogDirectory return the directory to keep the scan client's output for each job in
+func (config *ScannerConfig) GetJobLogDirectory() string {
+	if config.JobLogDirectory == "" {
+		return "/tmp/scanner/joblogs"
+	}
+	return config.JobLogDirectory
+}
+
+// GetJobLogMaxMegabytes return the size at which a job's log is rotated
+func (config *ScannerConfig) GetJobLogMaxMegabytes() int {
+	if config.JobLogMaxMegabytes == 0 {
+		return 10
+	}
+	return config.JobLogMaxMegabytes
+}
+
+// GetJobLogMaxJobs return the number of jobs to keep logs for
+func (config *ScannerConfig) GetJobLogMaxJobs() int {
+	if config.JobLogMaxJobs == 0 {
+		return 100
+	}
+	return config.JobLogMaxJobs
+}
+
+// GetJobHistorySize return the number of completed jobs to keep track of
+func (config *ScannerConfig) GetJobHistorySize() int {
+	if config.JobHistorySize == 0 {
+		return 20
+	}
+	return config.JobHistorySize
+}
+
+// GetHeartbeatSeconds return how often to tell perceptor that a job is still running
+func (config *ScannerConfig) GetHeartbeatSeconds() int {
+	if config.HeartbeatSeconds == 0 {
+		return 30
+	}
+	return config.HeartbeatSeconds
+}
+
+// GetOutboxDirectory return the directory to keep undelivered finished scan reports in
+func (config *ScannerConfig) GetOutboxDirectory() string {
+	if config.OutboxDirectory == "" {
+		return "/tmp/scanner/outbox"
+	}
+	return config.OutboxDirectory
+}
+
+// GetDrainSeconds return how long to wait for the current job when shutting down
+func (config *ScannerConfig) GetDrainSeconds() int {
+	if config.DrainSeconds == 0 {
+		return 10
+	}
+	return config.DrainSeconds
+}
+
 // GetLogLevel return the log level
 func (config *Config) GetLogLevel() (log.Level, error) {
 	return log.ParseLevel(config.LogLevel)
 }
 
+// redacted returns a copy of the config that's safe to show, with secrets replaced
+func (config *Config) redacted() *Config {
+	copied := *config
+	if config.BlackDuck != nil {
+		blackDuck := *config.BlackDuck
+		blackDuck.Token = common.RedactIfSet(blackDuck.Token)
+		copied.BlackDuck = &blackDuck
+	}
+	return &copied
+}
+
+// String shows the config with its secrets redacted
+func (config *Config) String() string {
+	bytes, err := json.Marshal(config.redacted())
+	if err != nil {
+		return fmt.Sprintf("unable to show config: %s", err.Error())
+	}
+	return string(bytes)
+}
+
+// Format keeps the secrets out of every fmt verb, including %#v
+func (config *Config) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, config.String())
+}
+
+// String shows the Black Duck config with the token redacted
+func (config BlackDuckConfig) String() string {
+	return fmt.Sprintf("{ConnectionsEnvironmentVariableName:%s ConnectionsFilePath:%s TLSVerification:%t Token:%s}",
+		config.ConnectionsEnvironmentVariableName, config.ConnectionsFilePath, config.TLSVerification, common.RedactIfSet(config.Token))
+}
+
+// Format keeps the token out of every fmt verb, including %#v
+func (config BlackDuckConfig) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, config.String())
+}
+
 // GetConfig returns the input configuration for Scanner pod
 func GetConfig(configPath string) (*Config, error) {
 	var config *Config
@@ -106,11 +280,29 @@ func GetConfig(configPath string) (*Config, error) {
 		viper.BindEnv("Perceptor.Port")
 
 		viper.BindEnv("BlackDuck.ConnectionsEnvironmentVariableName")
+		viper.BindEnv("BlackDuck.ConnectionsFilePath")
 		viper.BindEnv("BlackDuck.TLSVerification")
+		viper.BindEnv("BlackDuck.Token")
 
 		viper.BindEnv("Scanner.Port")
 		viper.BindEnv("Scanner.ImageDirectory")
 		viper.BindEnv("Scanner.HubClientTimeoutSeconds")
+		viper.BindEnv("Scanner.DryRunDirectory")
+		viper.BindEnv("Scanner.DryRunMaxMegabytes")
+		viper.BindEnv("Scanner.DryRunMaxPending")
+		viper.BindEnv("Scanner.ScanClientDirectory")
+		viper.BindEnv("Scanner.JobLogDirectory")
+		viper.BindEnv("Scanner.JobLogMaxMegabytes")
+		viper.BindEnv("Scanner.JobLogMaxJobs")
+		viper.BindEnv("Scanner.PrefetchCount")
+		viper.BindEnv("Scanner.JobHistorySize")
+		viper.BindEnv("Scanner.HeartbeatSeconds")
+		viper.BindEnv("Scanner.OutboxDirectory")
+		viper.BindEnv("Scanner.DrainSeconds")
+		viper.BindEnv("Scanner.Platform")
+		viper.BindEnv("Scanner.ScanAllPlatforms")
+		viper.BindEnv("Scanner.ScanNameMetadata")
+		viper.BindEnv("Scanner.DownloadTarballs")
 
 		viper.BindEnv("LogLevel")
 
@@ -121,6 +313,9 @@ func GetConfig(configPath string) (*Config, error) {
 	if err != nil {
 		return nil, errors.Annotatef(err, "failed to unmarshal config")
 	}
+	if config.BlackDuck != nil {
+		common.RegisterSecret(config.BlackDuck.Token)
+	}
 
 	return config, nil
 }
@@ -0,0 +1,53 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"testing"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+)
+
+func TestRedactedConfig(t *testing.T) {
+	config := &Config{
+		BlackDuck: &BlackDuckConfig{Token: "secret", ConnectionsFilePath: "/etc/blackduck.json"},
+		Scanner:   &ScannerConfig{Port: 3003}}
+	redacted := config.redacted()
+	if redacted.BlackDuck.Token != common.RedactedValue {
+		t.Errorf("expected token to be redacted, got %s", redacted.BlackDuck.Token)
+	}
+	if redacted.BlackDuck.ConnectionsFilePath != "/etc/blackduck.json" || redacted.Scanner.Port != 3003 {
+		t.Errorf("expected other values to be kept, got %+v", redacted)
+	}
+	if config.BlackDuck.Token != "secret" {
+		t.Errorf("expected original config to be unchanged, got %s", config.BlackDuck.Token)
+	}
+}
+
+func TestDefaultShutdownFitsGracePeriod(t *testing.T) {
+	if shutdown := maxShutdownDuration(&ScannerConfig{}); shutdown > terminationGracePeriod {
+		t.Errorf("expected the default shutdown to fit in %s, it can take %s", terminationGracePeriod, shutdown)
+	}
+	if shutdown := maxShutdownDuration(&ScannerConfig{DrainSeconds: 25}); shutdown <= terminationGracePeriod {
+		t.Errorf("expected a longer drain not to fit in %s, it can take %s", terminationGracePeriod, shutdown)
+	}
+}
@@ -25,12 +25,29 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
 
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
-// RunScanner ...
+const (
+	httpShutdownTimeout = 3 * time.Second
+	// terminationGracePeriod is kubernetes' default terminationGracePeriodSeconds:
+	// draining, interrupting the current job, delivering finished scan reports
+	// and shutting down the HTTP server have to fit in it by default
+	terminationGracePeriod = 30 * time.Second
+)
+
+// maxShutdownDuration is how long shutting down can take, at most
+func maxShutdownDuration(config *ScannerConfig) time.Duration {
+	return time.Duration(config.GetDrainSeconds())*time.Second + interruptedJobTimeout + outboxFlushTimeout + httpShutdownTimeout
+}
+
+// RunScanner runs until stop is closed or the process is terminated, then
+// drains the current scan job and shuts down
 func RunScanner(configPath string, stop <-chan struct{}) {
 	config, err := GetConfig(configPath)
 	if err != nil {
@@ -43,22 +60,32 @@ func RunScanner(configPath string, stop <-chan struct{}) {
 	}
 	log.SetLevel(level)
 
+	if shutdown := maxShutdownDuration(config.Scanner); shutdown > terminationGracePeriod {
+		log.Warnf("shutting down can take up to %s: terminationGracePeriodSeconds must be at least that, rather than the default %s", shutdown, terminationGracePeriod)
+	}
+
 	prometheus.Unregister(prometheus.NewProcessCollector(os.Getpid(), ""))
 	prometheus.Unregister(prometheus.NewGoCollector())
 
-	manager, err := NewManager(config, stop)
+	manager, err := NewManager(config)
 	if err != nil {
 		panic(err)
 	}
 	manager.StartRequestingScanJobs()
 
-	http.Handle("/metrics", prometheus.Handler())
+	SetupHTTPServer(manager)
 
 	addr := fmt.Sprintf(":%d", config.Scanner.Port)
-	log.Infof("successfully instantiated manager %+v, serving on %s", manager, addr)
+	log.Infof("successfully instantiated manager, serving on %s", addr)
+	server := &http.Server{Addr: addr}
 	go func() {
-		http.ListenAndServe(addr, nil)
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			log.Errorf("HTTP server on %s failed: %s", addr, err.Error())
+		}
 	}()
 
-	<-stop
+	common.WaitForShutdown(stop)
+	manager.Shutdown(time.Duration(config.Scanner.GetDrainSeconds()) * time.Second)
+	common.ShutdownHTTPServer(server, httpShutdownTimeout)
 }
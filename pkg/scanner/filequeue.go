@@ -0,0 +1,149 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const fileQueueSuffix = ".json"
+
+// fileQueue is a FIFO queue persisted as one JSON file per entry, so that
+// entries survive restarts of the scanner.  Entries may contain credentials,
+// so they're only readable by the owner.
+// It is not safe for concurrent use.
+type fileQueue struct {
+	directory string
+}
+
+func newFileQueue(directory string) (*fileQueue, error) {
+	err := os.MkdirAll(directory, 0700)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create queue directory %s", directory)
+	}
+	return &fileQueue{directory: directory}, nil
+}
+
+// push writes the entry and returns its name.  Writes go to a temporary file
+// first, so that a crash never leaves a half-written entry behind.
+func (q *fileQueue) push(entry interface{}) (string, error) {
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return "", errors.Annotatef(err, "unable to marshal queue entry")
+	}
+	nanos := time.Now().UnixNano()
+	name := fmt.Sprintf("%020d", nanos)
+	for _, err := os.Stat(q.path(name)); err == nil; _, err = os.Stat(q.path(name)) {
+		nanos++
+		name = fmt.Sprintf("%020d", nanos)
+	}
+	tmpPath := filepath.Join(q.directory, name+".tmp")
+	err = ioutil.WriteFile(tmpPath, bytes, 0600)
+	if err != nil {
+		return "", errors.Annotatef(err, "unable to write queue entry %s", tmpPath)
+	}
+	err = os.Rename(tmpPath, q.path(name))
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", errors.Annotatef(err, "unable to rename queue entry %s", tmpPath)
+	}
+	return name, nil
+}
+
+// update overwrites an existing entry
+func (q *fileQueue) update(name string, entry interface{}) error {
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Annotatef(err, "unable to marshal queue entry")
+	}
+	tmpPath := filepath.Join(q.directory, name+".tmp")
+	err = ioutil.WriteFile(tmpPath, bytes, 0600)
+	if err != nil {
+		return errors.Annotatef(err, "unable to write queue entry %s", tmpPath)
+	}
+	return errors.Trace(os.Rename(tmpPath, q.path(name)))
+}
+
+// names returns the names of all entries, oldest first
+func (q *fileQueue) names() ([]string, error) {
+	infos, err := ioutil.ReadDir(q.directory)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to read queue directory %s", q.directory)
+	}
+	names := []string{}
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), fileQueueSuffix) {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(info.Name(), fileQueueSuffix))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (q *fileQueue) read(name string, entry interface{}) error {
+	bytes, err := ioutil.ReadFile(q.path(name))
+	if err != nil {
+		return errors.Annotatef(err, "unable to read queue entry %s", name)
+	}
+	err = json.Unmarshal(bytes, entry)
+	if err != nil {
+		return errors.Annotatef(err, "unable to unmarshal queue entry %s", name)
+	}
+	return nil
+}
+
+func (q *fileQueue) remove(name string) error {
+	err := os.Remove(q.path(name))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Annotatef(err, "unable to remove queue entry %s", name)
+	}
+	return nil
+}
+
+func (q *fileQueue) path(name string) string {
+	return filepath.Join(q.directory, name+fileQueueSuffix)
+}
+
+// directorySize returns the total size, in bytes, of the files under a directory
+func directorySize(directory string) (int64, error) {
+	var size int64
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, errors.Trace(err)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFileQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filequeuetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue, err := newFileQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scanName := range []string{"first", "second", "third"} {
+		if _, err = queue.push(&PendingUpload{ScanName: scanName}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a new queue on the same directory should see the same entries, in order
+	queue, err = newFileQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := queue.names()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(names))
+	}
+	var upload PendingUpload
+	if err = queue.read(names[0], &upload); err != nil {
+		t.Fatal(err)
+	}
+	if upload.ScanName != "first" {
+		t.Errorf("expected oldest entry first, got %s", upload.ScanName)
+	}
+
+	upload.Attempts = 2
+	if err = queue.update(names[0], &upload); err != nil {
+		t.Fatal(err)
+	}
+	if err = queue.remove(names[1]); err != nil {
+		t.Fatal(err)
+	}
+	names, _ = queue.names()
+	if len(names) != 2 {
+		t.Fatalf("expected 2 entries after remove, got %d", len(names))
+	}
+	var updated PendingUpload
+	if err = queue.read(names[0], &updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Attempts != 2 {
+		t.Errorf("expected update to be persisted, got %+v", updated)
+	}
+}
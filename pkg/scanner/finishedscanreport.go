@@ -0,0 +1,78 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"fmt"
+
+	ifapi "github.com/blackducksoftware/perceptor-scanner/pkg/api"
+	"github.com/blackducksoftware/perceptor/pkg/api"
+)
+
+// FinishedScanReport is what the scanner sends to perceptor after a scan job.
+// It embeds perceptor's FinishedScanClientJob, so that perceptor can decode it
+// without knowing about the additional fields.
+type FinishedScanReport struct {
+	api.FinishedScanClientJob
+	JobID      string
+	ScanResult *ScanResult `json:",omitempty"`
+	// Interrupted is set when the scanner shut down before the job could finish;
+	// the image wasn't necessarily at fault, so the job can be requeued
+	Interrupted bool `json:",omitempty"`
+	// ResolvedSha is the digest that a tag-only image spec resolved to
+	ResolvedSha string `json:",omitempty"`
+	// Platform and ManifestDigest identify the platform's image that was
+	// scanned, when the image is multi-arch or its manifest was read
+	Platform       string `json:",omitempty"`
+	ManifestDigest string `json:",omitempty"`
+	// PlatformScans are the scans of a multi-arch image's other platforms
+	PlatformScans []*PlatformScan `json:",omitempty"`
+	// ImageMetadata is the scanned image's config and layers
+	ImageMetadata *ifapi.InspectImageResponse `json:",omitempty"`
+}
+
+// NewFinishedScanReport ...
+func NewFinishedScanReport(jobID string, imageSpec *api.ImageSpec, err error, scanResult *ScanResult) *FinishedScanReport {
+	errorString := ""
+	if err != nil {
+		errorString = err.Error()
+	}
+	return &FinishedScanReport{
+		FinishedScanClientJob: api.FinishedScanClientJob{Err: errorString, ImageSpec: imageSpec},
+		JobID:                 jobID,
+		ScanResult:            scanResult}
+}
+
+// String shows the report with the Black Duck password redacted
+func (report *FinishedScanReport) String() string {
+	imageID := ""
+	if report.ImageMetadata != nil {
+		imageID = report.ImageMetadata.ID
+	}
+	return fmt.Sprintf("{JobID:%s ImageSpec:%s Err:%s ScanResult:%+v Interrupted:%t ResolvedSha:%s Platform:%s ManifestDigest:%s PlatformScans:%v ImageID:%s}",
+		report.JobID, redactedImageSpec{report.ImageSpec}, report.Err, report.ScanResult, report.Interrupted, report.ResolvedSha, report.Platform, report.ManifestDigest, report.PlatformScans, imageID)
+}
+
+// Format keeps the password out of every fmt verb, including %#v
+func (report *FinishedScanReport) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, report.String())
+}
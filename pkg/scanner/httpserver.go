@@ -0,0 +1,126 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/juju/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// HTTPResponder ...
+type HTTPResponder interface {
+	GetModel() map[string]interface{}
+	GetJobs() map[string]interface{}
+	CheckLiveness() map[string]error
+	CheckReadiness() map[string]error
+	WriteJobLog(jobID string, w io.Writer) error
+}
+
+// SetupHTTPServer ...
+func SetupHTTPServer(responder HTTPResponder) {
+	http.HandleFunc("/model", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			recordHTTPRequest("model")
+			writeJSON(w, "model", responder.GetModel())
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	http.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			recordHTTPRequest("jobs")
+			writeJSON(w, "jobs", responder.GetJobs())
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	// /jobs/{id}/log
+	http.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		pathParts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+		if len(pathParts) != 2 || pathParts[1] != "log" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			recordHTTPRequest("jobs/log")
+			jobID := pathParts[0]
+			header := w.Header()
+			header.Set(http.CanonicalHeaderKey("content-type"), "text/plain; charset=utf-8")
+			err := responder.WriteJobLog(jobID, w)
+			if errors.IsNotFound(err) {
+				http.NotFound(w, r)
+			} else if err != nil {
+				log.Errorf("unable to write log for job %s: %s", jobID, err.Error())
+				http.Error(w, err.Error(), 500)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			recordHTTPRequest("healthz")
+			common.WriteHealthResponse(w, responder.CheckLiveness())
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			recordHTTPRequest("readyz")
+			common.WriteHealthResponse(w, responder.CheckReadiness())
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	http.Handle("/metrics", prometheus.Handler())
+}
+
+func writeJSON(w http.ResponseWriter, name string, obj interface{}) {
+	jsonBytes, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		log.Errorf("unable to marshal JSON for %s: %s", name, err.Error())
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	header := w.Header()
+	header.Set(http.CanonicalHeaderKey("content-type"), "application/json")
+	fmt.Fprint(w, string(jsonBytes))
+}
@@ -23,10 +23,14 @@ package scanner
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
 	"time"
 
 	"github.com/blackducksoftware/perceptor-scanner/pkg/api"
@@ -36,13 +40,24 @@ import (
 )
 
 const (
-	pullImagePath  = "pullimage"
-	checkImagePath = "checkimage"
+	maxTarballDownloadAttempts = 5
+	// a pull that the image facade turns away because it's busy is requested
+	// again, until it's been turned away for this long
+	maxImageFacadeBusyWait = 30 * time.Minute
+
+	pullImagePath    = "pullimage"
+	checkImagePath   = "checkimage"
+	inspectImagePath = "inspectimage"
+	tarballPath      = "imagetarball"
+	healthPath       = "healthz"
 )
 
 // ImageFacadeClientInterface ...
 type ImageFacadeClientInterface interface {
-	PullImage(image *common.Image) error
+	PullImage(image *common.Image) (*api.CheckImageResponse, error)
+	InspectImage(image *common.Image) (*api.InspectImageResponse, error)
+	DownloadTarball(image *common.Image, path string) error
+	RemoveTarball(image *common.Image) error
 }
 
 // ImageFacadeClient ...
@@ -50,47 +65,108 @@ type ImageFacadeClient struct {
 	ImageFacadeHost string
 	ImageFacadePort int
 	httpClient      *http.Client
+	// downloadClient has no overall timeout, since tarballs can be large
+	downloadClient *http.Client
+	// pollInterval is how often a pull's status is checked
+	pollInterval time.Duration
+	// busyPause is the first pause before asking again for a pull that the
+	// image facade turned away; the pauses double from there
+	busyPause time.Duration
+	// closing stop abandons any pull that's being waited on
+	stop <-chan struct{}
 }
 
 // NewImageFacadeClient ...
-func NewImageFacadeClient(imageFacadeHost string, imageFacadePort int) *ImageFacadeClient {
+func NewImageFacadeClient(imageFacadeHost string, imageFacadePort int, stop <-chan struct{}) *ImageFacadeClient {
 	return &ImageFacadeClient{
 		ImageFacadeHost: imageFacadeHost,
 		ImageFacadePort: imageFacadePort,
-		httpClient:      &http.Client{Timeout: 5 * time.Second}}
+		httpClient:      &http.Client{Timeout: 5 * time.Second},
+		downloadClient: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: 30 * time.Second}},
+		pollInterval: 5 * time.Second,
+		busyPause:    5 * time.Second,
+		stop:         stop}
 }
 
-// PullImage ...
-func (ifp *ImageFacadeClient) PullImage(image *common.Image) error {
+// PullImage returns the image facade's response once the pull is done, which
+// includes the digest that the pull spec resolved to
+func (ifp *ImageFacadeClient) PullImage(image *common.Image) (*api.CheckImageResponse, error) {
 	log.Infof("attempting to pull image %s", image.PullSpec)
 
-	err := ifp.startImagePull(image)
+	err := ifp.requestImagePull(image)
 	if err != nil {
-		return errors.Annotatef(err, "unable to pull image %s", image.PullSpec)
+		return nil, errors.Annotatef(err, "unable to pull image %s", image.PullSpec)
 	}
 
 	for {
-		time.Sleep(5 * time.Second)
+		select {
+		case <-ifp.stop:
+			return nil, fmt.Errorf("stopped waiting for image %s to be pulled", image.PullSpec)
+		case <-time.After(ifp.pollInterval):
+		}
 
-		imageStatus, err := ifp.checkImage(image)
+		response, err := ifp.checkImage(image)
 		if err != nil {
 			log.Errorf("unable to check image %s: %s", image.PullSpec, err.Error())
+			response = &api.CheckImageResponse{PullSpec: image.PullSpec, ImageStatus: common.ImageStatusUnknown}
 		}
 
-		switch imageStatus {
+		switch response.ImageStatus {
 		case common.ImageStatusUnknown:
 			// job got lost somehow -- maybe the container crashed
-			return fmt.Errorf("unable to pull image %s: job was lost", image.PullSpec)
+			return nil, fmt.Errorf("unable to pull image %s: job was lost", image.PullSpec)
 		case common.ImageStatusInProgress:
 			// just keep on waiting
 			break
+		case common.ImageStatusQueued:
+			log.Debugf("image %s is queued at position %d", image.PullSpec, response.QueuePosition)
 		case common.ImageStatusDone:
-			log.Infof("finished pulling image %s", image.PullSpec)
-			return nil
+			log.Infof("finished pulling image %s, digest %s", image.PullSpec, response.Digest)
+			return response, nil
 		case common.ImageStatusError:
-			return fmt.Errorf("unable to pull image %s", image.PullSpec)
+			return nil, fmt.Errorf("unable to pull image %s", image.PullSpec)
 		default:
-			panic(fmt.Errorf("invalid ImageStatus value %d", imageStatus))
+			panic(fmt.Errorf("invalid ImageStatus value %d", response.ImageStatus))
+		}
+	}
+}
+
+// imageFacadeBusyError is returned for pulls that the image facade turned
+// away, because its queue is full or it's shutting down
+type imageFacadeBusyError struct {
+	statusCode int
+	retryAfter time.Duration
+}
+
+func (err *imageFacadeBusyError) Error() string {
+	return fmt.Sprintf("image facade is busy: status code %d", err.statusCode)
+}
+
+// requestImagePull asks the image facade to pull an image, asking again for as
+// long as it's busy
+func (ifp *ImageFacadeClient) requestImagePull(image *common.Image) error {
+	busySince := time.Now()
+	busyBackoff := newBackoff(ifp.busyPause, time.Minute)
+	for {
+		err := ifp.startImagePull(image)
+		busy, ok := err.(*imageFacadeBusyError)
+		if !ok {
+			return err
+		}
+		if time.Since(busySince) > maxImageFacadeBusyWait {
+			return errors.Annotatef(err, "gave up after %s", maxImageFacadeBusyWait)
+		}
+		pause := busyBackoff.next()
+		if busy.retryAfter > pause {
+			pause = busy.retryAfter
+		}
+		log.Warnf("unable to start pulling image %s, trying again in %s: %s", image.PullSpec, pause, err.Error())
+		select {
+		case <-ifp.stop:
+			return fmt.Errorf("stopped waiting for the image facade to pull image %s", image.PullSpec)
+		case <-time.After(pause):
 		}
 	}
 }
@@ -108,52 +184,243 @@ func (ifp *ImageFacadeClient) startImagePull(image *common.Image) error {
 		return errors.Annotatef(err, "unable to create request to %s for image %s", url, image.PullSpec)
 	}
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("request to start image pull for image %s failed with status code %d", url, resp.StatusCode)
-	}
-
 	defer resp.Body.Close()
 	_, _ = ioutil.ReadAll(resp.Body)
 
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		retryAfter, _ := common.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return &imageFacadeBusyError{statusCode: resp.StatusCode, retryAfter: retryAfter}
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("request to start image pull for image %s failed with status code %d", url, resp.StatusCode)
+	}
 	log.Infof("request to start image pull for image %s succeeded", image.PullSpec)
 
 	return nil
 }
 
-func (ifp *ImageFacadeClient) checkImage(image *common.Image) (common.ImageStatus, error) {
+func (ifp *ImageFacadeClient) checkImage(image *common.Image) (*api.CheckImageResponse, error) {
 	url := ifp.buildURL(checkImagePath)
 
 	requestBytes, err := json.Marshal(image)
 	if err != nil {
-		return common.ImageStatusUnknown, errors.Annotatef(err, "unable to marshal JSON for %s", image.PullSpec)
+		return nil, errors.Annotatef(err, "unable to marshal JSON for %s", image.PullSpec)
 	}
 
 	resp, err := ifp.httpClient.Post(url, "application/json", bytes.NewBuffer(requestBytes))
 	if err != nil {
-		return common.ImageStatusUnknown, errors.Annotatef(err, "unable to create request to %s for image %s", url, image.PullSpec)
+		return nil, errors.Annotatef(err, "unable to create request to %s for image %s", url, image.PullSpec)
 	}
 
 	if resp.StatusCode != 200 {
-		return common.ImageStatusUnknown, fmt.Errorf("GET %s failed with status code %d", url, resp.StatusCode)
+		return nil, fmt.Errorf("GET %s failed with status code %d", url, resp.StatusCode)
 	}
 
 	defer resp.Body.Close()
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		recordScannerError("unable to read response body")
-		return common.ImageStatusUnknown, errors.Annotatef(err, "unable to read response body from %s", url)
+		return nil, errors.Annotatef(err, "unable to read response body from %s", url)
 	}
 
 	var getImage api.CheckImageResponse
 	err = json.Unmarshal(bodyBytes, &getImage)
 	if err != nil {
 		recordScannerError("unmarshaling JSON body failed")
-		return common.ImageStatusUnknown, errors.Annotatef(err, "unmarshaling JSON body bytes %s failed for URL %s", string(bodyBytes), url)
+		return nil, errors.Annotatef(err, "unmarshaling JSON body bytes %s failed for URL %s", string(bodyBytes), url)
 	}
 
 	log.Debugf("image check for image %s succeeded, status %s", image.PullSpec, getImage.ImageStatus.String())
 
-	return getImage.ImageStatus, nil
+	return &getImage, nil
+}
+
+// InspectImage returns the config and layers of an image that's been pulled
+func (ifp *ImageFacadeClient) InspectImage(image *common.Image) (*api.InspectImageResponse, error) {
+	url := ifp.buildURL(inspectImagePath)
+
+	requestBytes, err := json.Marshal(image)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to marshal JSON for %s", image.PullSpec)
+	}
+
+	resp, err := ifp.httpClient.Post(url, "application/json", bytes.NewBuffer(requestBytes))
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create request to %s for image %s", url, image.PullSpec)
+	}
+
+	defer resp.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to read response body from %s", url)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("POST %s failed with status code %d: %s", url, resp.StatusCode, string(bodyBytes))
+	}
+
+	var inspection api.InspectImageResponse
+	err = json.Unmarshal(bodyBytes, &inspection)
+	if err != nil {
+		recordScannerError("unmarshaling JSON body failed")
+		return nil, errors.Annotatef(err, "unmarshaling JSON body failed for URL %s", url)
+	}
+	return &inspection, nil
+}
+
+// DownloadTarball copies a pulled image's tarball from the image facade to
+// path.  Interrupted downloads are resumed where they left off, as long as
+// the tarball hasn't changed since.
+func (ifp *ImageFacadeClient) DownloadTarball(image *common.Image, path string) error {
+	// what's left over from before, such as from a scanner that restarted,
+	// may be part of an earlier pull of the image
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Annotatef(err, "unable to remove %s", path)
+	}
+	var err error
+	// validator identifies the version of the tarball that's been downloaded
+	// so far: its ETag or Last-Modified
+	var validator string
+	for attempt := 1; attempt <= maxTarballDownloadAttempts; attempt++ {
+		var done bool
+		done, err = ifp.downloadTarball(image, path, &validator)
+		recordTarballDownload(err == nil)
+		if err == nil {
+			return nil
+		}
+		if done {
+			break
+		}
+		log.Warnf("download of %s, attempt %d, failed: %s", image.PullSpec, attempt, err.Error())
+		select {
+		case <-ifp.stop:
+			return fmt.Errorf("stopped downloading image %s", image.PullSpec)
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+	return errors.Annotatef(err, "unable to download image %s", image.PullSpec)
+}
+
+// downloadTarball downloads a tarball, or the rest of it, to path.  done is
+// set for errors that retrying won't fix.
+func (ifp *ImageFacadeClient) downloadTarball(image *common.Image, path string, validator *string) (done bool, err error) {
+	var offset int64
+	if info, statErr := os.Stat(path); statErr == nil {
+		offset = info.Size()
+	}
+	req, err := http.NewRequest("GET", ifp.tarballURL(image), nil)
+	if err != nil {
+		return true, errors.Trace(err)
+	}
+	// without a validator, the image facade can't tell whether what's been
+	// downloaded is still its tarball, so it's downloaded from the start
+	if offset > 0 && *validator != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// if the tarball has changed, all of the new one is sent
+		req.Header.Set("If-Range", *validator)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-ifp.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	resp, err := ifp.downloadClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, errors.Annotatef(err, "unable to request tarball of %s", image.PullSpec)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	var size int64
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// the image facade sent all of it
+		flags |= os.O_TRUNC
+		offset, size = 0, resp.ContentLength
+		*validator = resp.Header.Get("ETag")
+		if *validator == "" {
+			*validator = resp.Header.Get("Last-Modified")
+		}
+	case http.StatusPartialContent:
+		var start, end int64
+		if _, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &size); err != nil || start != offset {
+			return false, fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), offset)
+		}
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// what's there is longer than the tarball: start over
+		os.Remove(path)
+		return false, fmt.Errorf("%s is longer than the tarball of %s", path, image.PullSpec)
+	case http.StatusNotFound:
+		_, _ = ioutil.ReadAll(resp.Body)
+		return true, fmt.Errorf("image facade has no tarball of %s", image.PullSpec)
+	default:
+		return false, fmt.Errorf("GET tarball of %s failed with status code %d", image.PullSpec, resp.StatusCode)
+	}
+
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return true, errors.Annotatef(err, "unable to open %s", path)
+	}
+	written, err := io.Copy(file, resp.Body)
+	closeErr := file.Close()
+	if err != nil {
+		return false, errors.Annotatef(err, "download of %s stopped after %d bytes", image.PullSpec, offset+written)
+	}
+	if closeErr != nil {
+		return true, errors.Annotatef(closeErr, "unable to write %s", path)
+	}
+	if size >= 0 && offset+written != size {
+		return false, fmt.Errorf("downloaded %d of %d bytes of %s", offset+written, size, image.PullSpec)
+	}
+	log.Infof("downloaded %d bytes of %s to %s, starting at %d", written, image.PullSpec, path, offset)
+	return true, nil
+}
+
+// RemoveTarball tells the image facade that an image's tarball has been
+// downloaded, and can be removed
+func (ifp *ImageFacadeClient) RemoveTarball(image *common.Image) error {
+	req, err := http.NewRequest("DELETE", ifp.tarballURL(image), nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := ifp.httpClient.Do(req)
+	if err != nil {
+		return errors.Annotatef(err, "unable to remove tarball of %s", image.PullSpec)
+	}
+	defer resp.Body.Close()
+	_, _ = ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("DELETE tarball of %s failed with status code %d", image.PullSpec, resp.StatusCode)
+	}
+	return nil
+}
+
+func (ifp *ImageFacadeClient) tarballURL(image *common.Image) string {
+	query := url.Values{}
+	query.Set("pullSpec", image.PullSpec)
+	if image.Platform != "" {
+		query.Set("platform", image.Platform)
+	}
+	return ifp.buildURL(tarballPath) + query.Encode()
+}
+
+// Ping checks that the image facade is up
+func (ifp *ImageFacadeClient) Ping() error {
+	url := ifp.buildURL(healthPath)
+	resp, err := ifp.httpClient.Get(url)
+	if err != nil {
+		return errors.Annotatef(err, "unable to reach image facade at %s", url)
+	}
+	defer resp.Body.Close()
+	_, _ = ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("image facade at %s is unhealthy: status code %d", url, resp.StatusCode)
+	}
+	return nil
 }
 
 func (ifp *ImageFacadeClient) buildURL(path string) string {
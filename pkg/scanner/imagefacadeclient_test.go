@@ -0,0 +1,175 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/api"
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+)
+
+func newTestImageFacadeClient(t *testing.T, server *httptest.Server) *ImageFacadeClient {
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("unable to parse %s: %s", server.URL, err.Error())
+	}
+	portNumber, _ := strconv.Atoi(port)
+	return NewImageFacadeClient(host, portNumber, make(chan struct{}))
+}
+
+func TestDownloadTarballResumes(t *testing.T) {
+	tarball := bytes.Repeat([]byte("0123456789"), 1000)
+	modTime := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	var repulled []byte
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/imagetarball" || r.URL.Query().Get("pullSpec") != "nginx:1.15" || r.URL.Query().Get("platform") != "linux/arm64" {
+			http.NotFound(w, r)
+			return
+		}
+		requests = append(requests, strings.TrimSpace(r.Header.Get("Range")+" "+r.Header.Get("If-Range")))
+		if len(requests)%2 == 1 {
+			// drop the connection part way through
+			w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "10000")
+			w.WriteHeader(http.StatusOK)
+			w.Write(tarball[:6000])
+			return
+		}
+		if repulled != nil {
+			// the image was pulled again in between
+			http.ServeContent(w, r, "nginx_1.15.tar", modTime.Add(time.Hour), bytes.NewReader(repulled))
+			return
+		}
+		http.ServeContent(w, r, "nginx_1.15.tar", modTime, bytes.NewReader(tarball))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nginx_1.15.tar")
+	// a scanner that restarted left part of an earlier pull behind
+	if err = ioutil.WriteFile(path, []byte("stale"), 0644); err != nil {
+		t.Fatalf("unable to write %s: %s", path, err.Error())
+	}
+
+	image := &common.Image{PullSpec: "nginx:1.15", Platform: "linux/arm64"}
+	client := newTestImageFacadeClient(t, server)
+	download := func(expected []byte) {
+		if err = client.DownloadTarball(image, path); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		downloaded, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unable to read %s: %s", path, err.Error())
+		}
+		if !bytes.Equal(downloaded, expected) {
+			t.Errorf("expected %d bytes of tarball, got %d bytes", len(expected), len(downloaded))
+		}
+	}
+	download(tarball)
+	// the resumed download is only for the same tarball
+	validator := modTime.Format(http.TimeFormat)
+	expectedRequests := []string{"", "bytes=6000- " + validator}
+	if strings.Join(requests, ",") != strings.Join(expectedRequests, ",") {
+		t.Errorf("expected requests %v, got %v", expectedRequests, requests)
+	}
+
+	// the tarball changed while resuming: all of the new one is downloaded
+	repulled = bytes.Repeat([]byte("abcdefghij"), 800)
+	download(repulled)
+	expectedRequests = append(expectedRequests, "", "bytes=6000- "+validator)
+	if strings.Join(requests, ",") != strings.Join(expectedRequests, ",") {
+		t.Errorf("expected requests %v, got %v", expectedRequests, requests)
+	}
+
+	if err = client.DownloadTarball(&common.Image{PullSpec: "redis"}, filepath.Join(dir, "redis.tar")); err == nil {
+		t.Errorf("expected error downloading a missing tarball")
+	}
+}
+
+func TestPullImageWaitsForBusyImageFacade(t *testing.T) {
+	pullRequests := 0
+	checks := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var image *common.Image
+		if err := json.NewDecoder(r.Body).Decode(&image); err != nil || image.Priority != 3 {
+			http.Error(w, "unexpected image", 400)
+			return
+		}
+		response := &api.CheckImageResponse{PullSpec: image.PullSpec}
+		switch r.URL.Path {
+		case "/pullimage":
+			pullRequests++
+			if pullRequests == 1 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "pull queue is full", 503)
+				return
+			}
+			response.ImageStatus = common.ImageStatusQueued
+			response.QueuePosition = 2
+		case "/checkimage":
+			checks++
+			response.ImageStatus = common.ImageStatusQueued
+			response.QueuePosition = 1
+			if checks > 1 {
+				response.ImageStatus = common.ImageStatusDone
+				response.QueuePosition = 0
+				response.Digest = "sha256:abc"
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	client := newTestImageFacadeClient(t, server)
+	client.pollInterval = time.Millisecond
+	client.busyPause = time.Millisecond
+	response, err := client.PullImage(&common.Image{PullSpec: "nginx:1.15", Priority: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if response.Digest != "sha256:abc" {
+		t.Errorf("expected digest sha256:abc, got %s", response.Digest)
+	}
+	if pullRequests != 2 || checks != 2 {
+		t.Errorf("expected 2 pull requests and 2 checks, got %d and %d", pullRequests, checks)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"sync"
+	"time"
+
+	"github.com/blackducksoftware/perceptor/pkg/api"
+)
+
+// JobHeartbeat tells perceptor that a scan job is still being worked on.  If
+// perceptor doesn't hear about a job for LeaseSeconds, it can assume that the
+// scanner is gone, and hand the job to another one.
+type JobHeartbeat struct {
+	JobID               string
+	Repository          string
+	Tag                 string
+	Sha                 string
+	Stage               string
+	ElapsedSeconds      float64
+	StageElapsedSeconds float64
+	// Progress is the fraction of the job's work that's done, from 0 to 1
+	Progress     float64
+	LeaseSeconds int
+}
+
+// jobProgress keeps track of a scan job, from when it's received from perceptor
+type jobProgress struct {
+	mutex        sync.Mutex
+	jobID        string
+	imageSpec    *api.ImageSpec
+	started      time.Time
+	stage        JobStage
+	stageStarted time.Time
+}
+
+func newJobProgress(jobID string, imageSpec *api.ImageSpec) *jobProgress {
+	now := time.Now()
+	return &jobProgress{
+		jobID:        jobID,
+		imageSpec:    imageSpec,
+		started:      now,
+		stage:        JobStageQueued,
+		stageStarted: now}
+}
+
+func (jp *jobProgress) setStage(stage JobStage) {
+	jp.mutex.Lock()
+	defer jp.mutex.Unlock()
+	if stage == jp.stage {
+		return
+	}
+	jp.stage = stage
+	jp.stageStarted = time.Now()
+}
+
+func (jp *jobProgress) heartbeat(lease time.Duration) *JobHeartbeat {
+	jp.mutex.Lock()
+	defer jp.mutex.Unlock()
+	now := time.Now()
+	return &JobHeartbeat{
+		JobID:               jp.jobID,
+		Repository:          jp.imageSpec.Repository,
+		Tag:                 jp.imageSpec.Tag,
+		Sha:                 jp.imageSpec.Sha,
+		Stage:               jp.stage.String(),
+		ElapsedSeconds:      now.Sub(jp.started).Seconds(),
+		StageElapsedSeconds: now.Sub(jp.stageStarted).Seconds(),
+		Progress:            jp.stage.progress(),
+		LeaseSeconds:        int(lease.Seconds())}
+}
+
+func (jp *jobProgress) status() *JobStatus {
+	jp.mutex.Lock()
+	defer jp.mutex.Unlock()
+	return &JobStatus{
+		JobID:           jp.jobID,
+		Repository:      jp.imageSpec.Repository,
+		Tag:             jp.imageSpec.Tag,
+		Sha:             jp.imageSpec.Sha,
+		Stage:           jp.stage.String(),
+		Started:         jp.started,
+		DurationSeconds: time.Now().Sub(jp.started).Seconds()}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blackducksoftware/perceptor/pkg/api"
+)
+
+func TestJobProgressHeartbeat(t *testing.T) {
+	progress := newJobProgress("job-1", &api.ImageSpec{Repository: "alpine", Sha: "abc", Password: "secret"})
+	heartbeat := progress.heartbeat(90 * time.Second)
+	if heartbeat.Stage != "Queued" || heartbeat.Progress != 0 || heartbeat.LeaseSeconds != 90 {
+		t.Errorf("unexpected heartbeat for new job: %+v", heartbeat)
+	}
+	if heartbeat.Repository != "alpine" || heartbeat.Sha != "abc" {
+		t.Errorf("expected heartbeat to identify the image, got %+v", heartbeat)
+	}
+
+	output := newScanOutput(nil)
+	output.onUpload = func() { progress.setStage(JobStageUploading) }
+	progress.setStage(JobStageScanning)
+	output.Write([]byte("INFO: scanning /var/images/alpine.tar\n"))
+	// the scan client mentions uploads before it starts uploading
+	output.Write([]byte("INFO: Will upload results to https://blackduck.example.com\n"))
+	output.Write([]byte("DEBUG: upload timeout is 300 seconds\n"))
+	output.Write([]byte("INFO: Scanned /var/images/alpine.tar/upload-helper.sh\n"))
+	if heartbeat = progress.heartbeat(time.Minute); heartbeat.Stage != "Scanning" {
+		t.Errorf("expected Scanning, got %s", heartbeat.Stage)
+	}
+	output.Write([]byte("INFO: Starting upload of scan results\n"))
+	heartbeat = progress.heartbeat(time.Minute)
+	if heartbeat.Stage != "Uploading" || heartbeat.Progress < 0.6 || heartbeat.Progress > 0.7 {
+		t.Errorf("expected Uploading with 2/3 progress, got %+v", heartbeat)
+	}
+
+	for _, line := range []string{"2018-12-01 10:00:00 INFO: Starting upload of scan results", "10:00:00.123 [main] INFO  Starting upload of scan results to Black Duck"} {
+		if !uploadStartedRegexp.MatchString(line) {
+			t.Errorf("expected %q to start the upload", line)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	jobLogSuffix     = ".log"
+	rotatedLogSuffix = ".1"
+)
+
+var jobIDRegexp = regexp.MustCompile(`^[\w.-]+$`)
+
+// JobLogs keeps the output of the scan client for each job in its own file.
+// A job's log is rotated once it reaches maxBytes, keeping one previous file;
+// only the logs of the most recent maxJobs jobs are kept.
+type JobLogs struct {
+	directory string
+	maxBytes  int64
+	maxJobs   int
+	mutex     sync.Mutex
+}
+
+// NewJobLogs ...
+func NewJobLogs(directory string, maxMegabytes int, maxJobs int) (*JobLogs, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create job log directory %s", directory)
+	}
+	return &JobLogs{
+		directory: directory,
+		maxBytes:  int64(maxMegabytes) * 1024 * 1024,
+		maxJobs:   maxJobs}, nil
+}
+
+// Open starts a job's log, removing the logs of old jobs if necessary, or
+// adds to the log it already has: the scan client runs once for each platform
+// of a multi-arch image, and every run's output is kept
+func (jl *JobLogs) Open(jobID string) (io.WriteCloser, error) {
+	if !jobIDRegexp.MatchString(jobID) {
+		return nil, errors.Errorf("invalid job id %s", jobID)
+	}
+	jl.mutex.Lock()
+	defer jl.mutex.Unlock()
+
+	path := jl.path(jobID)
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	} else {
+		jl.removeOldLogs()
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to open log for job %s", jobID)
+	}
+	return &jobLogWriter{path: path, file: file, size: size, maxBytes: jl.maxBytes}, nil
+}
+
+// WriteTo writes the log of a job, including its rotated part, to w
+func (jl *JobLogs) WriteTo(jobID string, w io.Writer) error {
+	if !jobIDRegexp.MatchString(jobID) {
+		return errors.NotFoundf("job %s", jobID)
+	}
+	path := jl.path(jobID)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return errors.NotFoundf("log for job %s", jobID)
+	}
+	for _, p := range []string{path + rotatedLogSuffix, path} {
+		file, err := os.Open(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return errors.Annotatef(err, "unable to open %s", p)
+		}
+		_, err = io.Copy(w, file)
+		file.Close()
+		if err != nil {
+			return errors.Annotatef(err, "unable to copy %s", p)
+		}
+	}
+	return nil
+}
+
+func (jl *JobLogs) path(jobID string) string {
+	return filepath.Join(jl.directory, jobID+jobLogSuffix)
+}
+
+// removeOldLogs makes room for one more job log
+func (jl *JobLogs) removeOldLogs() {
+	infos, err := ioutil.ReadDir(jl.directory)
+	if err != nil {
+		log.Errorf("unable to read job log directory %s: %s", jl.directory, err.Error())
+		return
+	}
+	logs := []os.FileInfo{}
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), jobLogSuffix) {
+			logs = append(logs, info)
+		}
+	}
+	if len(logs) < jl.maxJobs {
+		return
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i].ModTime().Before(logs[j].ModTime()) })
+	for _, info := range logs[:len(logs)-jl.maxJobs+1] {
+		path := filepath.Join(jl.directory, info.Name())
+		log.Debugf("removing old job log %s", path)
+		os.Remove(path)
+		os.Remove(path + rotatedLogSuffix)
+	}
+}
+
+// jobLogWriter writes to a job's log, rotating it when it gets too big
+type jobLogWriter struct {
+	path     string
+	file     *os.File
+	size     int64
+	maxBytes int64
+	mutex    sync.Mutex
+}
+
+func (w *jobLogWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return 0, fmt.Errorf("job log %s is closed", w.path)
+	}
+	if w.maxBytes > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *jobLogWriter) rotate() error {
+	err := w.file.Close()
+	if err != nil {
+		return errors.Annotatef(err, "unable to close %s", w.path)
+	}
+	err = os.Rename(w.path, w.path+rotatedLogSuffix)
+	if err != nil {
+		return errors.Annotatef(err, "unable to rotate %s", w.path)
+	}
+	w.file, err = os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Annotatef(err, "unable to reopen %s", w.path)
+	}
+	w.size = 0
+	return nil
+}
+
+func (w *jobLogWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
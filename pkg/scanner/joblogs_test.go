@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestJobLogsRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "joblogstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jobLogs, err := NewJobLogs(dir, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a line of 1KB: writing 1.5MB rotates the log once
+	line := strings.Repeat("x", 1023) + "\n"
+	w, err := jobLogs.Open("job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1536; i++ {
+		if _, err = w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	buf := &bytes.Buffer{}
+	if err = jobLogs.WriteTo("job-1", buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 1536*1024 {
+		t.Errorf("expected rotated and current log to be served together, got %d bytes", buf.Len())
+	}
+
+	// a job that runs the scan client again, for another platform, adds to its log
+	w, err = jobLogs.Open("job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("platform linux/arm64\n")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	buf.Reset()
+	if err = jobLogs.WriteTo("job-1", buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), line) || !strings.HasSuffix(buf.String(), line+"platform linux/arm64\n") {
+		t.Errorf("expected the earlier output to be kept, got %d bytes ending %q", buf.Len(), buf.String()[buf.Len()-32:])
+	}
+
+	// only the two most recent jobs are kept
+	for _, jobID := range []string{"job-2", "job-3"} {
+		w, err = jobLogs.Open(jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+	if err = jobLogs.WriteTo("job-1", &bytes.Buffer{}); !errors.IsNotFound(err) {
+		t.Errorf("expected log of oldest job to be removed, got %v", err)
+	}
+	if err = jobLogs.WriteTo("../job-3", &bytes.Buffer{}); !errors.IsNotFound(err) {
+		t.Errorf("expected invalid job id to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// jobQueue holds scan jobs that have been received from perceptor but not
+// started yet.  Higher priority jobs come out first; jobs with the same
+// priority come out in the order they were received.
+// It is not safe for concurrent use.
+type jobQueue struct {
+	jobs     queuedJobs
+	received int
+}
+
+type queuedJob struct {
+	progress *jobProgress
+	priority int
+	// order is when the job was received, relative to the other jobs
+	order int
+}
+
+// queuedJobs implements heap.Interface
+type queuedJobs []*queuedJob
+
+func (qj queuedJobs) Len() int { return len(qj) }
+
+func (qj queuedJobs) Less(i, j int) bool {
+	if qj[i].priority != qj[j].priority {
+		return qj[i].priority > qj[j].priority
+	}
+	return qj[i].order < qj[j].order
+}
+
+func (qj queuedJobs) Swap(i, j int) { qj[i], qj[j] = qj[j], qj[i] }
+
+func (qj *queuedJobs) Push(x interface{}) { *qj = append(*qj, x.(*queuedJob)) }
+
+func (qj *queuedJobs) Pop() interface{} {
+	old := *qj
+	job := old[len(old)-1]
+	*qj = old[:len(old)-1]
+	return job
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{jobs: queuedJobs{}}
+}
+
+func (q *jobQueue) push(progress *jobProgress) {
+	q.received++
+	heap.Push(&q.jobs, &queuedJob{progress: progress, priority: progress.imageSpec.Priority, order: q.received})
+}
+
+// pop returns the next job to run, or nil if there isn't one
+func (q *jobQueue) pop() *jobProgress {
+	if len(q.jobs) == 0 {
+		return nil
+	}
+	return heap.Pop(&q.jobs).(*queuedJob).progress
+}
+
+func (q *jobQueue) len() int {
+	return len(q.jobs)
+}
+
+// list returns the queued jobs in the order they'll run
+func (q *jobQueue) list() []*jobProgress {
+	jobs := make(queuedJobs, len(q.jobs))
+	copy(jobs, q.jobs)
+	sort.Sort(jobs)
+	progresses := []*jobProgress{}
+	for _, job := range jobs {
+		progresses = append(progresses, job.progress)
+	}
+	return progresses
+}
@@ -0,0 +1,55 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"testing"
+
+	"github.com/blackducksoftware/perceptor/pkg/api"
+)
+
+func TestJobQueueOrdersByPriority(t *testing.T) {
+	queue := newJobQueue()
+	for _, job := range []struct {
+		jobID    string
+		priority int
+	}{{"low-1", 0}, {"high-1", 5}, {"low-2", 0}, {"high-2", 5}, {"medium", 1}} {
+		queue.push(newJobProgress(job.jobID, &api.ImageSpec{Priority: job.priority}))
+	}
+
+	expected := []string{"high-1", "high-2", "medium", "low-1", "low-2"}
+	listed := queue.list()
+	for i, jobID := range expected {
+		if listed[i].jobID != jobID {
+			t.Errorf("expected listed job %d to be %s, got %s", i, jobID, listed[i].jobID)
+		}
+	}
+	for _, jobID := range expected {
+		progress := queue.pop()
+		if progress == nil || progress.jobID != jobID {
+			t.Fatalf("expected %s, got %+v", jobID, progress)
+		}
+	}
+	if queue.pop() != nil || queue.len() != 0 {
+		t.Errorf("expected queue to be empty")
+	}
+}
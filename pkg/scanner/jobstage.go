@@ -0,0 +1,67 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import "fmt"
+
+// JobStage is how far along a scan job is
+type JobStage int
+
+// ...
+const (
+	JobStageQueued    JobStage = iota
+	JobStagePulling   JobStage = iota
+	JobStageScanning  JobStage = iota
+	JobStageUploading JobStage = iota
+)
+
+func (js JobStage) String() string {
+	switch js {
+	case JobStageQueued:
+		return "Queued"
+	case JobStagePulling:
+		return "Pulling"
+	case JobStageScanning:
+		return "Scanning"
+	case JobStageUploading:
+		return "Uploading"
+	default:
+		panic(fmt.Errorf("invalid JobStage value: %d", js))
+	}
+}
+
+// progress returns the fraction of a job's work that's done by the time it reaches the stage
+func (js JobStage) progress() float64 {
+	switch js {
+	case JobStageScanning:
+		return 1.0 / 3
+	case JobStageUploading:
+		return 2.0 / 3
+	default:
+		return 0
+	}
+}
+
+// JobStageListener is told when a scan job moves on to another stage
+type JobStageListener interface {
+	SetJobStage(jobID string, stage JobStage)
+}
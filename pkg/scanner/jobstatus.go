@@ -0,0 +1,65 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import "time"
+
+// JobStatus describes a scan job, for debugging through the HTTP API
+type JobStatus struct {
+	JobID      string
+	Repository string
+	Tag        string
+	Sha        string
+	Stage      string
+	Started    time.Time
+	// Finished is only set for completed jobs
+	Finished          *time.Time `json:",omitempty"`
+	DurationSeconds   float64
+	ImageFacadeStatus string      `json:",omitempty"`
+	Error             string      `json:",omitempty"`
+	Interrupted       bool        `json:",omitempty"`
+	ScanResult        *ScanResult `json:",omitempty"`
+}
+
+// jobHistory keeps the most recently completed jobs, newest first.
+// It is not safe for concurrent use.
+type jobHistory struct {
+	size int
+	jobs []*JobStatus
+}
+
+func newJobHistory(size int) *jobHistory {
+	return &jobHistory{size: size, jobs: []*JobStatus{}}
+}
+
+func (jh *jobHistory) add(job *JobStatus) {
+	jh.jobs = append([]*JobStatus{job}, jh.jobs...)
+	if len(jh.jobs) > jh.size {
+		jh.jobs = jh.jobs[:jh.size]
+	}
+}
+
+func (jh *jobHistory) list() []*JobStatus {
+	jobs := make([]*JobStatus, len(jh.jobs))
+	copy(jobs, jh.jobs)
+	return jobs
+}
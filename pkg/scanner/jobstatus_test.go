@@ -0,0 +1,38 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import "testing"
+
+func TestJobHistoryKeepsNewestJobs(t *testing.T) {
+	history := newJobHistory(2)
+	for _, jobID := range []string{"job-1", "job-2", "job-3"} {
+		history.add(&JobStatus{JobID: jobID})
+	}
+	jobs := history.list()
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 jobs, got %d", len(jobs))
+	}
+	if jobs[0].JobID != "job-3" || jobs[1].JobID != "job-2" {
+		t.Errorf("expected newest jobs first, got %s, %s", jobs[0].JobID, jobs[1].JobID)
+	}
+}
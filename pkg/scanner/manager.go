@@ -22,8 +22,14 @@ under the License.
 package scanner
 
 import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
 	"github.com/blackducksoftware/perceptor/pkg/api"
 	"github.com/juju/errors"
 	log "github.com/sirupsen/logrus"
@@ -31,13 +37,57 @@ import (
 
 const (
 	requestScanJobPause = 20 * time.Second
+	// once a job's been interrupted, this is how long it has to wind down
+	interruptedJobTimeout = 10 * time.Second
+	// on shutdown, finished scan reports are delivered for up to this long;
+	// those that aren't are delivered after the restart
+	outboxFlushTimeout = 5 * time.Second
+	// perceptor may reassign a job after this many missed heartbeats
+	heartbeatsPerLease = 3
+	// between jobs, the job loop is considered stalled if it's been idle this long
+	jobLoopStallTimeout = 5 * requestScanJobPause
 )
 
+var jobIDUnsafeCharacters = regexp.MustCompile(`[^\w.-]`)
+
 // Manager ...
 type Manager struct {
-	scanner         *Scanner
+	config            *Config
+	scanner           *Scanner
+	scanClient        *ScanClient
+	imageFacadeClient *ImageFacadeClient
+	// uploader is nil unless offline (dry run) scanning is enabled
+	uploader        *Uploader
 	perceptorClient *PerceptorClient
-	stop            <-chan struct{}
+	outbox          *Outbox
+	jobLogs         *JobLogs
+	blackDuckToken  string
+	// blackDuckConnections holds locally configured credentials, by domain
+	blackDuckConnections map[string]*Host
+	// perceptorCredentials holds the credentials that perceptor sent with the
+	// latest job for each domain, for uploading dry run scans to it later
+	perceptorCredentials map[string]*Host
+	credentialsMutex     sync.Mutex
+	// shutdown is closed when draining starts: no more jobs are requested
+	shutdown chan struct{}
+	// interrupt is closed when the drain period is over: the current job is abandoned
+	interrupt chan struct{}
+	// jobsDone is closed once the job loop has exited
+	jobsDone     chan struct{}
+	shutdownOnce sync.Once
+	// currentJob is nil while no job is running
+	currentJob        *jobProgress
+	queue             *jobQueue
+	prefetchCount     int
+	completedJobs     *jobHistory
+	jobLoopActivity   time.Time
+	jobMutex          sync.Mutex
+	heartbeatInterval time.Duration
+	// downloadHost is used to download the scan client before the first job, if known
+	downloadHost *Host
+	// downloadingScanClient is true while that download runs, which has its own timeout
+	downloadingScanClient bool
+	ensureScanClient      func(host *Host) (*ScanClientInfo, error)
 }
 
 // Host configures the Black Duck hosts
@@ -47,65 +97,516 @@ type Host struct {
 	Port     int    `json:"port"`
 	User     string `json:"user"`
 	Password string `json:"password"`
+	// Token is a Black Duck API token; if present, it's used instead of User and Password
+	Token string `json:"token"`
+}
+
+// String shows the host with its password and token redacted
+func (host Host) String() string {
+	return fmt.Sprintf("{Scheme:%s Domain:%s Port:%d User:%s Password:%s Token:%s}",
+		host.Scheme, host.Domain, host.Port, host.User, common.RedactIfSet(host.Password), common.RedactIfSet(host.Token))
+}
+
+// Format keeps the password and token out of every fmt verb, including %#v
+func (host Host) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, host.String())
 }
 
 // NewManager return the manager type
-func NewManager(config *Config, stop <-chan struct{}) (*Manager, error) {
-	log.Infof("instantiating Manager with config %+v", config)
+func NewManager(config *Config) (*Manager, error) {
+	log.Infof("instantiating Manager with config %s", config)
+
+	blackDuckConnections, err := loadBlackDuckConnections(config.BlackDuck)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load Black Duck connections")
+	}
+	log.Infof("loaded %d Black Duck connections", len(blackDuckConnections))
 
-	imagePuller := NewImageFacadeClient(config.ImageFacade.GetHost(), config.ImageFacade.Port)
-	scanClient, err := NewScanClient(config.BlackDuck.TLSVerification)
+	if config.Scanner.Platform != "" {
+		if _, err = common.ParsePlatform(config.Scanner.Platform); err != nil {
+			return nil, errors.Annotatef(err, "invalid scanner platform")
+		}
+	}
+
+	shutdown := make(chan struct{})
+	interrupt := make(chan struct{})
+
+	imagePuller := NewImageFacadeClient(config.ImageFacade.GetHost(), config.ImageFacade.Port, interrupt)
+	jobLogs, err := NewJobLogs(config.Scanner.GetJobLogDirectory(), config.Scanner.GetJobLogMaxMegabytes(), config.Scanner.GetJobLogMaxJobs())
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to instantiate job logs")
+	}
+	scanClient, err := NewScanClient(config.BlackDuck.TLSVerification, jobLogs, interrupt)
 	if err != nil {
 		return nil, errors.Annotatef(err, "unable to instantiate hub scan client")
 	}
+	if config.Scanner.ScanClientDirectory != "" {
+		if err = scanClient.UseLocalScanClient(config.Scanner.ScanClientDirectory); err != nil {
+			return nil, errors.Annotatef(err, "unable to use local scan client")
+		}
+	} else if config.Scanner.DryRunDirectory != "" {
+		return nil, fmt.Errorf("dry runs need a local scan client in ScanClientDirectory, since Black Duck may be unreachable")
+	}
 
-	return &Manager{
-		scanner:         NewScanner(imagePuller, scanClient, config.Scanner.GetImageDirectory(), stop),
-		perceptorClient: NewPerceptorClient(config.Perceptor.Host, config.Perceptor.Port),
-		stop:            stop}, nil
+	var uploader *Uploader
+	if config.Scanner.DryRunDirectory != "" {
+		uploader, err = NewUploader(config.Scanner.DryRunDirectory, config.Scanner.GetDryRunMaxMegabytes(), config.Scanner.GetDryRunMaxPending(), scanClient, shutdown)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to instantiate dry run uploader")
+		}
+	}
+
+	perceptorClient := NewPerceptorClient(config.Perceptor.Host, config.Perceptor.Port)
+	outbox, err := NewOutbox(config.Scanner.GetOutboxDirectory(), perceptorClient, shutdown)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to instantiate finished scan outbox")
+	}
+	outbox.Start()
+
+	sm := &Manager{
+		config:               config,
+		scanner:              NewScanner(imagePuller, scanClient, config.Scanner.GetImageDirectory(), config.Scanner.Platform, config.Scanner.ScanAllPlatforms, config.Scanner.ScanNameMetadata, config.Scanner.DownloadTarballs, uploader, interrupt),
+		scanClient:           scanClient,
+		imageFacadeClient:    imagePuller,
+		uploader:             uploader,
+		perceptorClient:      perceptorClient,
+		outbox:               outbox,
+		jobLogs:              jobLogs,
+		blackDuckToken:       config.BlackDuck.Token,
+		blackDuckConnections: blackDuckConnections,
+		perceptorCredentials: map[string]*Host{},
+		shutdown:             shutdown,
+		interrupt:            interrupt,
+		jobsDone:             make(chan struct{}),
+		queue:                newJobQueue(),
+		prefetchCount:        config.Scanner.PrefetchCount,
+		completedJobs:        newJobHistory(config.Scanner.GetJobHistorySize()),
+		jobLoopActivity:      time.Now(),
+		heartbeatInterval:    time.Duration(config.Scanner.GetHeartbeatSeconds()) * time.Second,
+		ensureScanClient:     scanClient.ensureScanClientIsDownloaded}
+	scanClient.stageListener = sm
+	if uploader != nil {
+		uploader.credentials = sm.uploadHost
+		uploader.Start()
+	}
+	for _, host := range blackDuckConnections {
+		sm.downloadHost = host
+		break
+	}
+	return sm, nil
 }
 
-// StartRequestingScanJobs will start asking for work
+// StartRequestingScanJobs will start asking for work, until Shutdown is called
 func (sm *Manager) StartRequestingScanJobs() {
-	log.Infof("starting to request scan jobs")
+	log.Infof("starting to request scan jobs, prefetching %d", sm.prefetchCount)
+	go sm.sendHeartbeats()
 	go func() {
+		defer close(sm.jobsDone)
+		if sm.downloadHost != nil {
+			// so that the scanner is ready before its first job
+			sm.downloadScanClient()
+		}
+		pause := requestScanJobPause
 		for {
+			sm.recordJobLoopActivity()
 			select {
-			case <-sm.stop:
+			case <-sm.shutdown:
+				log.Infof("stopped requesting scan jobs")
+				sm.handBackQueuedJobs()
 				return
-			case <-time.After(requestScanJobPause):
-				sm.requestAndRunScanJob()
+			case <-time.After(pause):
+			}
+			if sm.isShuttingDown() {
+				continue
+			}
+			sm.requestScanJobs()
+			progress := sm.nextQueuedJob()
+			if progress != nil {
+				sm.runScanJob(progress)
+			}
+			pause = requestScanJobPause
+			if sm.queuedJobCount() > 0 {
+				pause = 0
 			}
 		}
 	}()
 }
 
-// requestAndRunScanJob will request for scan jobs from the Perceptor
-func (sm *Manager) requestAndRunScanJob() {
-	log.Debug("requesting scan job")
-	nextImage, err := sm.perceptorClient.GetNextImage()
-	if err != nil {
-		log.Errorf("unable to request scan job: %s", err.Error())
-		return
+// downloadScanClient downloads the scan client from downloadHost; the job
+// loop counts as live while it runs
+func (sm *Manager) downloadScanClient() {
+	sm.setDownloadingScanClient(true)
+	defer sm.setDownloadingScanClient(false)
+	if _, err := sm.ensureScanClient(sm.downloadHost); err != nil {
+		log.Errorf("unable to download scan client from %s: %s", sm.downloadHost.Domain, err.Error())
 	}
-	if nextImage.ImageSpec == nil {
-		log.Debug("requested scan job, got nil")
-		return
+}
+
+func (sm *Manager) setDownloadingScanClient(downloading bool) {
+	sm.jobMutex.Lock()
+	defer sm.jobMutex.Unlock()
+	sm.downloadingScanClient = downloading
+	sm.jobLoopActivity = time.Now()
+}
+
+// requestScanJobs asks the perceptor for work, until there are prefetchCount
+// jobs queued on top of the one that's about to run
+func (sm *Manager) requestScanJobs() {
+	for sm.queuedJobCount() <= sm.prefetchCount {
+		log.Debug("requesting scan job")
+		nextImage, err := sm.perceptorClient.GetNextImage()
+		if err != nil {
+			log.Errorf("unable to request scan job: %s", err.Error())
+			return
+		}
+		if nextImage.ImageSpec == nil {
+			log.Debug("requested scan job, got nil")
+			return
+		}
+		jobID := newJobID(nextImage.ImageSpec)
+		log.Infof("queueing scan job %s: %s", jobID, redactedImageSpec{nextImage.ImageSpec})
+		progress := newJobProgress(jobID, nextImage.ImageSpec)
+		sm.jobMutex.Lock()
+		sm.queue.push(progress)
+		sm.jobMutex.Unlock()
 	}
+}
 
-	log.Infof("processing scan job %+v", nextImage)
+// runScanJob pulls and scans an image, and reports the result to the perceptor
+func (sm *Manager) runScanJob(progress *jobProgress) {
+	jobID := progress.jobID
+	imageSpec := progress.imageSpec
+	log.Infof("processing scan job %s: %s", jobID, redactedImageSpec{imageSpec})
 
-	err = sm.scanner.ScanFullDockerImage(nextImage.ImageSpec)
-	errorString := ""
+	progress.setStage(JobStagePulling)
+	sm.setCurrentJob(progress)
+	sm.sendHeartbeat(progress)
+	scanResult, pulledImage, err := sm.scanner.ScanFullDockerImage(jobID, sm.blackDuckHost(imageSpec), imageSpec)
 	if err != nil {
 		log.Errorf("scan error: %s", err.Error())
-		errorString = err.Error()
 	}
+	if scanResult != nil {
+		recordScanResult(scanResult)
+	}
+
+	finishedJob := NewFinishedScanReport(jobID, imageSpec, err, scanResult)
+	if err != nil && sm.isInterrupted() {
+		log.Warnf("scan job %s was interrupted by shutdown", jobID)
+		recordScannerError("scan job interrupted")
+		finishedJob.Interrupted = true
+	}
+	if pulledImage != nil {
+		if imageSpec.Sha == "" {
+			finishedJob.ResolvedSha = strings.TrimPrefix(pulledImage.Digest, "sha256:")
+		}
+		finishedJob.Platform = pulledImage.Platform
+		finishedJob.ManifestDigest = pulledImage.ManifestDigest
+		finishedJob.PlatformScans = pulledImage.PlatformScans
+		finishedJob.ImageMetadata = pulledImage.Metadata
+	}
+	sm.finishCurrentJob(progress, finishedJob)
+	sm.report(finishedJob)
+}
+
+// handBackQueuedJobs reports jobs that were never started as interrupted, so
+// that the perceptor can give them to another scanner
+func (sm *Manager) handBackQueuedJobs() {
+	sm.jobMutex.Lock()
+	progresses := []*jobProgress{}
+	for progress := sm.queue.pop(); progress != nil; progress = sm.queue.pop() {
+		progresses = append(progresses, progress)
+	}
+	sm.jobMutex.Unlock()
+	for _, progress := range progresses {
+		log.Infof("handing back queued scan job %s", progress.jobID)
+		finishedJob := NewFinishedScanReport(progress.jobID, progress.imageSpec, fmt.Errorf("scanner shut down before the job started"), nil)
+		finishedJob.Interrupted = true
+		sm.report(finishedJob)
+	}
+}
 
-	finishedJob := api.FinishedScanClientJob{Err: errorString, ImageSpec: nextImage.ImageSpec}
-	log.Infof("about to finish job, going to send over %+v", finishedJob)
-	sm.perceptorClient.PostFinishedScan(&finishedJob)
+// report sends a finished job to the perceptor, by way of the outbox
+func (sm *Manager) report(finishedJob *FinishedScanReport) {
+	log.Infof("about to finish job, going to send over %s", finishedJob)
+	err := sm.outbox.Enqueue(finishedJob)
 	if err != nil {
-		log.Errorf("unable to finish scan job: %s", err.Error())
+		// the outbox is only unusable if the disk is -- try sending it directly
+		log.Errorf("unable to persist finished scan job %s: %s", finishedJob.JobID, err.Error())
+		err = sm.perceptorClient.PostFinishedScan(finishedJob)
+		if err != nil {
+			log.Errorf("unable to finish scan job %s: %s", finishedJob.JobID, err.Error())
+		}
+	}
+}
+
+// sendHeartbeats tells perceptor about the progress of the current and queued
+// jobs until the job loop exits, so that perceptor can tell a long-running job
+// from a dead scanner
+func (sm *Manager) sendHeartbeats() {
+	for {
+		select {
+		case <-sm.jobsDone:
+			return
+		case <-time.After(sm.heartbeatInterval):
+		}
+		for _, progress := range sm.activeJobs() {
+			sm.sendHeartbeat(progress)
+		}
 	}
 }
+
+func (sm *Manager) sendHeartbeat(progress *jobProgress) {
+	heartbeat := progress.heartbeat(heartbeatsPerLease * sm.heartbeatInterval)
+	log.Debugf("sending heartbeat for job %s: %+v", heartbeat.JobID, heartbeat)
+	if err := sm.perceptorClient.PostJobHeartbeat(heartbeat); err != nil {
+		log.Errorf("unable to send heartbeat for job %s: %s", heartbeat.JobID, err.Error())
+	}
+}
+
+// activeJobs returns the current job, if any, followed by the queued jobs
+func (sm *Manager) activeJobs() []*jobProgress {
+	sm.jobMutex.Lock()
+	defer sm.jobMutex.Unlock()
+	progresses := []*jobProgress{}
+	if sm.currentJob != nil {
+		progresses = append(progresses, sm.currentJob)
+	}
+	return append(progresses, sm.queue.list()...)
+}
+
+func (sm *Manager) nextQueuedJob() *jobProgress {
+	sm.jobMutex.Lock()
+	defer sm.jobMutex.Unlock()
+	return sm.queue.pop()
+}
+
+func (sm *Manager) queuedJobCount() int {
+	sm.jobMutex.Lock()
+	defer sm.jobMutex.Unlock()
+	return sm.queue.len()
+}
+
+func (sm *Manager) recordJobLoopActivity() {
+	sm.jobMutex.Lock()
+	defer sm.jobMutex.Unlock()
+	sm.jobLoopActivity = time.Now()
+}
+
+func (sm *Manager) setCurrentJob(progress *jobProgress) {
+	sm.jobMutex.Lock()
+	defer sm.jobMutex.Unlock()
+	sm.currentJob = progress
+}
+
+// finishCurrentJob moves the current job into the history of completed jobs
+func (sm *Manager) finishCurrentJob(progress *jobProgress, report *FinishedScanReport) {
+	status := progress.status()
+	finished := time.Now()
+	status.Finished = &finished
+	status.Error = report.Err
+	status.Interrupted = report.Interrupted
+	status.ScanResult = report.ScanResult
+
+	sm.jobMutex.Lock()
+	defer sm.jobMutex.Unlock()
+	sm.completedJobs.add(status)
+	sm.currentJob = nil
+}
+
+// SetJobStage implements JobStageListener
+func (sm *Manager) SetJobStage(jobID string, stage JobStage) {
+	sm.jobMutex.Lock()
+	defer sm.jobMutex.Unlock()
+	if sm.currentJob == nil || sm.currentJob.jobID != jobID {
+		// e.g. an upload of a dry run scan, which isn't part of the current job
+		return
+	}
+	log.Infof("job %s is now %s", jobID, stage.String())
+	sm.currentJob.setStage(stage)
+}
+
+// Shutdown stops requesting scan jobs, and gives the current job until the end
+// of drainPeriod to finish.  After that, the job is interrupted and reported to
+// perceptor as such, so that it can be requeued.  Reports that can't be
+// delivered before exiting stay in the outbox until the next start.
+func (sm *Manager) Shutdown(drainPeriod time.Duration) {
+	sm.shutdownOnce.Do(func() {
+		log.Infof("draining scan jobs for up to %s", drainPeriod)
+		close(sm.shutdown)
+		select {
+		case <-sm.jobsDone:
+			log.Infof("scan jobs drained")
+		case <-time.After(drainPeriod):
+			log.Warnf("drain period of %s is over, interrupting the current scan job", drainPeriod)
+			close(sm.interrupt)
+			select {
+			case <-sm.jobsDone:
+			case <-time.After(interruptedJobTimeout):
+				log.Errorf("timed out waiting for the interrupted scan job")
+			}
+		}
+
+		delivered := make(chan error, 1)
+		go func() {
+			delivered <- sm.outbox.DeliverPending()
+		}()
+		select {
+		case err := <-delivered:
+			if err != nil {
+				log.Errorf("%d finished scan reports left undelivered: %s", sm.outbox.PendingCount(), err.Error())
+			}
+		case <-time.After(outboxFlushTimeout):
+			log.Errorf("timed out delivering finished scan reports, %d left undelivered", sm.outbox.PendingCount())
+		}
+	})
+}
+
+func (sm *Manager) isInterrupted() bool {
+	select {
+	case <-sm.interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// blackDuckHost returns the Black Duck connection to use for a job.  Credentials
+// configured locally for the job's host take precedence over those from perceptor.
+func (sm *Manager) blackDuckHost(imageSpec *api.ImageSpec) *Host {
+	host := &Host{
+		Scheme:   imageSpec.Scheme,
+		Domain:   imageSpec.Domain,
+		Port:     imageSpec.Port,
+		User:     imageSpec.User,
+		Password: imageSpec.Password,
+		Token:    sm.blackDuckToken}
+	if host.User != "" {
+		sm.credentialsMutex.Lock()
+		sm.perceptorCredentials[host.Domain] = &Host{User: host.User, Password: host.Password}
+		sm.credentialsMutex.Unlock()
+	}
+	return withLocalCredentials(host, sm.blackDuckConnections)
+}
+
+// uploadHost returns the connection to upload a pending dry run scan to its
+// Black Duck host, with the credentials that are current for it: those
+// configured locally, or else the API token, or those that perceptor sent
+// with the latest job for it
+func (sm *Manager) uploadHost(host *Host) (*Host, error) {
+	current := &Host{Scheme: host.Scheme, Domain: host.Domain, Port: host.Port, Token: sm.blackDuckToken}
+	sm.credentialsMutex.Lock()
+	if credentials, ok := sm.perceptorCredentials[host.Domain]; ok {
+		current.User = credentials.User
+		current.Password = credentials.Password
+	}
+	sm.credentialsMutex.Unlock()
+	current = withLocalCredentials(current, sm.blackDuckConnections)
+	if current.Token == "" && current.User == "" {
+		return nil, fmt.Errorf("no credentials for Black Duck host %s yet", host.Domain)
+	}
+	return current, nil
+}
+
+// newJobID returns an identifier for a scan job, which is also safe to use as a file name
+func newJobID(imageSpec *api.ImageSpec) string {
+	sha := imageSpec.Sha
+	if sha == "" {
+		sha = imageSpec.Tag
+	}
+	if len(sha) > 12 {
+		sha = sha[:12]
+	}
+	jobID := fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405.000"), sha)
+	return jobIDUnsafeCharacters.ReplaceAllString(jobID, "_")
+}
+
+// HTTPResponder implementation
+
+// GetJobs returns the current job, if any, the queued jobs, and the most recently completed jobs
+func (sm *Manager) GetJobs() map[string]interface{} {
+	sm.jobMutex.Lock()
+	progress := sm.currentJob
+	queuedJobs := []*JobStatus{}
+	for _, queued := range sm.queue.list() {
+		queuedJobs = append(queuedJobs, queued.status())
+	}
+	completedJobs := sm.completedJobs.list()
+	sm.jobMutex.Unlock()
+
+	var currentJob *JobStatus
+	if progress != nil {
+		currentJob = progress.status()
+		if progress.imageSpec != nil && currentJob.Stage == JobStagePulling.String() {
+			// this goes over the network, so the lock mustn't be held
+			response, err := sm.imageFacadeClient.checkImage(sm.scanner.image(progress.imageSpec, sm.scanner.platform))
+			if err != nil {
+				currentJob.ImageFacadeStatus = fmt.Sprintf("unable to check image: %s", err.Error())
+			} else {
+				currentJob.ImageFacadeStatus = response.ImageStatus.String()
+				if response.ImageStatus == common.ImageStatusQueued {
+					currentJob.ImageFacadeStatus = fmt.Sprintf("%s (position %d)", response.ImageStatus.String(), response.QueuePosition)
+				}
+			}
+		}
+	}
+	return map[string]interface{}{
+		"CurrentJob":    currentJob,
+		"QueuedJobs":    queuedJobs,
+		"CompletedJobs": completedJobs,
+	}
+}
+
+// GetModel returns the state of the scanner, for debugging
+func (sm *Manager) GetModel() map[string]interface{} {
+	model := sm.GetJobs()
+	model["ScanClientVersion"] = sm.scanClient.ScanClientVersion()
+	model["PendingFinishedScans"] = sm.outbox.PendingCount()
+	if sm.uploader != nil {
+		model["PendingUploads"] = sm.uploader.PendingCount()
+	}
+	model["Config"] = sm.config.redacted()
+	return model
+}
+
+// CheckLiveness checks that the job loop isn't stalled
+func (sm *Manager) CheckLiveness() map[string]error {
+	sm.jobMutex.Lock()
+	defer sm.jobMutex.Unlock()
+	var err error
+	idle := time.Now().Sub(sm.jobLoopActivity)
+	// a running job and the scan client download have their own timeouts, and
+	// the loop exits when shutting down
+	busy := sm.currentJob != nil || sm.downloadingScanClient
+	if !busy && !sm.isShuttingDown() && idle > jobLoopStallTimeout {
+		err = fmt.Errorf("job loop has been idle for %s", idle)
+	}
+	return map[string]error{"jobLoop": err}
+}
+
+// CheckReadiness checks that the scan client is downloaded, and that the
+// perceptor and the image facade are reachable
+func (sm *Manager) CheckReadiness() map[string]error {
+	var scanClientErr error
+	if sm.scanClient.ScanClientVersion() == "" {
+		scanClientErr = fmt.Errorf("scan client has not been downloaded")
+	}
+	return map[string]error{
+		"scanClient":  scanClientErr,
+		"perceptor":   sm.perceptorClient.Ping(),
+		"imageFacade": sm.imageFacadeClient.Ping(),
+	}
+}
+
+func (sm *Manager) isShuttingDown() bool {
+	select {
+	case <-sm.shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// WriteJobLog writes the scan client's output for a job
+func (sm *Manager) WriteJobLog(jobID string, w io.Writer) error {
+	return sm.jobLogs.WriteTo(jobID, w)
+}
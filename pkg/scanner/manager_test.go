@@ -0,0 +1,174 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/blackducksoftware/perceptor/pkg/api"
+)
+
+func TestCheckLivenessDuringSlowScanClientDownload(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	sm := &Manager{
+		shutdown:        make(chan struct{}),
+		downloadHost:    &Host{Domain: "blackduck"},
+		jobLoopActivity: time.Now(),
+		ensureScanClient: func(host *Host) (*ScanClientInfo, error) {
+			close(started)
+			<-release
+			return &ScanClientInfo{}, nil
+		}}
+	done := make(chan struct{})
+	go func() {
+		sm.downloadScanClient()
+		close(done)
+	}()
+	<-started
+
+	// pretend the download has been running for longer than the stall timeout
+	sm.jobMutex.Lock()
+	sm.jobLoopActivity = time.Now().Add(-2 * jobLoopStallTimeout)
+	sm.jobMutex.Unlock()
+	if err := sm.CheckLiveness()["jobLoop"]; err != nil {
+		t.Errorf("expected the job loop to be live while downloading the scan client, got %s", err.Error())
+	}
+
+	close(release)
+	<-done
+	if err := sm.CheckLiveness()["jobLoop"]; err != nil {
+		t.Errorf("expected the job loop to be live right after the download, got %s", err.Error())
+	}
+
+	sm.jobMutex.Lock()
+	sm.jobLoopActivity = time.Now().Add(-2 * jobLoopStallTimeout)
+	sm.jobMutex.Unlock()
+	if err := sm.CheckLiveness()["jobLoop"]; err == nil {
+		t.Errorf("expected a stalled job loop to fail the liveness check")
+	}
+}
+
+// newShutdownTestManager returns a manager whose job loop is run by the test,
+// with a finished scan report waiting in its outbox
+func newShutdownTestManager(t *testing.T, dir string, perceptor *fakePerceptorClient) *Manager {
+	outbox, err := NewOutbox(dir, perceptor, make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := NewFinishedScanReport("job-1", &api.ImageSpec{Sha: "abc"}, nil, &ScanResult{FileCount: 3})
+	if err = outbox.Enqueue(report); err != nil {
+		t.Fatal(err)
+	}
+	return &Manager{
+		outbox:    outbox,
+		shutdown:  make(chan struct{}),
+		interrupt: make(chan struct{}),
+		jobsDone:  make(chan struct{})}
+}
+
+func TestManagerShutdownDrainsJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "managertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	perceptor := &fakePerceptorClient{fail: true}
+	sm := newShutdownTestManager(t, dir, perceptor)
+	// the current job finishes soon after the shutdown starts
+	go func() {
+		<-sm.shutdown
+		time.Sleep(10 * time.Millisecond)
+		perceptor.fail = false
+		close(sm.jobsDone)
+	}()
+
+	sm.Shutdown(time.Minute)
+	if sm.isInterrupted() {
+		t.Errorf("expected a job that finished within the drain period not to be interrupted")
+	}
+	if len(perceptor.received) != 1 || sm.outbox.PendingCount() != 0 {
+		t.Errorf("expected the finished scan report to be delivered, got %d delivered and %d pending", len(perceptor.received), sm.outbox.PendingCount())
+	}
+
+	// a second shutdown does nothing
+	sm.Shutdown(time.Minute)
+}
+
+func TestManagerShutdownInterruptsJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "managertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	perceptor := &fakePerceptorClient{}
+	sm := newShutdownTestManager(t, dir, perceptor)
+	// the current job only stops once it's interrupted
+	go func() {
+		<-sm.shutdown
+		<-sm.interrupt
+		close(sm.jobsDone)
+	}()
+
+	start := time.Now()
+	sm.Shutdown(10 * time.Millisecond)
+	if !sm.isInterrupted() {
+		t.Errorf("expected the job to be interrupted once the drain period was over")
+	}
+	if elapsed := time.Now().Sub(start); elapsed > interruptedJobTimeout {
+		t.Errorf("expected the shutdown to finish once the interrupted job did, took %s", elapsed)
+	}
+	if len(perceptor.received) != 1 {
+		t.Errorf("expected the finished scan report to be delivered, got %d", len(perceptor.received))
+	}
+}
+
+func TestManagerUploadHost(t *testing.T) {
+	sm := &Manager{
+		blackDuckConnections: map[string]*Host{"hub.local": {Scheme: "https", Domain: "hub.local", Port: 443, Token: "local-token"}},
+		perceptorCredentials: map[string]*Host{}}
+	if _, err := sm.uploadHost(&Host{Domain: "hub.remote"}); err == nil {
+		t.Errorf("expected an error for a host without credentials")
+	}
+
+	// perceptor's credentials for a host are remembered from its latest job
+	sm.blackDuckHost(&api.ImageSpec{Domain: "hub.remote", User: "sysadmin", Password: "old-password"})
+	sm.blackDuckHost(&api.ImageSpec{Domain: "hub.remote", User: "sysadmin", Password: "new-password"})
+	host, err := sm.uploadHost(&Host{Scheme: "https", Domain: "hub.remote", Port: 8443})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expected := Host{Scheme: "https", Domain: "hub.remote", Port: 8443, User: "sysadmin", Password: "new-password"}
+	if *host != expected {
+		t.Errorf("expected %+v, got %+v", expected, *host)
+	}
+
+	// local credentials win
+	if host, err = sm.uploadHost(&Host{Domain: "hub.local"}); err != nil || host.Token != "local-token" {
+		t.Errorf("expected the local token, got %+v, %v", host, err)
+	}
+}
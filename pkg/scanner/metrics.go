@@ -29,10 +29,19 @@ import (
 )
 
 var httpResults *prometheus.CounterVec
+var httpRequestsCounter *prometheus.CounterVec
 var scanClientDurationHistogram *prometheus.HistogramVec
 var totalScannerDurationHistogram *prometheus.HistogramVec
 var errorsCounter *prometheus.CounterVec
 var cleanUpFileCounter *prometheus.CounterVec
+var scanFileCountHistogram prometheus.Histogram
+var scanDirectoryCountHistogram prometheus.Histogram
+var scanUploadStatusCounter *prometheus.CounterVec
+var pendingUploadsGauge prometheus.Gauge
+var uploadResultCounter *prometheus.CounterVec
+var pendingFinishedScansGauge prometheus.Gauge
+var finishedScanDeliveryCounter *prometheus.CounterVec
+var tarballDownloadCounter *prometheus.CounterVec
 
 // helpers
 
@@ -46,6 +55,10 @@ func recordHTTPStats(path string, statusCode int) {
 	httpResults.With(prometheus.Labels{"path": path, "code": fmt.Sprintf("%d", statusCode)}).Inc()
 }
 
+func recordHTTPRequest(path string) {
+	httpRequestsCounter.With(prometheus.Labels{"path": path}).Inc()
+}
+
 func recordScanClientDuration(duration time.Duration, isSuccess bool) {
 	result := "success"
 	if !isSuccess {
@@ -70,6 +83,36 @@ func recordCleanUpFile(isSuccess bool) {
 	cleanUpFileCounter.With(prometheus.Labels{"success": fmt.Sprintf("%t", isSuccess)})
 }
 
+func recordScanResult(result *ScanResult) {
+	scanFileCountHistogram.Observe(float64(result.FileCount))
+	scanDirectoryCountHistogram.Observe(float64(result.DirectoryCount))
+	uploadStatus := result.UploadStatus
+	if uploadStatus == "" {
+		uploadStatus = "unknown"
+	}
+	scanUploadStatusCounter.With(prometheus.Labels{"status": uploadStatus}).Inc()
+}
+
+func recordPendingUploads(count int) {
+	pendingUploadsGauge.Set(float64(count))
+}
+
+func recordUploadResult(isSuccess bool) {
+	uploadResultCounter.With(prometheus.Labels{"success": fmt.Sprintf("%t", isSuccess)}).Inc()
+}
+
+func recordPendingFinishedScans(count int) {
+	pendingFinishedScansGauge.Set(float64(count))
+}
+
+func recordFinishedScanDelivery(isSuccess bool) {
+	finishedScanDeliveryCounter.With(prometheus.Labels{"success": fmt.Sprintf("%t", isSuccess)}).Inc()
+}
+
+func recordTarballDownload(isSuccess bool) {
+	tarballDownloadCounter.With(prometheus.Labels{"success": fmt.Sprintf("%t", isSuccess)}).Inc()
+}
+
 // init
 
 func init() {
@@ -81,6 +124,14 @@ func init() {
 	}, []string{"path", "code"})
 	prometheus.MustRegister(httpResults)
 
+	httpRequestsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "scanner",
+		Name:      "http_requests_received",
+		Help:      "HTTP requests received by scanner",
+	}, []string{"path"})
+	prometheus.MustRegister(httpRequestsCounter)
+
 	scanClientDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: "perceptor",
 		Subsystem: "scanner",
@@ -114,4 +165,70 @@ func init() {
 		Help:      "success, failure of cleaning up files after pulling them",
 	}, []string{"success"})
 	prometheus.MustRegister(cleanUpFileCounter)
+
+	scanFileCountHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "perceptor",
+		Subsystem: "scanner",
+		Name:      "scan_file_count",
+		Help:      "number of files found by the scan client",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 20),
+	})
+	prometheus.MustRegister(scanFileCountHistogram)
+
+	scanDirectoryCountHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "perceptor",
+		Subsystem: "scanner",
+		Name:      "scan_directory_count",
+		Help:      "number of directories found by the scan client",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 20),
+	})
+	prometheus.MustRegister(scanDirectoryCountHistogram)
+
+	scanUploadStatusCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "scanner",
+		Name:      "scan_upload_status",
+		Help:      "upload status reported by the scan client",
+	}, []string{"status"})
+	prometheus.MustRegister(scanUploadStatusCounter)
+
+	pendingUploadsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "perceptor",
+		Subsystem: "scanner",
+		Name:      "pending_uploads",
+		Help:      "number of dry run scans waiting to be uploaded to Black Duck",
+	})
+	prometheus.MustRegister(pendingUploadsGauge)
+
+	uploadResultCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "scanner",
+		Name:      "dry_run_upload_results",
+		Help:      "success, failure of uploading dry run scans to Black Duck",
+	}, []string{"success"})
+	prometheus.MustRegister(uploadResultCounter)
+
+	pendingFinishedScansGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "perceptor",
+		Subsystem: "scanner",
+		Name:      "pending_finished_scans",
+		Help:      "number of finished scan reports waiting to be delivered to perceptor",
+	})
+	prometheus.MustRegister(pendingFinishedScansGauge)
+
+	finishedScanDeliveryCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "scanner",
+		Name:      "finished_scan_deliveries",
+		Help:      "success, failure of delivering finished scan reports to perceptor",
+	}, []string{"success"})
+	prometheus.MustRegister(finishedScanDeliveryCounter)
+
+	tarballDownloadCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "scanner",
+		Name:      "tarball_downloads",
+		Help:      "success, failure of attempts to download tarballs from the image facade",
+	}, []string{"success"})
+	prometheus.MustRegister(tarballDownloadCounter)
 }
@@ -34,6 +34,13 @@ func TestMetrics(t *testing.T) {
 	recordScanClientDuration(time.Now().Sub(time.Now()), true)
 	recordTotalScannerDuration(time.Now().Sub(time.Now()), false)
 	recordHTTPStats("getnextimage", 200)
+	recordHTTPRequest("jobs/log")
+	recordScanResult(&ScanResult{FileCount: 12, DirectoryCount: 3})
+	recordPendingUploads(4)
+	recordUploadResult(false)
+	recordPendingFinishedScans(2)
+	recordFinishedScanDelivery(true)
+	recordTarballDownload(false)
 
 	message := "finished test case"
 	t.Log(message)
@@ -0,0 +1,177 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	outboxPause          = 30 * time.Second
+	outboxInitialBackoff = 5 * time.Second
+	outboxMaxBackoff     = 5 * time.Minute
+)
+
+// pendingReport is a finished scan report that perceptor hasn't acknowledged yet
+type pendingReport struct {
+	Report    *FinishedScanReport
+	Created   time.Time
+	Attempts  int
+	LastError string
+}
+
+// Outbox delivers finished scan reports to perceptor.  Reports are persisted
+// before they're sent, and only deleted once perceptor has accepted them, so
+// that a perceptor outage -- or a scanner restart -- doesn't lose scan results.
+type Outbox struct {
+	queue           *fileQueue
+	perceptorClient PerceptorClientInterface
+	// wake prompts the delivery loop to send a newly added report right away
+	wake chan struct{}
+	// deliveryMutex keeps a report from being sent twice at the same time
+	deliveryMutex sync.Mutex
+	mutex         sync.Mutex
+	stop          <-chan struct{}
+}
+
+// NewOutbox ...
+func NewOutbox(directory string, perceptorClient PerceptorClientInterface, stop <-chan struct{}) (*Outbox, error) {
+	queue, err := newFileQueue(directory)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	outbox := &Outbox{
+		queue:           queue,
+		perceptorClient: perceptorClient,
+		wake:            make(chan struct{}, 1),
+		stop:            stop}
+	recordPendingFinishedScans(outbox.PendingCount())
+	return outbox, nil
+}
+
+// Start delivers reports in the background, until stop is closed.  Reports left
+// over from before a restart are delivered first.
+func (o *Outbox) Start() {
+	log.Infof("starting to deliver finished scan reports from %s", o.queue.directory)
+	go func() {
+		b := newBackoff(outboxInitialBackoff, outboxMaxBackoff)
+		var pause time.Duration
+		for {
+			select {
+			case <-o.stop:
+				return
+			case <-o.wake:
+			case <-time.After(pause):
+			}
+			err := o.DeliverPending()
+			if err != nil {
+				pause = b.next()
+				log.Errorf("unable to deliver finished scan reports, retrying in %s: %s", pause, err.Error())
+			} else {
+				b.reset()
+				pause = outboxPause
+			}
+		}
+	}()
+}
+
+// Enqueue persists a report, and prompts its delivery
+func (o *Outbox) Enqueue(report *FinishedScanReport) error {
+	o.mutex.Lock()
+	_, err := o.queue.push(&pendingReport{Report: report, Created: time.Now()})
+	o.mutex.Unlock()
+	if err != nil {
+		return errors.Annotatef(err, "unable to persist finished scan report for job %s", report.JobID)
+	}
+	recordPendingFinishedScans(o.PendingCount())
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// PendingCount returns the number of reports waiting to be delivered
+func (o *Outbox) PendingCount() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	names, err := o.queue.names()
+	if err != nil {
+		log.Errorf("unable to count pending finished scan reports: %s", err.Error())
+		return 0
+	}
+	return len(names)
+}
+
+// DeliverPending sends pending reports to perceptor, oldest first.  It gives up
+// at the first failure, since the most likely cause is that perceptor is down.
+func (o *Outbox) DeliverPending() error {
+	o.deliveryMutex.Lock()
+	defer o.deliveryMutex.Unlock()
+
+	o.mutex.Lock()
+	names, err := o.queue.names()
+	o.mutex.Unlock()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, name := range names {
+		var pending pendingReport
+		err = o.queue.read(name, &pending)
+		if err != nil || pending.Report == nil {
+			// nothing we can do with an unreadable entry -- don't let it block the rest
+			log.Errorf("dropping unreadable finished scan report %s: %+v", name, err)
+			o.remove(name)
+			continue
+		}
+		err = o.perceptorClient.PostFinishedScan(pending.Report)
+		recordFinishedScanDelivery(err == nil)
+		if err != nil {
+			pending.Attempts++
+			pending.LastError = err.Error()
+			o.mutex.Lock()
+			if updateErr := o.queue.update(name, &pending); updateErr != nil {
+				log.Errorf("unable to update finished scan report %s: %s", name, updateErr.Error())
+			}
+			o.mutex.Unlock()
+			return errors.Annotatef(err, "unable to deliver finished scan report for job %s after %d attempts", pending.Report.JobID, pending.Attempts)
+		}
+		log.Infof("delivered finished scan report for job %s, queued at %s", pending.Report.JobID, pending.Created)
+		o.remove(name)
+	}
+	return nil
+}
+
+func (o *Outbox) remove(name string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if err := o.queue.remove(name); err != nil {
+		log.Errorf("unable to remove finished scan report %s: %s", name, err.Error())
+	}
+	if names, err := o.queue.names(); err == nil {
+		recordPendingFinishedScans(len(names))
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/blackducksoftware/perceptor/pkg/api"
+)
+
+type fakePerceptorClient struct {
+	fail     bool
+	received []*FinishedScanReport
+}
+
+func (pc *fakePerceptorClient) GetNextImage() (*api.NextImage, error) {
+	return &api.NextImage{}, nil
+}
+
+func (pc *fakePerceptorClient) PostFinishedScan(scan *FinishedScanReport) error {
+	if pc.fail {
+		return fmt.Errorf("perceptor is down")
+	}
+	pc.received = append(pc.received, scan)
+	return nil
+}
+
+func (pc *fakePerceptorClient) PostJobHeartbeat(heartbeat *JobHeartbeat) error {
+	return nil
+}
+
+func TestOutboxKeepsReportsUntilDelivered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outboxtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	perceptor := &fakePerceptorClient{fail: true}
+	outbox, err := NewOutbox(dir, perceptor, make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, jobID := range []string{"job-1", "job-2"} {
+		report := NewFinishedScanReport(jobID, &api.ImageSpec{Sha: "abc"}, nil, &ScanResult{FileCount: 3})
+		if err = outbox.Enqueue(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = outbox.DeliverPending(); err == nil {
+		t.Fatal("expected delivery to fail while perceptor is down")
+	}
+	if outbox.PendingCount() != 2 {
+		t.Fatalf("expected 2 pending reports, got %d", outbox.PendingCount())
+	}
+
+	// as if the scanner restarted
+	perceptor.fail = false
+	outbox, err = NewOutbox(dir, perceptor, make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = outbox.DeliverPending(); err != nil {
+		t.Fatal(err)
+	}
+	if outbox.PendingCount() != 0 {
+		t.Errorf("expected no pending reports, got %d", outbox.PendingCount())
+	}
+	if len(perceptor.received) != 2 || perceptor.received[0].JobID != "job-1" || perceptor.received[1].JobID != "job-2" {
+		t.Fatalf("expected both reports in order, got %+v", perceptor.received)
+	}
+	if perceptor.received[0].ImageSpec.Sha != "abc" || perceptor.received[0].ScanResult.FileCount != 3 {
+		t.Errorf("expected report to survive the round trip, got %+v", perceptor.received[0])
+	}
+}
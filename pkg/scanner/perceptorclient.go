@@ -23,6 +23,8 @@ package scanner
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/blackducksoftware/perceptor/pkg/api"
@@ -34,12 +36,16 @@ import (
 const (
 	nextImagePath    = "nextimage"
 	finishedScanPath = "finishedscan"
+	jobHeartbeatPath = "jobheartbeat"
+
+	pingTimeout = 5 * time.Second
 )
 
 // PerceptorClientInterface provides an interface for accessing the perceptor
 type PerceptorClientInterface interface {
 	GetNextImage() (*api.NextImage, error)
-	PostFinishedScan(scan *api.FinishedScanClientJob) error
+	PostFinishedScan(scan *FinishedScanReport) error
+	PostJobHeartbeat(heartbeat *JobHeartbeat) error
 }
 
 // PerceptorClient stores the Perceptor configurations
@@ -84,9 +90,9 @@ func (pc *PerceptorClient) GetNextImage() (*api.NextImage, error) {
 }
 
 // PostFinishedScan updates the perceptor about the Black Duck scan
-func (pc *PerceptorClient) PostFinishedScan(scan *api.FinishedScanClientJob) error {
+func (pc *PerceptorClient) PostFinishedScan(scan *FinishedScanReport) error {
 	url := fmt.Sprintf("http://%s:%d/%s", pc.Host, pc.Port, finishedScanPath)
-	log.Debugf("about to issue post request %+v to url %s", scan, url)
+	log.Debugf("about to issue post request %s to url %s", scan, url)
 	resp, err := pc.Resty.R().SetBody(scan).Post(url)
 	log.Debugf("received resp %+v, status code %d, error %+v from url %s", resp, resp.StatusCode(), err, url)
 	recordHTTPStats(finishedScanPath, resp.StatusCode())
@@ -99,3 +105,29 @@ func (pc *PerceptorClient) PostFinishedScan(scan *api.FinishedScanClientJob) err
 	}
 	return errors.Trace(err)
 }
+
+// PostJobHeartbeat tells the perceptor that a scan job is still running
+func (pc *PerceptorClient) PostJobHeartbeat(heartbeat *JobHeartbeat) error {
+	url := fmt.Sprintf("http://%s:%d/%s", pc.Host, pc.Port, jobHeartbeatPath)
+	log.Debugf("about to issue post request %+v to url %s", heartbeat, url)
+	resp, err := pc.Resty.R().SetBody(heartbeat).Post(url)
+	recordHTTPStats(jobHeartbeatPath, resp.StatusCode())
+	if err != nil {
+		recordScannerError("unable to post job heartbeat")
+		return errors.Annotatef(err, "unable to post job heartbeat")
+	} else if (resp.StatusCode() < 200) || (resp.StatusCode() >= 300) {
+		recordScannerError("unable to post job heartbeat -- bad status code")
+		return fmt.Errorf("unable to post job heartbeat; body %s and status code %d", string(resp.Body()), resp.StatusCode())
+	}
+	return nil
+}
+
+// Ping checks that the perceptor accepts connections
+func (pc *PerceptorClient) Ping() error {
+	address := net.JoinHostPort(pc.Host, strconv.Itoa(pc.Port))
+	conn, err := net.DialTimeout("tcp", address, pingTimeout)
+	if err != nil {
+		return errors.Annotatef(err, "unable to reach perceptor at %s", address)
+	}
+	return conn.Close()
+}
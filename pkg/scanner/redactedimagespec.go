@@ -0,0 +1,50 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"fmt"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/blackducksoftware/perceptor/pkg/api"
+)
+
+// redactedImageSpec wraps perceptor's ImageSpec for logging, since it carries
+// the Black Duck password
+type redactedImageSpec struct {
+	*api.ImageSpec
+}
+
+// String shows the image spec with the password redacted
+func (spec redactedImageSpec) String() string {
+	if spec.ImageSpec == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("{Repository:%s Tag:%s Sha:%s Scheme:%s Domain:%s Port:%d User:%s Password:%s BlackDuckProjectName:%s BlackDuckProjectVersionName:%s BlackDuckScanName:%s Priority:%d}",
+		spec.Repository, spec.Tag, spec.Sha, spec.Scheme, spec.Domain, spec.Port, spec.User, common.RedactIfSet(spec.Password),
+		spec.BlackDuckProjectName, spec.BlackDuckProjectVersionName, spec.BlackDuckScanName, spec.Priority)
+}
+
+// Format keeps the password out of every fmt verb, including %#v
+func (spec redactedImageSpec) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, spec.String())
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/blackducksoftware/perceptor/pkg/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// newCapturingLogger returns a logger that writes to a buffer, with the same
+// redaction hook as the standard logger
+func newCapturingLogger() (*log.Logger, *bytes.Buffer) {
+	buffer := &bytes.Buffer{}
+	logger := log.New()
+	logger.Out = buffer
+	logger.Level = log.DebugLevel
+	logger.Hooks.Add(&common.RedactionHook{})
+	return logger, buffer
+}
+
+func TestSecretsNeverReachLogOutput(t *testing.T) {
+	jobPassword := "job-password-1234"
+	hostPassword := "host-password-5678"
+	apiToken := "api-token-abcdef"
+	imageSpec := &api.ImageSpec{Repository: "alpine", Tag: "3.8", Domain: "blackduck", User: "sysadmin", Password: jobPassword}
+	host := &Host{Scheme: "https", Domain: "blackduck", Port: 443, User: "sysadmin", Password: hostPassword, Token: apiToken}
+	config := &Config{BlackDuck: &BlackDuckConfig{Token: apiToken}, Scanner: &ScannerConfig{Port: 3003}}
+	report := NewFinishedScanReport("job-1", imageSpec, fmt.Errorf("scan failed"), nil)
+
+	logger, buffer := newCapturingLogger()
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+		logger.Infof("image spec "+verb, redactedImageSpec{imageSpec})
+		logger.Infof("host "+verb, host)
+		logger.Infof("host "+verb, *host)
+		logger.Infof("config "+verb, config)
+		logger.Infof("Black Duck config "+verb, config.BlackDuck)
+		logger.Infof("report "+verb, report)
+	}
+	logger.WithField("host", host).Info("field")
+	logger.Infof("command %s", strings.Join(exec.Command("scan.cli.sh", "--creds", "sysadmin:"+hostPassword).Args, " "))
+	logger.Infof("environment %s", fmt.Sprintf("BD_HUB_PASSWORD=%s", hostPassword))
+	logger.Infof("header Authorization: token %s", apiToken)
+	// perceptor's ImageSpec itself has no redaction, so this one relies on
+	// the password having been registered
+	common.RegisterSecret(jobPassword)
+	logger.Infof("raw image spec %+v", *imageSpec)
+
+	output := buffer.String()
+	for _, secret := range []string{jobPassword, hostPassword, apiToken} {
+		if strings.Contains(output, secret) {
+			t.Errorf("expected %s to be redacted from log output:\n%s", secret, output)
+		}
+	}
+	if !strings.Contains(output, common.RedactedValue) || !strings.Contains(output, "alpine") {
+		t.Errorf("expected redacted values alongside the rest of the output:\n%s", output)
+	}
+}
+
+func TestRedactIfSetKeepsEmptySecretsEmpty(t *testing.T) {
+	host := Host{Domain: "blackduck"}
+	if strings.Contains(host.String(), common.RedactedValue) {
+		t.Errorf("expected unset password and token to be shown as empty, got %s", host.String())
+	}
+}
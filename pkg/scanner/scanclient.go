@@ -23,7 +23,12 @@ package scanner
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/juju/errors"
@@ -32,7 +37,9 @@ import (
 
 // ScanClientInterface ...
 type ScanClientInterface interface {
-	Scan(scheme string, host string, port int, username string, password string, path string, projectName string, versionName string, scanName string) error
+	Scan(jobID string, host *Host, path string, projectName string, versionName string, scanName string) (*ScanResult, error)
+	DryRunScan(jobID string, host *Host, path string, projectName string, versionName string, scanName string, dryRunDirectory string) (*ScanResult, error)
+	UploadDryRun(jobID string, host *Host, dryRunFile string) (*ScanResult, error)
 	//ScanCliSh(job ScanJob) error
 	//ScanDockerSh(job ScanJob) error
 }
@@ -42,34 +49,74 @@ type ScanClientInterface interface {
 type ScanClient struct {
 	tlsVerification bool
 	scanClientInfo  *ScanClientInfo
+	// mutex guards scanClientInfo, which is set by the first download
+	mutex sync.Mutex
+	// downloadMutex keeps the scan client from being downloaded more than once
+	downloadMutex sync.Mutex
+	// jobLogs is optional; if present, the scan client's output for each job is kept there
+	jobLogs *JobLogs
+	// closing interrupt kills any scan client process that's running
+	interrupt <-chan struct{}
+	// stageListener is optional; if present, it's told when a job starts scanning and uploading
+	stageListener JobStageListener
 }
 
 // NewScanClient requires hub login credentials
-func NewScanClient(tlsVerification bool) (*ScanClient, error) {
-	sc := ScanClient{tlsVerification: tlsVerification}
+func NewScanClient(tlsVerification bool, jobLogs *JobLogs, interrupt <-chan struct{}) (*ScanClient, error) {
+	sc := ScanClient{tlsVerification: tlsVerification, jobLogs: jobLogs, interrupt: interrupt}
 	return &sc, nil
 }
 
+// UseLocalScanClient uses the scan client that's unpacked in rootPath, instead
+// of downloading one from Black Duck
+func (sc *ScanClient) UseLocalScanClient(rootPath string) error {
+	scanClientInfo, err := FindLocalScanClient(rootPath, OSTypeLinux)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sc.mutex.Lock()
+	sc.scanClientInfo = scanClientInfo
+	sc.mutex.Unlock()
+	log.Infof("using scan client %s from %s", scanClientInfo.HubVersion, rootPath)
+	return nil
+}
+
 // ensureScanClientIsDownloaded will make sure that the Black Duck scan client is Downloaded for scanning
-func (sc *ScanClient) ensureScanClientIsDownloaded(scheme string, host string, port int, username string, password string) error {
-	if sc.scanClientInfo != nil {
-		return nil
+func (sc *ScanClient) ensureScanClientIsDownloaded(host *Host) (*ScanClientInfo, error) {
+	sc.downloadMutex.Lock()
+	defer sc.downloadMutex.Unlock()
+	if scanClientInfo := sc.getScanClientInfo(); scanClientInfo != nil {
+		return scanClientInfo, nil
 	}
 	cliRootPath := "/tmp/scanner"
 	scanClientInfo, err := DownloadScanClient(
 		OSTypeLinux,
 		cliRootPath,
-		scheme,
 		host,
-		username,
-		password,
-		port,
+		sc.tlsVerification,
 		time.Duration(300)*time.Second)
 	if err != nil {
-		return errors.Annotate(err, "unable to download scan client")
+		return nil, errors.Annotate(err, "unable to download scan client")
 	}
+	sc.mutex.Lock()
 	sc.scanClientInfo = scanClientInfo
-	return nil
+	sc.mutex.Unlock()
+	return scanClientInfo, nil
+}
+
+func (sc *ScanClient) getScanClientInfo() *ScanClientInfo {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.scanClientInfo
+}
+
+// ScanClientVersion returns the version of the scan client in use, or "" if
+// it hasn't been downloaded yet
+func (sc *ScanClient) ScanClientVersion() string {
+	if scanClientInfo := sc.getScanClientInfo(); scanClientInfo != nil {
+		return scanClientInfo.HubVersion
+	}
+	return ""
 }
 
 // getTLSVerification return the TLS verfiication of the Black Duck host
@@ -81,62 +128,174 @@ func (sc *ScanClient) getTLSVerification() string {
 }
 
 // Scan executes the Black Duck scan for the input artifact
-func (sc *ScanClient) Scan(scheme string, host string, port int, username string, password string, path string, projectName string, versionName string, scanName string) error {
-	if err := sc.ensureScanClientIsDownloaded(scheme, host, port, username, password); err != nil {
-		return errors.Annotate(err, "cannot run scan cli")
+func (sc *ScanClient) Scan(jobID string, host *Host, path string, projectName string, versionName string, scanName string) (*ScanResult, error) {
+	return sc.runScanClient(jobID, host, path,
+		"--project", projectName,
+		"--release", versionName,
+		"--name", scanName)
+}
+
+// DryRunScan executes the scan for the input artifact without uploading anything to
+// Black Duck.  The scan client writes its results to dryRunDirectory instead, from
+// where they can be uploaded later with UploadDryRun.
+func (sc *ScanClient) DryRunScan(jobID string, host *Host, path string, projectName string, versionName string, scanName string, dryRunDirectory string) (*ScanResult, error) {
+	return sc.runScanClient(jobID, host, path,
+		"--project", projectName,
+		"--release", versionName,
+		"--name", scanName,
+		"--dryRunWriteDir", dryRunDirectory)
+}
+
+// UploadDryRun uploads a results file written by DryRunScan to Black Duck
+func (sc *ScanClient) UploadDryRun(jobID string, host *Host, dryRunFile string) (*ScanResult, error) {
+	return sc.runScanClient(jobID, host, "",
+		"--dryRunReadFile", dryRunFile)
+}
+
+// runScanClient runs the java scan client with the connection arguments, plus any
+// extra arguments.  path is optional: uploads of dry run results don't have one.
+func (sc *ScanClient) runScanClient(jobID string, host *Host, path string, extraArgs ...string) (*ScanResult, error) {
+	scanClientInfo, err := sc.ensureScanClientIsDownloaded(host)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot run scan cli")
 	}
 	startTotal := time.Now()
 
-	scanCliImplJarPath := sc.scanClientInfo.ScanCliImplJarPath()
-	scanCliJarPath := sc.scanClientInfo.ScanCliJarPath()
-	scanCliJavaPath := sc.scanClientInfo.ScanCliJavaPath()
-	cmd := exec.Command(scanCliJavaPath,
+	statusDir, err := ioutil.TempDir("", "scanstatus")
+	if err != nil {
+		return nil, errors.Annotate(err, "unable to create scan status directory")
+	}
+	defer os.RemoveAll(statusDir)
+
+	scanCliImplJarPath := scanClientInfo.ScanCliImplJarPath()
+	scanCliJarPath := scanClientInfo.ScanCliJarPath()
+	scanCliJavaPath := scanClientInfo.ScanCliJavaPath()
+	args := []string{
 		"-Xms512m",
 		"-Xmx4096m",
 		"-Dblackduck.scan.cli.benice=true",
 		"-Dblackduck.scan.skipUpdate=true",
 		"-Done-jar.silent=true",
-		"-Done-jar.jar.path="+scanCliImplJarPath,
+		"-Done-jar.jar.path=" + scanCliImplJarPath,
 		"-jar", scanCliJarPath,
-		"--host", host,
-		"--port", fmt.Sprintf("%d", port),
-		"--scheme", scheme,
-		"--project", projectName,
-		"--release", versionName,
-		"--username", username,
-		"--name", scanName,
-		sc.getTLSVerification(),
-		"-v",
-		path)
-	log.Infof("running command %+v for path %s\n", cmd, path)
-	cmd.Env = append(cmd.Env, fmt.Sprintf("BD_HUB_PASSWORD=%s", password))
+		"--host", host.Domain,
+		"--port", fmt.Sprintf("%d", host.Port),
+		"--scheme", host.Scheme,
+		"--statusWriteDir", statusDir}
+	args = append(args, sc.credentialArgs(host)...)
+	args = append(args, extraArgs...)
+	args = append(args, sc.getTLSVerification(), "-v")
+	if path != "" {
+		args = append(args, path)
+	}
+	cmd := exec.Command(scanCliJavaPath, args...)
+	cmd.Env = append(cmd.Env, sc.credentialEnv(host)...)
 
+	result, err := sc.runCommand(jobID, cmd, path, statusDir, "scan client")
+	recordTotalScannerDuration(time.Now().Sub(startTotal), err == nil)
+	return result, err
+}
+
+// credentialArgs returns the scan client arguments for authenticating to Black Duck:
+// API tokens take precedence over username and password
+func (sc *ScanClient) credentialArgs(host *Host) []string {
+	if host.Token != "" {
+		return []string{}
+	}
+	return []string{"--username", host.User}
+}
+
+// credentialEnv returns the environment variables holding the Black Duck credentials,
+// which are kept out of the arguments so that they don't show up in the process list
+func (sc *ScanClient) credentialEnv(host *Host) []string {
+	if host.Token != "" {
+		return []string{fmt.Sprintf("BD_HUB_TOKEN=%s", host.Token)}
+	}
+	return []string{fmt.Sprintf("BD_HUB_PASSWORD=%s", host.Password)}
+}
+
+// runCommand runs the scan client, streaming its output into the job's log
+func (sc *ScanClient) runCommand(jobID string, cmd *exec.Cmd, path string, statusDir string, name string) (*ScanResult, error) {
+	var jobLog io.WriteCloser
+	if sc.jobLogs != nil {
+		var err error
+		jobLog, err = sc.jobLogs.Open(jobID)
+		if err != nil {
+			log.Errorf("unable to open log for job %s: %s", jobID, err.Error())
+		} else {
+			defer jobLog.Close()
+		}
+	}
+	output := newScanOutput(jobLog)
+	output.onUpload = func() { sc.setJobStage(jobID, JobStageUploading) }
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	// only the arguments are logged: the environment holds the Black Duck credentials
+	log.Infof("running command %s for path %s, job %s\n", strings.Join(cmd.Args, " "), path, jobID)
 	startScanClient := time.Now()
-	stdoutStderr, err := cmd.CombinedOutput()
+	sc.setJobStage(jobID, JobStageScanning)
+	err := sc.runUntilInterrupted(jobID, cmd)
+	output.flush()
 
 	recordScanClientDuration(time.Now().Sub(startScanClient), err == nil)
-	recordTotalScannerDuration(time.Now().Sub(startTotal), err == nil)
 
+	result := output.result
+	result.mergeStatusDirectory(statusDir)
 	if err != nil {
-		recordScannerError("scan client failed")
-		log.Errorf("java scanner failed for path %s with error %s and output:\n%s\n", path, err.Error(), string(stdoutStderr))
+		recordScannerError(name + " failed")
+		log.Errorf("%s failed for path %s, job %s with error %s and output:\n%s\n", name, path, jobID, err.Error(), output.tailString())
+		return result, errors.Trace(err)
+	}
+	log.Infof("successfully completed %s for path %s, job %s: %+v", name, path, jobID, result)
+	return result, nil
+}
+
+func (sc *ScanClient) setJobStage(jobID string, stage JobStage) {
+	if sc.stageListener != nil {
+		sc.stageListener.SetJobStage(jobID, stage)
+	}
+}
+
+// runUntilInterrupted runs cmd, killing it if the scan client is interrupted first
+func (sc *ScanClient) runUntilInterrupted(jobID string, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
 		return errors.Trace(err)
 	}
-	log.Infof("successfully completed java scanner for path %s", path)
-	log.Debugf("output from path %s: %s", path, stdoutStderr)
-	return nil
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-sc.interrupt:
+		log.Warnf("killing scan client for job %s", jobID)
+		if err := cmd.Process.Kill(); err != nil {
+			log.Errorf("unable to kill scan client for job %s: %s", jobID, err.Error())
+		}
+		<-done
+		return errors.Errorf("scan client for job %s was interrupted", jobID)
+	}
 }
 
 // ScanSh invokes scan.cli.sh
 // example:
 // 	BD_HUB_PASSWORD=??? ./bin/scan.cli.sh --host ??? --port 443 --scheme https --username sysadmin --insecure --name ??? --release ??? --project ??? ???.tar
-func (sc *ScanClient) ScanSh(hubScheme string, host string, port int, username string, password string, path string, projectName string, versionName string, scanName string) error {
-	if err := sc.ensureScanClientIsDownloaded(hubScheme, host, port, username, password); err != nil {
-		return errors.Annotate(err, "cannot run scan.cli.sh")
+func (sc *ScanClient) ScanSh(jobID string, host *Host, path string, projectName string, versionName string, scanName string) (*ScanResult, error) {
+	scanClientInfo, err := sc.ensureScanClientIsDownloaded(host)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot run scan.cli.sh")
 	}
 	startTotal := time.Now()
 
-	cmd := exec.Command(sc.scanClientInfo.ScanCliShPath(),
+	statusDir, err := ioutil.TempDir("", "scanstatus")
+	if err != nil {
+		return nil, errors.Annotate(err, "unable to create scan status directory")
+	}
+	defer os.RemoveAll(statusDir)
+
+	args := []string{
 		"-Xms512m",
 		"-Xmx4096m",
 		"-Dblackduck.scan.cli.benice=true",
@@ -144,31 +303,19 @@ func (sc *ScanClient) ScanSh(hubScheme string, host string, port int, username s
 		"-Done-jar.silent=true",
 		// "-Done-jar.jar.path="+scanCliImplJarPath,
 		// "-jar", scanCliJarPath,
-		"--host", host,
-		"--port", fmt.Sprintf("%d", port),
-		"--scheme", hubScheme,
+		"--host", host.Domain,
+		"--port", fmt.Sprintf("%d", host.Port),
+		"--scheme", host.Scheme,
 		"--project", projectName,
 		"--release", versionName,
-		"--username", username,
 		"--name", scanName,
-		sc.getTLSVerification(),
-		"-v",
-		path)
-	cmd.Env = append(cmd.Env, fmt.Sprintf("BD_HUB_PASSWORD=%s", password))
+		"--statusWriteDir", statusDir}
+	args = append(args, sc.credentialArgs(host)...)
+	args = append(args, sc.getTLSVerification(), "-v", path)
+	cmd := exec.Command(scanClientInfo.ScanCliShPath(), args...)
+	cmd.Env = append(cmd.Env, sc.credentialEnv(host)...)
 
-	log.Infof("running command %+v for path %s\n", cmd, path)
-	startScanClient := time.Now()
-	stdoutStderr, err := cmd.CombinedOutput()
-
-	recordScanClientDuration(time.Now().Sub(startScanClient), err == nil)
+	result, err := sc.runCommand(jobID, cmd, path, statusDir, "scan.cli.sh")
 	recordTotalScannerDuration(time.Now().Sub(startTotal), err == nil)
-
-	if err != nil {
-		recordScannerError("scan.cli.sh failed")
-		log.Errorf("scan.cli.sh failed for path %s with error %s and output:\n%s\n", path, err.Error(), string(stdoutStderr))
-		return errors.Trace(err)
-	}
-	log.Infof("successfully completed scan.cli.sh for path %s", path)
-	log.Debugf("output from path %s: %s", path, stdoutStderr)
-	return nil
+	return result, err
 }
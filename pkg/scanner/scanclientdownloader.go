@@ -27,29 +27,45 @@ import (
 	"time"
 
 	"github.com/blackducksoftware/hub-client-go/hubclient"
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
 	"github.com/juju/errors"
 	log "github.com/sirupsen/logrus"
 )
 
 // DownloadScanClient downloads the Black Duck scan client
-func DownloadScanClient(osType OSType, cliRootPath string, hubScheme string, hubHost string, hubUser string, hubPassword string, hubPort int, timeout time.Duration) (*ScanClientInfo, error) {
-	// 1. instantiate hub client
-	hubBaseURL := fmt.Sprintf("%s://%s:%d", hubScheme, hubHost, hubPort)
-	hubClient, err := hubclient.NewWithSession(hubBaseURL, hubclient.HubClientDebugTimings, timeout)
-	if err != nil {
-		return nil, errors.Annotatef(err, "unable to instantiate hub client")
-	}
-
-	log.Infof("successfully instantiated hub client %s", hubBaseURL)
-
-	// 2. log in to hub client
-	err = hubClient.Login(hubUser, hubPassword)
-	if err != nil {
-		return nil, errors.Annotatef(err, "unable to log in to hub")
+func DownloadScanClient(osType OSType, cliRootPath string, host *Host, tlsVerification bool, timeout time.Duration) (*ScanClientInfo, error) {
+	// 1. instantiate hub client, and
+	// 2. log in to hub client: with an API token if there is one, otherwise with username and password
+	hubBaseURL := fmt.Sprintf("%s://%s:%d", host.Scheme, host.Domain, host.Port)
+	var hubClient *hubclient.Client
+	if host.Token != "" {
+		bearerToken, err := blackDuckBearerTokens.get(hubBaseURL, host.Token, tlsVerification, timeout)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to authenticate to hub with API token")
+		}
+		common.RegisterSecret(bearerToken)
+		hubClient, err = hubclient.NewWithToken(hubBaseURL, bearerToken, hubclient.HubClientDebugTimings, timeout)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to instantiate hub client")
+		}
+		log.Infof("successfully instantiated hub client %s with API token", hubBaseURL)
+	} else {
+		var err error
+		hubClient, err = hubclient.NewWithSession(hubBaseURL, hubclient.HubClientDebugTimings, timeout)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to instantiate hub client")
+		}
+
+		log.Infof("successfully instantiated hub client %s", hubBaseURL)
+
+		err = hubClient.Login(host.User, host.Password)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to log in to hub")
+		}
+
+		log.Info("successfully logged in to hub")
 	}
 
-	log.Info("successfully logged in to hub")
-
 	// 3. get hub version
 	currentVersion, err := hubClient.CurrentVersion()
 	if err != nil {
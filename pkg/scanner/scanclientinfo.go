@@ -21,7 +21,14 @@ under the License.
 
 package scanner
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/errors"
+)
 
 // ScanClientInfo ...
 type ScanClientInfo struct {
@@ -35,6 +42,30 @@ func NewScanClientInfo(hubVersion string, rootPath string, osType OSType) *ScanC
 	return &ScanClientInfo{HubVersion: hubVersion, RootPath: rootPath, OSType: osType}
 }
 
+// FindLocalScanClient returns the scan client that's unpacked in rootPath, in
+// a scan.cli-<version> directory as a download leaves it
+func FindLocalScanClient(rootPath string, osType OSType) (*ScanClientInfo, error) {
+	dirs, err := filepath.Glob(filepath.Join(rootPath, "scan.cli-*"))
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to look for a scan client in %s", rootPath)
+	}
+	found := []*ScanClientInfo{}
+	for _, dir := range dirs {
+		scanClientInfo := NewScanClientInfo(strings.TrimPrefix(filepath.Base(dir), "scan.cli-"), rootPath, osType)
+		if _, err := os.Stat(scanClientInfo.ScanCliJarPath()); err == nil {
+			found = append(found, scanClientInfo)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, errors.NotFoundf("scan client in %s", rootPath)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("found %d scan clients in %s, expected one", len(found), rootPath)
+	}
+}
+
 // ScanCliZipPath ...
 func (sci *ScanClientInfo) ScanCliZipPath() string {
 	return fmt.Sprintf("%s/scanclient.zip", sci.RootPath)
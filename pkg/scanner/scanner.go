@@ -24,7 +24,9 @@ package scanner
 import (
 	"fmt"
 	"os"
+	"strings"
 
+	ifapi "github.com/blackducksoftware/perceptor-scanner/pkg/api"
 	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
 	"github.com/blackducksoftware/perceptor/pkg/api"
 	"github.com/juju/errors"
@@ -36,34 +38,255 @@ type Scanner struct {
 	ifClient       ImageFacadeClientInterface
 	scanClient     ScanClientInterface
 	imageDirectory string
-	stop           <-chan struct{}
+	// platform is pulled from multi-arch images; if it's empty, the image
+	// facade picks its default platform
+	platform string
+	// scanAllPlatforms also scans every other platform of a multi-arch image
+	scanAllPlatforms bool
+	// scanNameMetadata appends the image's platform and ID to its scan name
+	scanNameMetadata bool
+	// downloadTarballs copies tarballs from the image facade into
+	// imageDirectory, instead of reading them from a shared volume
+	downloadTarballs bool
+	// uploader is nil unless offline (dry run) scanning is enabled
+	uploader *Uploader
+	stop     <-chan struct{}
 }
 
 // NewScanner return the Scanner configurations
-func NewScanner(ifClient ImageFacadeClientInterface, scanClient ScanClientInterface, imageDirectory string, stop <-chan struct{}) *Scanner {
+func NewScanner(ifClient ImageFacadeClientInterface, scanClient ScanClientInterface, imageDirectory string, platform string, scanAllPlatforms bool, scanNameMetadata bool, downloadTarballs bool, uploader *Uploader, stop <-chan struct{}) *Scanner {
 	return &Scanner{
-		ifClient:       ifClient,
-		scanClient:     scanClient,
-		imageDirectory: imageDirectory,
-		stop:           stop}
+		ifClient:         ifClient,
+		scanClient:       scanClient,
+		imageDirectory:   imageDirectory,
+		platform:         platform,
+		scanAllPlatforms: scanAllPlatforms,
+		scanNameMetadata: scanNameMetadata,
+		downloadTarballs: downloadTarballs,
+		uploader:         uploader,
+		stop:             stop}
+}
+
+// PulledImage describes the image that the image facade pulled for a job
+type PulledImage struct {
+	PullSpec string
+	// Digest is what the pull spec resolved to, such as sha256:abc...; it's
+	// empty if the image facade couldn't find out
+	Digest string
+	// Platform and ManifestDigest identify the platform's image that was
+	// scanned, if the image facade could read the image's manifest
+	Platform       string
+	ManifestDigest string
+	// PlatformScans are the scans of a multi-arch image's other platforms
+	PlatformScans []*PlatformScan
+	// Metadata is the image's config and layers; it's nil if the image
+	// facade couldn't read them
+	Metadata *ifapi.InspectImageResponse
+}
+
+// PlatformScan is the scan of one of a multi-arch image's other platforms.
+// Its failure doesn't fail the job.
+type PlatformScan struct {
+	Platform       string
+	ManifestDigest string `json:",omitempty"`
+	ScanName       string
+	ScanResult     *ScanResult `json:",omitempty"`
+	Err            string      `json:",omitempty"`
+}
+
+// String ...
+func (platformScan *PlatformScan) String() string {
+	return fmt.Sprintf("{Platform:%s ManifestDigest:%s ScanName:%s ScanResult:%+v Err:%s}",
+		platformScan.Platform, platformScan.ManifestDigest, platformScan.ScanName, platformScan.ScanResult, platformScan.Err)
 }
 
 // ScanFullDockerImage runs the scan client on a full tar from 'docker export'
-func (scanner *Scanner) ScanFullDockerImage(apiImage *api.ImageSpec) error {
-	pullSpec := fmt.Sprintf("%s@sha256:%s", apiImage.Repository, apiImage.Sha)
-	image := common.NewImage(scanner.imageDirectory, pullSpec)
-	err := scanner.ifClient.PullImage(image)
+func (scanner *Scanner) ScanFullDockerImage(jobID string, host *Host, apiImage *api.ImageSpec) (*ScanResult, *PulledImage, error) {
+	image := scanner.image(apiImage, scanner.platform)
+	response, err := scanner.ifClient.PullImage(image)
+	if err != nil {
+		scanner.cleanUpPartialTarball(image)
+		return nil, nil, errors.Trace(err)
+	}
+	pulledImage := &PulledImage{
+		PullSpec:       image.PullSpec,
+		Digest:         response.Digest,
+		Platform:       response.Platform,
+		ManifestDigest: response.ManifestDigest}
+	scanName := apiImage.BlackDuckScanName
+	pulledImage.Metadata, err = scanner.ifClient.InspectImage(image)
+	if err != nil {
+		log.Warnf("unable to inspect image %s: %s", image.PullSpec, err.Error())
+	} else if scanner.scanNameMetadata {
+		scanName = scanNameWithMetadata(scanName, pulledImage.Metadata)
+	}
+	result, err := scanner.scanImage(jobID, host, image, apiImage, scanName)
+	if err != nil || !scanner.scanAllPlatforms {
+		return result, pulledImage, err
+	}
+	for _, platform := range response.Platforms {
+		if platform == response.Platform {
+			continue
+		}
+		pulledImage.PlatformScans = append(pulledImage.PlatformScans, scanner.scanPlatform(jobID, host, apiImage, platform))
+	}
+	return result, pulledImage, nil
+}
+
+// scanPlatform pulls and scans another platform of a multi-arch image, under
+// its own scan name
+func (scanner *Scanner) scanPlatform(jobID string, host *Host, apiImage *api.ImageSpec, platform string) *PlatformScan {
+	image := scanner.image(apiImage, platform)
+	platformScan := &PlatformScan{Platform: platform, ScanName: platformScanName(apiImage.BlackDuckScanName, platform)}
+	log.Infof("scanning platform %s of %s as %s", platform, image.PullSpec, platformScan.ScanName)
+	response, err := scanner.ifClient.PullImage(image)
 	if err != nil {
+		scanner.cleanUpPartialTarball(image)
+		log.Errorf("unable to pull platform %s of %s: %s", platform, image.PullSpec, err.Error())
+		platformScan.Err = err.Error()
+		return platformScan
+	}
+	platformScan.ManifestDigest = response.ManifestDigest
+	platformScan.ScanResult, err = scanner.scanImage(jobID, host, image, apiImage, platformScan.ScanName)
+	if err != nil {
+		log.Errorf("unable to scan platform %s of %s: %s", platform, image.PullSpec, err.Error())
+		platformScan.Err = err.Error()
+	}
+	return platformScan
+}
+
+// scanImage scans a pulled image's tarball, then removes it
+func (scanner *Scanner) scanImage(jobID string, host *Host, image *common.Image, apiImage *api.ImageSpec, scanName string) (*ScanResult, error) {
+	path, err := scanner.fetchTarball(image)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer scanner.releaseTarball(image, path)
+	if scanner.uploader != nil {
+		return scanner.DryRunScanFile(jobID, host, path, apiImage.BlackDuckProjectName, apiImage.BlackDuckProjectVersionName, scanName)
+	}
+	return scanner.ScanFile(jobID, host, path, apiImage.BlackDuckProjectName, apiImage.BlackDuckProjectVersionName, scanName)
+}
+
+// fetchTarball returns the path of a pulled image's tarball: where the image
+// facade wrote it, or where it's been downloaded to.  Once all of it's been
+// downloaded, the image facade's copy is removed.
+func (scanner *Scanner) fetchTarball(image *common.Image) (string, error) {
+	if !scanner.downloadTarballs {
+		return image.DockerTarFilePath(), nil
+	}
+	local := *image
+	local.Directory = scanner.imageDirectory
+	path := local.DockerTarFilePath()
+	err := scanner.ifClient.DownloadTarball(image, path)
+	if err != nil {
+		// the image facade's copy is kept, for the job to be retried
+		cleanUpFile(path)
+		return "", errors.Trace(err)
+	}
+	if err = scanner.ifClient.RemoveTarball(image); err != nil {
+		log.Warnf("unable to remove tarball of %s from the image facade: %s", image.PullSpec, err.Error())
+	}
+	return path, nil
+}
+
+// releaseTarball removes a downloaded tarball.  A tarball in the shared image
+// directory may be scanned by other scanners that asked for the same image,
+// so the image facade removes it once they're all done.  If the image facade
+// can't release it, for instance because it's restarted and lost track of
+// it, it's removed here so that it doesn't fill up the shared volume.
+func (scanner *Scanner) releaseTarball(image *common.Image, path string) {
+	if scanner.downloadTarballs {
+		cleanUpFile(path)
+		return
+	}
+	if err := scanner.ifClient.RemoveTarball(image); err != nil {
+		log.Warnf("unable to release tarball of %s to the image facade, removing it: %s", image.PullSpec, err.Error())
+		cleanUpFile(path)
+	}
+}
+
+// cleanUpPartialTarball removes what a failed pull left in the shared image
+// directory; the image facade cleans up its own
+func (scanner *Scanner) cleanUpPartialTarball(image *common.Image) {
+	if !scanner.downloadTarballs {
 		cleanUpFile(image.DockerTarFilePath())
-		return errors.Trace(err)
 	}
-	defer cleanUpFile(image.DockerTarFilePath())
-	return scanner.ScanFile(apiImage.Scheme, apiImage.Domain, apiImage.Port, apiImage.User, apiImage.Password, image.DockerTarFilePath(), apiImage.BlackDuckProjectName, apiImage.BlackDuckProjectVersionName, apiImage.BlackDuckScanName)
+}
+
+// platformScanName keeps the scans of a multi-arch image's platforms apart in
+// the same project version, such as my-scan-linux-arm64-v8
+func platformScanName(scanName string, platform string) string {
+	return fmt.Sprintf("%s-%s", scanName, strings.Replace(platform, "/", "-", -1))
+}
+
+// scanNameWithMetadata tells images apart in Black Duck by what they are, as
+// in my-scan (linux/arm64/v8 0a1b2c3d4e5f), rather than only by where they
+// were pulled from
+func scanNameWithMetadata(scanName string, metadata *ifapi.InspectImageResponse) string {
+	platform := common.Platform{OS: metadata.OS, Architecture: metadata.Architecture, Variant: metadata.Variant}
+	id := strings.TrimPrefix(metadata.ID, "sha256:")
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return fmt.Sprintf("%s (%s %s)", scanName, platform.String(), id)
+}
+
+// image returns the image that the image facade pulls for a job.  Images
+// that are downloaded don't name a directory: the image facade writes them
+// to its own.
+func (scanner *Scanner) image(apiImage *api.ImageSpec, platform string) *common.Image {
+	directory := scanner.imageDirectory
+	if scanner.downloadTarballs {
+		directory = ""
+	}
+	image := common.NewImage(directory, pullSpec(apiImage))
+	image.Platform = platform
+	image.Priority = apiImage.Priority
+	return image
+}
+
+// pullSpec prefers pulling by digest.  Images that perceptor only knows by tag
+// are pulled by tag, and the image facade resolves the tag to a digest.
+func pullSpec(apiImage *api.ImageSpec) string {
+	switch {
+	case apiImage.Sha != "":
+		return fmt.Sprintf("%s@sha256:%s", apiImage.Repository, apiImage.Sha)
+	case apiImage.Tag != "":
+		return fmt.Sprintf("%s:%s", apiImage.Repository, apiImage.Tag)
+	default:
+		return apiImage.Repository
+	}
 }
 
 // ScanFile runs the scan client against a single file
-func (scanner *Scanner) ScanFile(scheme string, host string, port int, username string, password string, path string, blackDuckProjectName string, blackDuckVersionName string, blackDuckScanName string) error {
-	return scanner.scanClient.Scan(scheme, host, port, username, password, path, blackDuckProjectName, blackDuckVersionName, blackDuckScanName)
+func (scanner *Scanner) ScanFile(jobID string, host *Host, path string, blackDuckProjectName string, blackDuckVersionName string, blackDuckScanName string) (*ScanResult, error) {
+	return scanner.scanClient.Scan(jobID, host, path, blackDuckProjectName, blackDuckVersionName, blackDuckScanName)
+}
+
+// DryRunScanFile runs the scan client against a single file without contacting
+// Black Duck, and queues the results for upload
+func (scanner *Scanner) DryRunScanFile(jobID string, host *Host, path string, blackDuckProjectName string, blackDuckVersionName string, blackDuckScanName string) (*ScanResult, error) {
+	dryRunDirectory, err := scanner.uploader.NewDryRunDirectory()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	result, err := scanner.scanClient.DryRunScan(jobID, host, path, blackDuckProjectName, blackDuckVersionName, blackDuckScanName, dryRunDirectory)
+	if err != nil {
+		os.RemoveAll(dryRunDirectory)
+		return result, errors.Trace(err)
+	}
+	err = scanner.uploader.Enqueue(&PendingUpload{
+		JobID:           jobID,
+		Host:            host,
+		ScanName:        blackDuckScanName,
+		DryRunDirectory: dryRunDirectory})
+	if err != nil {
+		os.RemoveAll(dryRunDirectory)
+		return result, errors.Annotatef(err, "unable to queue dry run scan of %s for upload", path)
+	}
+	result.UploadStatus = uploadStatusPending
+	return result, nil
 }
 
 // cleanUpFile cleans up the file that is locally pulled for scanning
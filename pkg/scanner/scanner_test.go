@@ -0,0 +1,223 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	ifapi "github.com/blackducksoftware/perceptor-scanner/pkg/api"
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+	"github.com/blackducksoftware/perceptor/pkg/api"
+)
+
+type fakeImageFacadeClient struct {
+	platforms  []string
+	failures   map[string]bool
+	pulled     []*common.Image
+	downloaded []string
+	removed    []*common.Image
+	// downloadErr fails every download
+	downloadErr error
+	// removeErr fails every removal
+	removeErr error
+}
+
+func (client *fakeImageFacadeClient) PullImage(image *common.Image) (*ifapi.CheckImageResponse, error) {
+	client.pulled = append(client.pulled, image)
+	if client.failures[image.Platform] {
+		return nil, fmt.Errorf("unable to pull platform %s", image.Platform)
+	}
+	platform := image.Platform
+	if platform == "" {
+		platform = "linux/amd64"
+	}
+	return &ifapi.CheckImageResponse{
+		PullSpec:       image.PullSpec,
+		ImageStatus:    common.ImageStatusDone,
+		Digest:         "sha256:list",
+		Platform:       platform,
+		ManifestDigest: "sha256:" + platform,
+		Platforms:      client.platforms}, nil
+}
+
+func (client *fakeImageFacadeClient) InspectImage(image *common.Image) (*ifapi.InspectImageResponse, error) {
+	platform, err := common.ParsePlatform(image.Platform)
+	if err != nil {
+		return nil, err
+	}
+	return &ifapi.InspectImageResponse{
+		PullSpec:     image.PullSpec,
+		ID:           "sha256:0123456789abcdef",
+		OS:           platform.OS,
+		Architecture: platform.Architecture,
+		Variant:      platform.Variant}, nil
+}
+
+func (client *fakeImageFacadeClient) DownloadTarball(image *common.Image, path string) error {
+	client.downloaded = append(client.downloaded, path)
+	return client.downloadErr
+}
+
+func (client *fakeImageFacadeClient) RemoveTarball(image *common.Image) error {
+	client.removed = append(client.removed, image)
+	return client.removeErr
+}
+
+type fakeScanClient struct {
+	scanNames []string
+	paths     []string
+}
+
+func (client *fakeScanClient) Scan(jobID string, host *Host, path string, projectName string, versionName string, scanName string) (*ScanResult, error) {
+	client.scanNames = append(client.scanNames, scanName)
+	client.paths = append(client.paths, path)
+	return &ScanResult{CodeLocationName: scanName}, nil
+}
+
+func (client *fakeScanClient) DryRunScan(jobID string, host *Host, path string, projectName string, versionName string, scanName string, dryRunDirectory string) (*ScanResult, error) {
+	return nil, fmt.Errorf("dry run scans aren't supported")
+}
+
+func (client *fakeScanClient) UploadDryRun(jobID string, host *Host, dryRunFile string) (*ScanResult, error) {
+	return nil, fmt.Errorf("dry run scans aren't supported")
+}
+
+func TestScanFullDockerImagePlatforms(t *testing.T) {
+	apiImage := &api.ImageSpec{Repository: "nginx", Tag: "1.15", BlackDuckScanName: "nginx-scan"}
+	platforms := []string{"linux/amd64", "linux/arm64/v8", "linux/s390x"}
+
+	ifClient := &fakeImageFacadeClient{platforms: platforms}
+	scanClient := &fakeScanClient{}
+	scanner := NewScanner(ifClient, scanClient, "/var/images", "", false, false, false, nil, make(chan struct{}))
+	_, pulledImage, err := scanner.ScanFullDockerImage("job", nil, apiImage)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if pulledImage.Platform != "linux/amd64" || pulledImage.ManifestDigest != "sha256:linux/amd64" || len(pulledImage.PlatformScans) != 0 {
+		t.Errorf("unexpected pulled image %+v", pulledImage)
+	}
+	if pulledImage.Metadata != nil {
+		t.Errorf("expected no metadata for an image that couldn't be inspected, got %+v", pulledImage.Metadata)
+	}
+	if len(ifClient.pulled) != 1 {
+		t.Errorf("expected 1 pull without ScanAllPlatforms, got %d", len(ifClient.pulled))
+	}
+	// the tarball in the shared directory is released to the image facade,
+	// which removes it once every scanner that asked for it is done
+	if len(ifClient.removed) != 1 {
+		t.Errorf("expected the shared tarball to be released, got %d releases", len(ifClient.removed))
+	}
+
+	ifClient = &fakeImageFacadeClient{platforms: platforms, failures: map[string]bool{"linux/s390x": true}}
+	scanClient = &fakeScanClient{}
+	scanner = NewScanner(ifClient, scanClient, "/var/images", "linux/arm64/v8", true, true, false, nil, make(chan struct{}))
+	_, pulledImage, err = scanner.ScanFullDockerImage("job", nil, apiImage)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if pulledImage.Platform != "linux/arm64/v8" {
+		t.Errorf("expected the selected platform to be scanned, got %s", pulledImage.Platform)
+	}
+	if pulledImage.Metadata == nil || pulledImage.Metadata.Architecture != "arm64" {
+		t.Errorf("unexpected metadata %+v", pulledImage.Metadata)
+	}
+	expectedScanNames := []string{"nginx-scan (linux/arm64/v8 0123456789ab)", "nginx-scan-linux-amd64"}
+	if !reflect.DeepEqual(scanClient.scanNames, expectedScanNames) {
+		t.Errorf("expected scans %v, got %v", expectedScanNames, scanClient.scanNames)
+	}
+	if len(pulledImage.PlatformScans) != 2 {
+		t.Fatalf("expected 2 platform scans, got %d", len(pulledImage.PlatformScans))
+	}
+	s390x := pulledImage.PlatformScans[1]
+	if s390x.Platform != "linux/s390x" || s390x.Err == "" || s390x.ScanResult != nil {
+		t.Errorf("expected failed s390x scan, got %s", s390x)
+	}
+	amd64 := pulledImage.PlatformScans[0]
+	if amd64.ManifestDigest != "sha256:linux/amd64" || amd64.ScanResult.CodeLocationName != "nginx-scan-linux-amd64" {
+		t.Errorf("unexpected amd64 scan %s", amd64)
+	}
+}
+
+func TestScanFullDockerImageDownloadsTarball(t *testing.T) {
+	apiImage := &api.ImageSpec{Repository: "nginx", Tag: "1.15", BlackDuckScanName: "nginx-scan", Priority: 2}
+	ifClient := &fakeImageFacadeClient{}
+	scanClient := &fakeScanClient{}
+	scanner := NewScanner(ifClient, scanClient, "/var/scratch", "", false, false, true, nil, make(chan struct{}))
+	if _, _, err := scanner.ScanFullDockerImage("job", nil, apiImage); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ifClient.pulled[0].Directory != "" {
+		t.Errorf("expected the image facade to pick the directory, got %s", ifClient.pulled[0].Directory)
+	}
+	if ifClient.pulled[0].Priority != 2 {
+		t.Errorf("expected the job's priority to be passed on, got %d", ifClient.pulled[0].Priority)
+	}
+	if !reflect.DeepEqual(ifClient.downloaded, []string{"/var/scratch/nginx_1.15.tar"}) || !reflect.DeepEqual(scanClient.paths, ifClient.downloaded) {
+		t.Errorf("expected the downloaded tarball to be scanned, downloaded %v, scanned %v", ifClient.downloaded, scanClient.paths)
+	}
+	if len(ifClient.removed) != 1 {
+		t.Errorf("expected the image facade's tarball to be removed, got %d removals", len(ifClient.removed))
+	}
+
+	// a failed download leaves the image facade's tarball where it is
+	ifClient = &fakeImageFacadeClient{downloadErr: fmt.Errorf("connection reset")}
+	scanner = NewScanner(ifClient, &fakeScanClient{}, "/var/scratch", "", false, false, true, nil, make(chan struct{}))
+	if _, _, err := scanner.ScanFullDockerImage("job", nil, apiImage); err == nil {
+		t.Errorf("expected error for a failed download")
+	}
+	if len(ifClient.removed) != 0 {
+		t.Errorf("expected the image facade's tarball to be kept, got %d removals", len(ifClient.removed))
+	}
+}
+
+func TestReleaseTarballFallsBackToRemovingIt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "release-tarball")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	image := common.NewImage(dir, "nginx:1.15")
+	path := filepath.Join(dir, "nginx_1.15.tar")
+
+	// the image facade removes a released tarball itself
+	ifClient := &fakeImageFacadeClient{}
+	scanner := NewScanner(ifClient, &fakeScanClient{}, dir, "", false, false, false, nil, make(chan struct{}))
+	if err = ioutil.WriteFile(path, []byte("tarball"), 0644); err != nil {
+		t.Fatalf("unable to write tarball: %s", err.Error())
+	}
+	scanner.releaseTarball(image, path)
+	if _, err = os.Stat(path); err != nil {
+		t.Errorf("expected the image facade to be left to remove %s, got %s", path, err.Error())
+	}
+
+	// one that's lost track of it, for instance after a restart, doesn't
+	ifClient.removeErr = fmt.Errorf("DELETE tarball of nginx:1.15 failed with status code 404")
+	scanner.releaseTarball(image, path)
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", path, err)
+	}
+}
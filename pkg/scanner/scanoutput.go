@@ -0,0 +1,101 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const scanOutputTailLines = 100
+
+// the scan client logs this at INFO, after any timestamp, once it's done
+// scanning and starts sending results to Black Duck.  Other lines mention
+// uploads too, such as its settings, so nothing else counts.
+var uploadStartedRegexp = regexp.MustCompile(`^(?:\S+\s+)*INFO:?\s+Starting upload of scan results\b`)
+
+// scanOutput receives the scan client's stdout and stderr as they're written.
+// Each complete line is written to the job's log and parsed into the scan
+// result; the last few lines are kept for error messages.
+type scanOutput struct {
+	jobLog  io.Writer
+	partial []byte
+	result  *ScanResult
+	tail    []string
+	// onUpload, if set, is called when the scan client starts uploading
+	onUpload  func()
+	uploading bool
+}
+
+func newScanOutput(jobLog io.Writer) *scanOutput {
+	return &scanOutput{jobLog: jobLog, result: &ScanResult{}}
+}
+
+func (so *scanOutput) Write(p []byte) (int, error) {
+	so.partial = append(so.partial, p...)
+	for {
+		index := bytes.IndexByte(so.partial, '\n')
+		if index < 0 {
+			break
+		}
+		so.addLine(string(so.partial[:index]))
+		so.partial = so.partial[index+1:]
+	}
+	return len(p), nil
+}
+
+// flush handles a last line which wasn't terminated by a newline
+func (so *scanOutput) flush() {
+	if len(so.partial) > 0 {
+		so.addLine(string(so.partial))
+		so.partial = nil
+	}
+}
+
+func (so *scanOutput) addLine(line string) {
+	line = strings.TrimRight(line, "\r")
+	if so.jobLog != nil {
+		if _, err := io.WriteString(so.jobLog, line+"\n"); err != nil {
+			log.Errorf("unable to write to job log: %s", err.Error())
+		}
+	}
+	so.result.parseLine(line)
+	if !so.uploading && uploadStartedRegexp.MatchString(line) {
+		so.uploading = true
+		if so.onUpload != nil {
+			so.onUpload()
+		}
+	}
+	so.tail = append(so.tail, line)
+	if len(so.tail) > scanOutputTailLines {
+		so.tail = so.tail[1:]
+	}
+}
+
+// tailString returns the last lines of output
+func (so *scanOutput) tailString() string {
+	return strings.Join(so.tail, "\n")
+}
@@ -0,0 +1,143 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ScanResult describes what the scan client reported about a single scan
+type ScanResult struct {
+	CodeLocationName string
+	ScanID           string
+	FileCount        int
+	DirectoryCount   int
+	UploadStatus     string
+}
+
+// scanStatus is the subset of the scan client's status file
+// (written with --statusWriteDir) that we care about
+type scanStatus struct {
+	Name           string `json:"name"`
+	ScanID         string `json:"scanId"`
+	Status         string `json:"status"`
+	FileCount      *int   `json:"numberOfFiles"`
+	DirectoryCount *int   `json:"numberOfDirectories"`
+}
+
+var (
+	scanIDRegexp           = regexp.MustCompile(`(?i)\bscan\s*id\s*[:=]\s*'?([\w-]+)'?`)
+	codeLocationNameRegexp = regexp.MustCompile(`(?i)\bcode\s*location(?:\s*name)?\s*[:=]\s*'?([^'\r\n]+?)'?\s*$`)
+	fileCountRegexp        = regexp.MustCompile(`(?i)\b(?:total\s+)?(?:number\s+of\s+)?files(?:\s+scanned)?\s*[:=]\s*(\d+)`)
+	directoryCountRegexp   = regexp.MustCompile(`(?i)\b(?:total\s+)?(?:number\s+of\s+)?directories(?:\s+scanned)?\s*[:=]\s*(\d+)`)
+	uploadStatusRegexp     = regexp.MustCompile(`(?i)\b(?:upload|post\s+scan)\s+(?:status|result)\s*[:=]\s*'?(\w+)'?`)
+)
+
+// parseLine updates the result from a line of the scan client's output.
+// Lines which don't match any known pattern are ignored; for repeated
+// matches, the last one wins.
+func (result *ScanResult) parseLine(line string) {
+	if match := scanIDRegexp.FindStringSubmatch(line); match != nil {
+		result.ScanID = match[1]
+	}
+	if match := codeLocationNameRegexp.FindStringSubmatch(line); match != nil {
+		result.CodeLocationName = match[1]
+	}
+	if match := fileCountRegexp.FindStringSubmatch(line); match != nil {
+		result.FileCount, _ = strconv.Atoi(match[1])
+	}
+	if match := directoryCountRegexp.FindStringSubmatch(line); match != nil {
+		result.DirectoryCount, _ = strconv.Atoi(match[1])
+	}
+	if match := uploadStatusRegexp.FindStringSubmatch(line); match != nil {
+		result.UploadStatus = match[1]
+	}
+}
+
+// parseScanClientOutput extracts a ScanResult from the scan client's stdout/stderr
+func parseScanClientOutput(output string) *ScanResult {
+	result := &ScanResult{}
+	for _, line := range strings.Split(output, "\n") {
+		result.parseLine(line)
+	}
+	return result
+}
+
+// readScanStatusDirectory reads the first status file found in the directory
+// handed to the scan client via --statusWriteDir
+func readScanStatusDirectory(dir string) (*scanStatus, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to list status files in %s", dir)
+	}
+	if len(paths) == 0 {
+		return nil, errors.Errorf("no status file found in %s", dir)
+	}
+	bytes, err := ioutil.ReadFile(paths[0])
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to read status file %s", paths[0])
+	}
+	var status scanStatus
+	err = json.Unmarshal(bytes, &status)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to unmarshal status file %s", paths[0])
+	}
+	return &status, nil
+}
+
+// merge overwrites the fields of the result with those found in the status file,
+// which is more reliable than the scan client's log output
+func (result *ScanResult) merge(status *scanStatus) {
+	if status.Name != "" {
+		result.CodeLocationName = status.Name
+	}
+	if status.ScanID != "" {
+		result.ScanID = status.ScanID
+	}
+	if status.Status != "" {
+		result.UploadStatus = status.Status
+	}
+	if status.FileCount != nil {
+		result.FileCount = *status.FileCount
+	}
+	if status.DirectoryCount != nil {
+		result.DirectoryCount = *status.DirectoryCount
+	}
+}
+
+// mergeStatusDirectory merges in the scan client's status file, if it wrote one
+func (result *ScanResult) mergeStatusDirectory(statusDir string) {
+	status, err := readScanStatusDirectory(statusDir)
+	if err != nil {
+		log.Debugf("unable to read scan status: %s", err.Error())
+		return
+	}
+	result.merge(status)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var sampleScanClientOutput = `2018-10-19 14:03:11 INFO  [main] --- Scan target: /var/images/alpine.tar
+2018-10-19 14:03:12 INFO  [main] --- Code location name: 'alpine-3.8-scan'
+2018-10-19 14:03:40 INFO  [main] --- Total number of files: 1432
+2018-10-19 14:03:40 INFO  [main] --- Total number of directories: 87
+2018-10-19 14:03:41 INFO  [main] --- Scan ID: 'a3f94f0c-41a3-4a7d-9c3e-7d3d3d2c9e55'
+2018-10-19 14:03:45 INFO  [main] --- Upload status: SUCCESS
+`
+
+func TestParseScanClientOutput(t *testing.T) {
+	result := parseScanClientOutput(sampleScanClientOutput)
+	expected := ScanResult{
+		CodeLocationName: "alpine-3.8-scan",
+		ScanID:           "a3f94f0c-41a3-4a7d-9c3e-7d3d3d2c9e55",
+		FileCount:        1432,
+		DirectoryCount:   87,
+		UploadStatus:     "SUCCESS",
+	}
+	if *result != expected {
+		t.Errorf("expected %+v, got %+v", expected, *result)
+	}
+}
+
+func TestScanStatusFileOverridesOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scanresulttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	status := `{"name": "from-status-file", "scanId": "123", "status": "FAILURE", "numberOfFiles": 5}`
+	err = ioutil.WriteFile(filepath.Join(dir, "status.json"), []byte(status), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := parseScanClientOutput(sampleScanClientOutput)
+	result.mergeStatusDirectory(dir)
+	expected := ScanResult{
+		CodeLocationName: "from-status-file",
+		ScanID:           "123",
+		FileCount:        5,
+		DirectoryCount:   87,
+		UploadStatus:     "FAILURE",
+	}
+	if *result != expected {
+		t.Errorf("expected %+v, got %+v", expected, *result)
+	}
+}
+
+func TestScanOutputStreamsLines(t *testing.T) {
+	jobLog := &bytes.Buffer{}
+	output := newScanOutput(jobLog)
+	// the scan client's output arrives in arbitrary chunks
+	for i := 0; i < len(sampleScanClientOutput); i += 7 {
+		end := i + 7
+		if end > len(sampleScanClientOutput) {
+			end = len(sampleScanClientOutput)
+		}
+		output.Write([]byte(sampleScanClientOutput[i:end]))
+	}
+	output.Write([]byte("no trailing newline"))
+	output.flush()
+
+	if jobLog.String() != sampleScanClientOutput+"no trailing newline\n" {
+		t.Errorf("unexpected job log contents:\n%s", jobLog.String())
+	}
+	if *output.result != *parseScanClientOutput(sampleScanClientOutput) {
+		t.Errorf("expected streamed result to match parsed result, got %+v", *output.result)
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	uploadPause          = 30 * time.Second
+	uploadInitialBackoff = 30 * time.Second
+	uploadMaxBackoff     = 30 * time.Minute
+
+	uploadStatusPending = "PENDING"
+)
+
+// PendingUpload is a dry run scan whose results haven't been uploaded to Black Duck yet
+type PendingUpload struct {
+	JobID string
+	// Host only says where to upload to: its credentials aren't persisted, but
+	// looked up when it's uploaded
+	Host            *Host
+	ScanName        string
+	DryRunDirectory string
+	Created         time.Time
+	Attempts        int
+	LastError       string
+}
+
+// Uploader uploads the results of dry run scans to Black Duck, retrying with
+// exponential backoff until Black Duck is reachable.  Pending uploads are
+// persisted, so that they survive restarts.
+type Uploader struct {
+	directory  string
+	queue      *fileQueue
+	scanClient ScanClientInterface
+	maxBytes   int64
+	maxPending int
+	// credentials returns a host with its current credentials; it must be
+	// set before the uploader is started
+	credentials func(host *Host) (*Host, error)
+	mutex       sync.Mutex
+	stop        <-chan struct{}
+}
+
+// NewUploader ...
+func NewUploader(directory string, maxMegabytes int, maxPending int, scanClient ScanClientInterface, stop <-chan struct{}) (*Uploader, error) {
+	queue, err := newFileQueue(filepath.Join(directory, "queue"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	err = os.MkdirAll(filepath.Join(directory, "data"), 0700)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create dry run data directory in %s", directory)
+	}
+	uploader := &Uploader{
+		directory:  directory,
+		queue:      queue,
+		scanClient: scanClient,
+		maxBytes:   int64(maxMegabytes) * 1024 * 1024,
+		maxPending: maxPending,
+		stop:       stop}
+	recordPendingUploads(uploader.PendingCount())
+	return uploader, nil
+}
+
+// Start uploads pending scans in the background, until stop is closed
+func (u *Uploader) Start() {
+	log.Infof("starting to upload dry run scans from %s", u.directory)
+	go func() {
+		b := newBackoff(uploadInitialBackoff, uploadMaxBackoff)
+		pause := uploadPause
+		for {
+			select {
+			case <-u.stop:
+				return
+			case <-time.After(pause):
+				pause = u.uploadPendingWithBackoff(b)
+			}
+		}
+	}()
+}
+
+// uploadPendingWithBackoff uploads pending scans, and returns how long to
+// pause before the next attempt: longer after each failure in a row
+func (u *Uploader) uploadPendingWithBackoff(b *backoff) time.Duration {
+	if err := u.uploadPending(); err != nil {
+		pause := b.next()
+		log.Errorf("unable to upload dry run scans, retrying in %s: %s", pause, err.Error())
+		return pause
+	}
+	b.reset()
+	return uploadPause
+}
+
+// NewDryRunDirectory creates a directory for the scan client to write a dry run scan to
+func (u *Uploader) NewDryRunDirectory() (string, error) {
+	dir, err := ioutil.TempDir(filepath.Join(u.directory, "data"), "scan")
+	return dir, errors.Annotatef(err, "unable to create dry run directory")
+}
+
+// Enqueue persists an upload, without its host's credentials, unless that
+// would exceed the configured disk caps
+func (u *Uploader) Enqueue(upload *PendingUpload) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	names, err := u.queue.names()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(names) >= u.maxPending {
+		recordScannerError("too many pending uploads")
+		return errors.Errorf("unable to queue upload for %s: %d uploads already pending", upload.ScanName, len(names))
+	}
+	size, err := directorySize(u.directory)
+	if err != nil {
+		return errors.Annotatef(err, "unable to get size of %s", u.directory)
+	}
+	if size > u.maxBytes {
+		recordScannerError("pending uploads exceed disk cap")
+		return errors.Errorf("unable to queue upload for %s: pending uploads use %d bytes, cap is %d", upload.ScanName, size, u.maxBytes)
+	}
+
+	upload.Created = time.Now()
+	upload.Host = &Host{Scheme: upload.Host.Scheme, Domain: upload.Host.Domain, Port: upload.Host.Port}
+	_, err = u.queue.push(upload)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	recordPendingUploads(len(names) + 1)
+	log.Infof("queued upload of dry run scan %s from %s", upload.ScanName, upload.DryRunDirectory)
+	return nil
+}
+
+// PendingCount returns the number of scans waiting to be uploaded
+func (u *Uploader) PendingCount() int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	names, err := u.queue.names()
+	if err != nil {
+		log.Errorf("unable to count pending uploads: %s", err.Error())
+		return 0
+	}
+	return len(names)
+}
+
+// uploadPending uploads pending scans, oldest first.  It gives up at the first
+// failure, since the most likely cause is that Black Duck is still unreachable.
+func (u *Uploader) uploadPending() error {
+	u.mutex.Lock()
+	names, err := u.queue.names()
+	u.mutex.Unlock()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, name := range names {
+		select {
+		case <-u.stop:
+			return nil
+		default:
+		}
+		var upload PendingUpload
+		err = u.queue.read(name, &upload)
+		if err != nil {
+			// nothing we can do with an unreadable entry -- don't let it block the rest
+			log.Errorf("dropping unreadable pending upload %s: %s", name, err.Error())
+			u.remove(name, &upload)
+			continue
+		}
+		err = u.upload(&upload)
+		recordUploadResult(err == nil)
+		if err != nil {
+			upload.Attempts++
+			upload.LastError = err.Error()
+			if updateErr := u.queue.update(name, &upload); updateErr != nil {
+				log.Errorf("unable to update pending upload %s: %s", name, updateErr.Error())
+			}
+			return errors.Annotatef(err, "unable to upload %s after %d attempts", upload.ScanName, upload.Attempts)
+		}
+		log.Infof("uploaded dry run scan %s, queued at %s", upload.ScanName, upload.Created)
+		u.remove(name, &upload)
+	}
+	return nil
+}
+
+// upload uploads each results file written by the scan client
+func (u *Uploader) upload(upload *PendingUpload) error {
+	files, err := filepath.Glob(filepath.Join(upload.DryRunDirectory, "*.json"))
+	if err != nil {
+		return errors.Annotatef(err, "unable to list dry run files in %s", upload.DryRunDirectory)
+	}
+	if len(files) == 0 {
+		// either the scan client didn't write anything, or everything was
+		// uploaded right before a restart -- in both cases, we're done
+		log.Warnf("no dry run files left in %s", upload.DryRunDirectory)
+		return nil
+	}
+	host, err := u.credentials(upload.Host)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, file := range files {
+		_, err = u.scanClient.UploadDryRun(upload.JobID+"-upload", host, file)
+		if err != nil {
+			return errors.Annotatef(err, "unable to upload %s", file)
+		}
+		// don't upload this file again if a later one fails
+		os.Remove(file)
+	}
+	return nil
+}
+
+func (u *Uploader) remove(name string, upload *PendingUpload) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if upload.DryRunDirectory != "" {
+		if err := os.RemoveAll(upload.DryRunDirectory); err != nil {
+			log.Errorf("unable to remove dry run directory %s: %s", upload.DryRunDirectory, err.Error())
+		}
+	}
+	if err := u.queue.remove(name); err != nil {
+		log.Errorf("unable to remove pending upload %s: %s", name, err.Error())
+	}
+	if names, err := u.queue.names(); err == nil {
+		recordPendingUploads(len(names))
+	}
+}
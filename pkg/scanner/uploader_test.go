@@ -0,0 +1,219 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package scanner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeUploadScanClient uploads dry run files, unless Black Duck is down
+type fakeUploadScanClient struct {
+	fakeScanClient
+	fail      bool
+	uploaded  []string
+	passwords []string
+}
+
+func (client *fakeUploadScanClient) UploadDryRun(jobID string, host *Host, dryRunFile string) (*ScanResult, error) {
+	if client.fail {
+		return nil, fmt.Errorf("Black Duck is down")
+	}
+	client.uploaded = append(client.uploaded, filepath.Base(dryRunFile))
+	client.passwords = append(client.passwords, host.Password)
+	return &ScanResult{}, nil
+}
+
+// rotatedCredentials stands in for the manager's lookup of a host's current credentials
+func rotatedCredentials(host *Host) (*Host, error) {
+	return &Host{Scheme: host.Scheme, Domain: host.Domain, Port: host.Port, User: "sysadmin", Password: "rotated-password"}, nil
+}
+
+// enqueueDryRun queues an upload of a dry run directory with one results file
+func enqueueDryRun(t *testing.T, uploader *Uploader, scanName string) (*PendingUpload, error) {
+	dir, err := uploader.NewDryRunDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, scanName+".json"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	upload := &PendingUpload{JobID: scanName, Host: &Host{Domain: "blackduck", User: "sysadmin", Password: "queued-password"}, ScanName: scanName, DryRunDirectory: dir}
+	return upload, uploader.Enqueue(upload)
+}
+
+func TestUploaderEnqueueCaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uploadertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	uploader, err := NewUploader(dir, 1, 2, &fakeUploadScanClient{}, make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scanName := range []string{"scan-1", "scan-2"} {
+		if _, err = enqueueDryRun(t, uploader, scanName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = enqueueDryRun(t, uploader, "scan-3"); err == nil {
+		t.Fatal("expected the third upload to exceed the pending cap")
+	}
+	if uploader.PendingCount() != 2 {
+		t.Fatalf("expected 2 pending uploads, got %d", uploader.PendingCount())
+	}
+
+	// the disk cap applies as well
+	uploader.maxPending = 10
+	if err = ioutil.WriteFile(filepath.Join(dir, "data", "big"), make([]byte, 2*1024*1024), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = enqueueDryRun(t, uploader, "scan-3"); err == nil {
+		t.Fatal("expected the upload to exceed the disk cap")
+	}
+	if uploader.PendingCount() != 2 {
+		t.Fatalf("expected 2 pending uploads, got %d", uploader.PendingCount())
+	}
+}
+
+func TestUploaderRetriesWithBackoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uploadertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	scanClient := &fakeUploadScanClient{fail: true}
+	uploader, err := NewUploader(dir, 100, 10, scanClient, make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploader.credentials = rotatedCredentials
+	first, err := enqueueDryRun(t, uploader, "scan-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = enqueueDryRun(t, uploader, "scan-2"); err != nil {
+		t.Fatal(err)
+	}
+	// the queue on disk says where to upload to, but not with what credentials
+	queued, err := ioutil.ReadDir(filepath.Join(dir, "queue"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range queued {
+		contents, err := ioutil.ReadFile(filepath.Join(dir, "queue", info.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(contents), "queued-password") || !strings.Contains(string(contents), "blackduck") {
+			t.Fatalf("expected only the host's address to be persisted, got %s", contents)
+		}
+	}
+
+	b := newBackoff(uploadInitialBackoff, uploadMaxBackoff)
+	expected := []time.Duration{uploadInitialBackoff, 2 * uploadInitialBackoff, 4 * uploadInitialBackoff}
+	for i, pause := range expected {
+		if actual := uploader.uploadPendingWithBackoff(b); actual != pause {
+			t.Fatalf("expected to pause %s after failure %d, got %s", pause, i+1, actual)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		uploader.uploadPendingWithBackoff(b)
+	}
+	if actual := uploader.uploadPendingWithBackoff(b); actual != uploadMaxBackoff {
+		t.Fatalf("expected the pause to be capped at %s, got %s", uploadMaxBackoff, actual)
+	}
+
+	// the oldest upload blocks the rest, and keeps track of its attempts
+	names, err := uploader.queue.names()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pending PendingUpload
+	if err = uploader.queue.read(names[0], &pending); err != nil {
+		t.Fatal(err)
+	}
+	if pending.ScanName != "scan-1" || pending.Attempts != 14 || pending.LastError == "" {
+		t.Fatalf("expected scan-1 to have failed 14 times, got %+v", pending)
+	}
+	if err = uploader.queue.read(names[1], &pending); err != nil {
+		t.Fatal(err)
+	}
+	if pending.Attempts != 0 {
+		t.Fatalf("expected scan-2 not to have been tried, got %d attempts", pending.Attempts)
+	}
+
+	scanClient.fail = false
+	if actual := uploader.uploadPendingWithBackoff(b); actual != uploadPause {
+		t.Fatalf("expected to pause %s after uploading, got %s", uploadPause, actual)
+	}
+	if len(scanClient.uploaded) != 2 || scanClient.uploaded[0] != "scan-1.json" || scanClient.uploaded[1] != "scan-2.json" {
+		t.Fatalf("expected both scans to be uploaded in order, got %v", scanClient.uploaded)
+	}
+	if !reflect.DeepEqual(scanClient.passwords, []string{"rotated-password", "rotated-password"}) {
+		t.Fatalf("expected the current credentials to be used, got %v", scanClient.passwords)
+	}
+	if uploader.PendingCount() != 0 {
+		t.Fatalf("expected no pending uploads, got %d", uploader.PendingCount())
+	}
+	if _, err = os.Stat(first.DryRunDirectory); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed", first.DryRunDirectory)
+	}
+	if b.next() != uploadInitialBackoff {
+		t.Fatal("expected the backoff to be reset after uploading")
+	}
+}
+
+func TestFindLocalScanClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scanclienttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err = FindLocalScanClient(dir, OSTypeLinux); err == nil {
+		t.Fatal("expected no scan client to be found")
+	}
+	scanClientInfo := NewScanClientInfo("2018.12.0", dir, OSTypeLinux)
+	if err = os.MkdirAll(filepath.Dir(scanClientInfo.ScanCliJarPath()), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(scanClientInfo.ScanCliJarPath(), []byte("jar"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	found, err := FindLocalScanClient(dir, OSTypeLinux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.HubVersion != "2018.12.0" {
+		t.Fatalf("expected version 2018.12.0, got %s", found.HubVersion)
+	}
+}
@@ -22,10 +22,14 @@ under the License.
 package skopeo
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
@@ -37,19 +41,34 @@ import (
 const (
 	dockerSocketPath = "/var/run/docker.sock"
 
-	copyStage = "copy docker image"
-	getStage  = "get docker image"
+	copyStage    = "copy docker image"
+	getStage     = "get docker image"
+	inspectStage = "inspect docker image"
 )
 
 // ImagePuller contains the http Docker client and the secured Docker registry credentials
 type ImagePuller struct {
-	registries []*common.RegistryAuth
+	credentials *common.RegistryCredentials
+	registries  []*common.RegistryConfig
+	// certificates are copied into here, since skopeo takes a directory of them
+	certsDirectory string
+	// closing stop kills any skopeo process that's running
+	stop <-chan struct{}
+	// resolvedDigests holds the digest that each pull spec was resolved to
+	// before it was copied, until ResolveDigest is asked for it
+	resolvedDigests map[string]string
+	mutex           sync.Mutex
 }
 
 // NewImagePuller returns the Image puller type
-func NewImagePuller(registries []*common.RegistryAuth) *ImagePuller {
+func NewImagePuller(credentials *common.RegistryCredentials, registries []*common.RegistryConfig, stop <-chan struct{}) *ImagePuller {
 	log.Infof("creating Skopeo image puller")
-	return &ImagePuller{registries: registries}
+	return &ImagePuller{
+		credentials:     credentials,
+		registries:      registries,
+		certsDirectory:  filepath.Join(os.TempDir(), "skopeo-certs"),
+		stop:            stop,
+		resolvedDigests: map[string]string{}}
 }
 
 // PullImage gives us access to a docker image by:
@@ -83,37 +102,21 @@ func (ip *ImagePuller) CreateImageInLocalDocker(image imageInterface.Image) erro
 	dockerPullSpec := image.DockerPullSpec()
 	log.Infof("Attempting to create %s ......", dockerPullSpec)
 
-	authHeader := ip.needAuthHeader(image)
-	var headerValue string
-	if strings.Compare(authHeader, "") != 0 {
-		headerValue = fmt.Sprintf("--src-creds=%s", authHeader)
-	}
-
-	var cmd *exec.Cmd
-	if len(headerValue) > 0 {
-		cmd = exec.Command("skopeo",
-			"--insecure-policy",
-			"--tls-verify=false",
-			"copy",
-			headerValue,
-			fmt.Sprintf("docker://%s", dockerPullSpec),
-			fmt.Sprintf("docker-daemon:%s", dockerPullSpec))
-	} else {
-		cmd = exec.Command("skopeo",
-			"--insecure-policy",
-			"--tls-verify=false",
-			"copy",
-			fmt.Sprintf("docker://%s", dockerPullSpec),
-			fmt.Sprintf("docker-daemon:%s", dockerPullSpec))
-	}
-
-	log.Infof("running skopeo copy command %+v", cmd)
-	stdoutStderr, err := cmd.CombinedOutput()
+	args, err := ip.copyArgs(image, fmt.Sprintf("docker-daemon:%s", dockerPullSpec))
+	if err != nil {
+		common.RecordDockerError(copyStage, "unable to set up skopeo arguments", image, err)
+		return errors.Annotatef(err, "Create failed for image %s", dockerPullSpec)
+	}
+	cmd := exec.Command("skopeo", args...)
+
+	log.Infof("running skopeo copy command %s", common.Redact(strings.Join(cmd.Args, " ")))
+	stdoutStderr, err := ip.runCommand(cmd)
 
 	if err != nil {
+		ip.forgetDigest(image)
 		common.RecordDockerError(copyStage, "skopeo copy failed", image, err)
 		log.Errorf("skopeo copy command failed for %s with error %s and output:\n%s\n", dockerPullSpec, err.Error(), string(stdoutStderr))
-		return errors.Annotatef(err, "Create failed for image %s", dockerPullSpec)
+		return commandError(err, image, stdoutStderr, "Create failed for image %s", dockerPullSpec)
 	}
 
 	common.RecordDockerCreateDuration(time.Now().Sub(start))
@@ -130,40 +133,24 @@ func (ip *ImagePuller) SaveImageToTar(image imageInterface.Image) error {
 	dockerPullSpec := image.DockerPullSpec()
 	log.Infof("Attempting to create %s ......", dockerPullSpec)
 
-	authHeader := ip.needAuthHeader(image)
-	var headerValue string
-	if strings.Compare(authHeader, "") != 0 {
-		headerValue = fmt.Sprintf("--src-creds=%s", authHeader)
-	}
-
 	tarFilePath := image.DockerTarFilePath()
 
-	var cmd *exec.Cmd
-	if len(headerValue) > 0 {
-		cmd = exec.Command("skopeo",
-			"--insecure-policy",
-			"--tls-verify=false",
-			"copy",
-			headerValue,
-			fmt.Sprintf("docker://%s", dockerPullSpec),
-			fmt.Sprintf("docker-archive:%s", tarFilePath))
-	} else {
-		cmd = exec.Command("skopeo",
-			"--insecure-policy",
-			"--tls-verify=false",
-			"copy",
-			fmt.Sprintf("docker://%s", dockerPullSpec),
-			fmt.Sprintf("docker-archive:%s", tarFilePath))
+	args, err := ip.copyArgs(image, fmt.Sprintf("docker-archive:%s", tarFilePath))
+	if err != nil {
+		common.RecordDockerError(copyStage, "unable to set up skopeo arguments", image, err)
+		return errors.Annotatef(err, "Create failed for image %s", dockerPullSpec)
 	}
+	cmd := exec.Command("skopeo", args...)
 
-	log.Infof("running skopeo copy command %+v", cmd)
+	log.Infof("running skopeo copy command %s", common.Redact(strings.Join(cmd.Args, " ")))
 
-	stdoutStderr, err := cmd.CombinedOutput()
+	stdoutStderr, err := ip.runCommand(cmd)
 
 	if err != nil {
+		ip.forgetDigest(image)
 		common.RecordDockerError(copyStage, "skopeo copy failed", image, err)
 		log.Errorf("skopeo copy command failed for %s with error: %s, stdouterr: %s", dockerPullSpec, err.Error(), string(stdoutStderr))
-		return errors.Annotatef(err, "Create failed for image %s", dockerPullSpec)
+		return commandError(err, image, stdoutStderr, "Create failed for image %s", dockerPullSpec)
 	}
 
 	common.RecordDockerGetDuration(time.Now().Sub(start))
@@ -173,24 +160,126 @@ func (ip *ImagePuller) SaveImageToTar(image imageInterface.Image) error {
 	return err
 }
 
-// needAuthHeader will determine whether the secured registry credentials to be passed to the skopeo client for docker pull
-func (ip *ImagePuller) needAuthHeader(image imageInterface.Image) string {
-	var headerValue string
+// copyArgs returns the arguments for copying the image from its registry to
+// destination.  The image is copied by digest, so that what's copied is what
+// ResolveDigest says it is, even if its tag moves in the meantime.
+func (ip *ImagePuller) copyArgs(image imageInterface.Image, destination string) ([]string, error) {
+	tlsArgs, err := ip.tlsArgs(image, "--src-")
+	if err != nil {
+		return nil, err
+	}
+	authArgs, err := ip.authArgs(image, "--src-")
+	if err != nil {
+		return nil, err
+	}
+	source, err := ip.sourcePullSpec(image)
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"--insecure-policy", "copy"}, tlsArgs...)
+	args = append(args, authArgs...)
+	return append(args, fmt.Sprintf("docker://%s", source), destination), nil
+}
+
+// sourcePullSpec returns the image's pull spec with its digest, resolving
+// its tag first if it doesn't have one
+func (ip *ImagePuller) sourcePullSpec(image imageInterface.Image) (string, error) {
 	dockerPullSpec := image.DockerPullSpec()
-	if registryAuth := common.NeedsAuthHeader(image, ip.registries); registryAuth != nil {
-		headerValue = fmt.Sprintf("%s:%s", registryAuth.User, registryAuth.Password)
+	if common.DigestOf(dockerPullSpec) != "" {
+		return dockerPullSpec, nil
+	}
+	digest, err := ip.inspectDigest(image)
+	if err != nil {
+		return "", err
+	}
+	ip.mutex.Lock()
+	ip.resolvedDigests[dockerPullSpec] = digest
+	ip.mutex.Unlock()
+	return common.RepositoryOf(dockerPullSpec) + "@" + digest, nil
+}
+
+// forgetDigest drops the digest resolved for a copy that failed
+func (ip *ImagePuller) forgetDigest(image imageInterface.Image) {
+	ip.mutex.Lock()
+	delete(ip.resolvedDigests, image.DockerPullSpec())
+	ip.mutex.Unlock()
+}
+
+// tlsArgs returns the TLS flags for the image's registry: certificates are
+// verified unless the registry is configured as insecure.  flagPrefix is
+// --src- for copy, and -- for inspect.
+func (ip *ImagePuller) tlsArgs(image imageInterface.Image, flagPrefix string) ([]string, error) {
+	config := common.FindRegistryConfig(image.DockerPullSpec(), ip.registries)
+	args := []string{fmt.Sprintf("%stls-verify=%t", flagPrefix, !config.Insecure)}
+	if config.HasCertificates() {
+		dir := filepath.Join(ip.certsDirectory, common.RegistryHost(image.DockerPullSpec()))
+		if err := config.InstallCertificates(dir); err != nil {
+			return nil, errors.Trace(err)
+		}
+		args = append(args, fmt.Sprintf("%scert-dir=%s", flagPrefix, dir))
+	}
+	return args, nil
+}
+
+// runCommand runs cmd and returns its combined output, killing it if stop is closed first
+func (ip *ImagePuller) runCommand(cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return output.Bytes(), err
+	case <-ip.stop:
+		log.Warnf("killing skopeo command %s", common.Redact(strings.Join(cmd.Args, " ")))
+		if err := cmd.Process.Kill(); err != nil {
+			log.Errorf("unable to kill skopeo command: %s", err.Error())
+		}
+		<-done
+		return output.Bytes(), fmt.Errorf("skopeo command was interrupted")
+	}
+}
 
-		common.RecordEvent("add auth header")
-		log.Debugf("adding auth header for %s", dockerPullSpec)
+// commandError annotates the error of a failed skopeo command, unless its
+// output says that the registry is throttling us
+func commandError(err error, image imageInterface.Image, output []byte, format string, args ...interface{}) error {
+	if common.IsTooManyRequests(string(output)) {
+		return common.NewTooManyRequestsError(image.DockerPullSpec(), nil, string(output))
+	}
+	return errors.Annotatef(err, format, args...)
+}
 
-		// // the -n prevents echo from appending a newline
-		// fmt.Printf("XRA=`echo -n \"{ \\\"username\\\": \\\"%s\\\", \\\"password\\\": \\\"%s\\\" }\" | base64 --wrap=0`\n", ip.dockerUser, ip.dockerPassword)
-		// fmt.Printf("curl -i --unix-socket /var/run/docker.sock -X POST -d \"\" -H \"X-Registry-Auth: %s\" %s\n", headerValue, imageURL)
-	} else {
+// authArgs returns the credential flags for the image's registry: a registry
+// token is passed as is, and a user and password as creds.  skopeo has no way
+// to exchange an identity token, so a registry with nothing else is an error
+// rather than a pull with empty credentials.  flagPrefix is --src- for copy,
+// and -- for inspect.
+func (ip *ImagePuller) authArgs(image imageInterface.Image, flagPrefix string) ([]string, error) {
+	dockerPullSpec := image.DockerPullSpec()
+	registryAuth := common.NeedsAuthHeader(image, ip.credentials.Registries())
+	if registryAuth == nil {
 		common.RecordEvent("omit auth header")
 		log.Debugf("omitting auth header for %s", dockerPullSpec)
+		return nil, nil
+	}
+	var args []string
+	switch {
+	case registryAuth.RegistryToken != "":
+		args = []string{fmt.Sprintf("%sregistry-token=%s", flagPrefix, registryAuth.RegistryToken)}
+	case registryAuth.User != "" || registryAuth.Password != "":
+		args = []string{fmt.Sprintf("%screds=%s:%s", flagPrefix, registryAuth.User, registryAuth.Password)}
+	default:
+		return nil, fmt.Errorf("the credentials for registry %s only have an identity token, which skopeo can't use", registryAuth.URL)
 	}
-	return headerValue
+	common.RecordEvent("add auth header")
+	log.Debugf("adding auth header for %s", dockerPullSpec)
+	return args, nil
 }
 
 // recordTarFileSize will record the TAR file size
@@ -210,3 +299,63 @@ func (ip *ImagePuller) recordTarFileSize(image imageInterface.Image) error {
 	common.RecordTarFileSize(fileSizeInMBs)
 	return nil
 }
+
+// ResolveDigest returns the digest that the image was copied by, or asks the
+// registry for the digest of its pull spec if it hasn't been copied
+func (ip *ImagePuller) ResolveDigest(image imageInterface.Image) (string, error) {
+	dockerPullSpec := image.DockerPullSpec()
+	ip.mutex.Lock()
+	digest, ok := ip.resolvedDigests[dockerPullSpec]
+	delete(ip.resolvedDigests, dockerPullSpec)
+	ip.mutex.Unlock()
+	if ok {
+		return digest, nil
+	}
+	return ip.inspectDigest(image)
+}
+
+// inspectDigest asks the registry for the digest of the image's pull spec
+func (ip *ImagePuller) inspectDigest(image imageInterface.Image) (string, error) {
+	dockerPullSpec := image.DockerPullSpec()
+	tlsArgs, err := ip.tlsArgs(image, "--")
+	if err != nil {
+		common.RecordDockerError(inspectStage, "unable to set up TLS", image, err)
+		return "", errors.Annotatef(err, "unable to inspect %s", dockerPullSpec)
+	}
+	authArgs, err := ip.authArgs(image, "--")
+	if err != nil {
+		common.RecordDockerError(inspectStage, "unable to set up credentials", image, err)
+		return "", errors.Annotatef(err, "unable to inspect %s", dockerPullSpec)
+	}
+	args := append([]string{"--insecure-policy", "inspect"}, tlsArgs...)
+	args = append(args, authArgs...)
+	args = append(args, fmt.Sprintf("docker://%s", dockerPullSpec))
+	output, err := ip.runCommand(exec.Command("skopeo", args...))
+	if err != nil {
+		common.RecordDockerError(inspectStage, "skopeo inspect failed", image, err)
+		return "", commandError(err, image, output, "skopeo inspect failed for %s: %s", dockerPullSpec, string(output))
+	}
+	var inspection struct {
+		Digest string
+	}
+	if err = json.Unmarshal(output, &inspection); err != nil {
+		return "", errors.Annotatef(err, "unable to decode skopeo inspect output for %s", dockerPullSpec)
+	}
+	if inspection.Digest == "" {
+		return "", fmt.Errorf("skopeo inspect returned no digest for %s", dockerPullSpec)
+	}
+	return inspection.Digest, nil
+}
+
+// RemoveCreatedImage has nothing to do: pulls to tarballs don't go through the
+// docker daemon, and the images created in it are what CreateImagesOnly is for.
+// RemoveCreatedImages is rejected for the skopeo puller.
+func (ip *ImagePuller) RemoveCreatedImage(image imageInterface.Image) error {
+	return nil
+}
+
+// Ping checks that the skopeo binary is available
+func (ip *ImagePuller) Ping() error {
+	_, err := exec.LookPath("skopeo")
+	return errors.Annotatef(err, "unable to find skopeo")
+}
@@ -0,0 +1,126 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package skopeo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blackducksoftware/perceptor-scanner/pkg/common"
+)
+
+func TestAuthArgs(t *testing.T) {
+	credentials := common.NewRegistryCredentials([]*common.RegistryAuth{
+		{URL: "basic.example.com", User: "user", Password: "password"},
+		{URL: "token.example.com", RegistryToken: "token"},
+		{URL: "identity.example.com", IdentityToken: "identity"},
+	}, nil)
+	ip := NewImagePuller(credentials, nil, nil)
+
+	cases := []struct {
+		pullSpec string
+		expected []string
+	}{
+		{"basic.example.com/app:1", []string{"--src-creds=user:password"}},
+		{"token.example.com/app:1", []string{"--src-registry-token=token"}},
+		{"docker.io/library/nginx:1", nil},
+	}
+	for _, c := range cases {
+		args, err := ip.authArgs(common.NewImage("/var/images", c.pullSpec), "--src-")
+		if err != nil {
+			t.Errorf("unexpected error for %s: %s", c.pullSpec, err.Error())
+		}
+		if !reflect.DeepEqual(args, c.expected) {
+			t.Errorf("expected %v for %s, got %v", c.expected, c.pullSpec, args)
+		}
+	}
+
+	if _, err := ip.authArgs(common.NewImage("/var/images", "identity.example.com/app:1"), "--"); err == nil {
+		t.Errorf("expected an error for a registry with only an identity token")
+	}
+}
+
+// fakeSkopeo is a skopeo on the PATH that logs its commands, answers inspects
+// with digest, and writes docker-archive destinations
+const fakeSkopeo = `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/commands"
+for arg; do last="$arg"; done
+case "$2" in
+inspect) echo '{"Digest":"'"$(cat "$(dirname "$0")/digest")"'"}' ;;
+copy) case "$last" in docker-archive:*) echo tarball > "${last#docker-archive:}" ;; esac ;;
+esac
+`
+
+func TestCopiesByResolvedDigest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skopeo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "skopeo"), []byte(fakeSkopeo), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "digest"), []byte("sha256:first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	ip := NewImagePuller(common.NewRegistryCredentials(nil, nil), nil, make(chan struct{}))
+	image := common.NewImage(dir, "registry.example.com/app:1")
+	if err = ip.PullImage(image); err != nil {
+		t.Fatal(err)
+	}
+	// the tag moves after the copy, but the digest is the copied image's
+	if err = ioutil.WriteFile(filepath.Join(dir, "digest"), []byte("sha256:second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := ip.ResolveDigest(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != "sha256:first" {
+		t.Errorf("expected the copied image's digest sha256:first, got %s", digest)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "commands"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(commands) != 2 || !strings.Contains(commands[0], "inspect") {
+		t.Fatalf("expected an inspect and then a copy, got %v", commands)
+	}
+	if !strings.Contains(commands[1], "copy") || !strings.Contains(commands[1], "docker://registry.example.com/app@sha256:first ") {
+		t.Errorf("expected the copy to be by digest, got %s", commands[1])
+	}
+
+	// once it's been asked for, the registry is asked again
+	if digest, err = ip.ResolveDigest(image); err != nil || digest != "sha256:second" {
+		t.Errorf("expected sha256:second from the registry, got %s, %v", digest, err)
+	}
+}